@@ -0,0 +1,183 @@
+package exiflign
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAuditHeader bounds how much of the output is kept to find the metadata
+// it carries, should its first scan never be found.
+const maxAuditHeader = 1 << 20
+
+// AuditRecord is the evidence of what normalizing a single image altered.
+type AuditRecord struct {
+	// Time is when the image was normalized.
+	Time time.Time `json:"time"`
+
+	// Input and Output are the content hashes of the original and of the
+	// normalized image.
+	Input  Hash `json:"input"`
+	Output Hash `json:"output"`
+
+	// Orientation is the orientation tag that was applied, as in Result.
+	Orientation uint16 `json:"orientation"`
+
+	// Suggested is set when Orientation came from Options.Suggester.
+	Suggested bool `json:"suggested,omitempty"`
+
+	// Stripped lists the kinds of metadata the original carries but the
+	// normalized image does not, sorted: "exif", "gps", "xmp", "icc",
+	// "iptc", "jfif", "adobe" and "comment", or "appN" for other APPn
+	// segments.  GPS data redacted under Options.Geofences is listed as
+	// "gps" even though the EXIF data that held it remains.
+	Stripped []string `json:"stripped,omitempty"`
+}
+
+// AuditSink receives an AuditRecord for every image normalized with
+// Options.Audit.  Implementations must be safe for concurrent use.
+type AuditSink interface {
+	Record(rec *AuditRecord) error
+}
+
+// AuditLog is an AuditSink writing each record as a line of JSON, suitable
+// for appending to a file or shipping to a log pipeline.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditLog returns an AuditLog writing to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// Record implements AuditSink.
+func (l *AuditLog) Record(rec *AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
+
+// auditWriter passes the output through to w, hashing it and keeping its
+// marker segments up to the first scan.
+type auditWriter struct {
+	w        io.Writer
+	hash     hash.Hash
+	header   []byte
+	complete bool
+}
+
+// Write implements io.Writer.
+func (a *auditWriter) Write(p []byte) (int, error) {
+	n, err := a.w.Write(p)
+	a.hash.Write(p[:n])
+	if !a.complete {
+		a.header = append(a.header, p[:n]...)
+		a.complete = len(a.header) >= maxAuditHeader || hasScan(a.header)
+	}
+
+	return n, err
+}
+
+// hasScan reports whether header holds a complete JPEG header, up to the
+// start of the first scan.
+func hasScan(header []byte) bool {
+	found := false
+	walkSegments(bytes.NewReader(header), int64(len(header)), func(s segment) bool {
+		found = s.marker == markerSOS
+		return true
+	})
+
+	return found
+}
+
+// normalizeAudited normalizes r and records what was altered in opts.Audit.
+// An error from the sink is returned even though the image was written.
+func normalizeAudited(r io.ReadSeeker, w io.Writer, opts *Options, m *memoryBudget) (*Result, error) {
+	input, err := HashOf(r)
+	if err != nil {
+		return nil, err
+	}
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+	before := metadataKinds(ra, size)
+
+	a := &auditWriter{w: w, hash: sha256.New()}
+	res, err := normalizeOrCached(r, a, opts, m)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &AuditRecord{Time: time.Now(), Input: input, Orientation: res.Orientation, Suggested: res.Suggested}
+	a.hash.Sum(rec.Output[:0])
+	after := metadataKinds(bytes.NewReader(a.header), int64(len(a.header)))
+	if res.GPSRedacted {
+		delete(after, "gps")
+	}
+	for kind := range before {
+		if !after[kind] {
+			rec.Stripped = append(rec.Stripped, kind)
+		}
+	}
+	sort.Strings(rec.Stripped)
+
+	return res, opts.Audit.Record(rec)
+}
+
+// metadataKinds returns the kinds of metadata, as listed for
+// AuditRecord.Stripped, carried by the JPEG image of the given size in r.
+func metadataKinds(r io.ReaderAt, size int64) map[string]bool {
+	kinds := map[string]bool{}
+	walkSegments(r, size, func(s segment) bool {
+		if s.marker == markerCOM {
+			kinds["comment"] = true
+		}
+		if s.marker < markerAPP0 || s.marker > markerAPP15 {
+			return true
+		}
+
+		payload, err := readSegment(r, s)
+		if err != nil {
+			return false
+		}
+		payload = standardExifPayload(s.marker, payload)
+		kind := fmt.Sprintf("app%d", s.marker-markerAPP0)
+		switch {
+		case isExifSegment(s.marker, payload):
+			kind = "exif"
+			x, err := parseExif(payload[len(exifHeader):])
+			if err == nil && x.GPS != nil {
+				kinds["gps"] = true
+			}
+		case s.marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader):
+			kind = "xmp"
+		case s.marker == markerAPP2 && bytes.HasPrefix(payload, iccHeader):
+			kind = "icc"
+		case s.marker == markerAPP13 && bytes.HasPrefix(payload, photoshopHeader):
+			kind = "iptc"
+		case s.marker == markerAPP0 && hasKnownHeader(s.marker, payload):
+			kind = "jfif"
+		case s.marker == markerAPP14 && bytes.HasPrefix(payload, adobeHeader):
+			kind = "adobe"
+		}
+		kinds[kind] = true
+		return true
+	})
+
+	return kinds
+}
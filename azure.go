@@ -0,0 +1,157 @@
+package exiflign
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// azureVersion is the version of the Blob service REST API requests are made
+// with, the first to support Put Blob From URL.
+const azureVersion = "2020-04-08"
+
+// AzureBlobStorage is a Storage over a container of Azure Blob Storage,
+// accessed through its REST API with a shared access signature.  Objects are
+// stored as block blobs, read into memory when opened and held in memory
+// until closed when created.
+type AzureBlobStorage struct {
+	// Endpoint is the base URL of the storage account, such as
+	// "https://myaccount.blob.core.windows.net".
+	Endpoint string
+
+	// Container is the name of the container.
+	Container string
+
+	// SAS is the shared access signature requests are authorized with,
+	// the query string of a SAS URL with or without its leading "?".  It
+	// must grant list, read, write, create and delete permissions on the
+	// container.  If empty, requests are not authorized, which only suits
+	// public containers and emulators.
+	SAS string
+
+	// Client is the HTTP client requests are made with.  If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// azureListResult is the response to a List Blobs request.
+type azureListResult struct {
+	Blobs struct {
+		Blob []struct {
+			Name string
+		}
+	}
+	NextMarker string
+}
+
+// List implements Storage.
+func (s *AzureBlobStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result azureListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range result.Blobs.Blob {
+			names = append(names, b.Name)
+		}
+
+		if result.NextMarker == "" {
+			break
+		}
+		query.Set("marker", result.NextMarker)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Open implements Storage, reading the whole blob into memory.
+func (s *AzureBlobStorage) Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	return readObject(s.do(ctx, http.MethodGet, name, nil, nil, nil))
+}
+
+// Create implements Storage.  The blob is uploaded when the writer is closed.
+func (s *AzureBlobStorage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &uploadWriter{upload: func(data []byte) error {
+		header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}
+		resp, err := s.do(ctx, http.MethodPut, name, nil, header, data)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}}, nil
+}
+
+// Rename implements Storage by copying oldName to newName with Put Blob From
+// URL, which completes synchronously, and deleting oldName, since Blob
+// Storage has no rename operation.
+func (s *AzureBlobStorage) Rename(ctx context.Context, oldName, newName string) error {
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}, "X-Ms-Copy-Source": {s.url(oldName, nil)}}
+	resp, err := s.do(ctx, http.MethodPut, newName, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	resp, err = s.do(ctx, http.MethodDelete, oldName, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// url returns the URL of the blob name, or of the container if name is
+// empty, with query and the shared access signature.
+func (s *AzureBlobStorage) url(name string, query url.Values) string {
+	u := strings.TrimSuffix(s.Endpoint, "/") + "/" + url.PathEscape(s.Container)
+	if name != "" {
+		u += "/" + strings.ReplaceAll(url.PathEscape(cleanName(name)), "%2F", "/")
+	}
+
+	q := query.Encode()
+	if sas := strings.TrimPrefix(s.SAS, "?"); sas != "" && q != "" {
+		q += "&" + sas
+	} else if sas != "" {
+		q = sas
+	}
+	if q != "" {
+		u += "?" + q
+	}
+
+	return u
+}
+
+// do performs a request for the blob name, or for the container if name is
+// empty, returning an error unless it succeeds.
+func (s *AzureBlobStorage) do(ctx context.Context, method, name string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(name, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("X-Ms-Version", azureVersion)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return checkStatus(client.Do(req))
+}
@@ -0,0 +1,146 @@
+package exiflign
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// backupHeader identifies the APP15 segments holding the original EXIF data
+// under Options.BackupExif.  Like ICC profile chunks, it is followed by a
+// sequence number and the count of segments.
+var backupHeader = []byte("exiflign EXIF\x00")
+
+var NoExifBackupError error = errors.New("The given file does not contain an EXIF backup.")
+
+// maxBackupChunk is the largest part of an EXIF backup that fits in a single
+// APP15 segment, after the identifier and the sequence number and count
+// bytes.
+const maxBackupChunk = maxSegmentPayload - 16
+
+// isBackupSegment reports whether the segment holds part of an EXIF backup.
+func isBackupSegment(marker byte, payload []byte) bool {
+	return marker == markerAPP15 && len(payload) >= len(backupHeader)+2 && bytes.HasPrefix(payload, backupHeader)
+}
+
+// readExifBackupAt reassembles the EXIF backup of the JPEG image of the given
+// size in r, the payload of the original APP1 segment including its
+// identifier.
+func readExifBackupAt(r io.ReaderAt, size int64) ([]byte, error) {
+	var chunks [][]byte
+	var serr error
+
+	err := walkSegments(r, size, func(s segment) bool {
+		if s.marker != markerAPP15 {
+			return true
+		}
+
+		var payload []byte
+		payload, serr = readSegment(r, s)
+		if serr != nil {
+			return false
+		}
+		if isBackupSegment(s.marker, payload) {
+			chunks = append(chunks, payload[len(backupHeader):])
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i][0] < chunks[j][0]
+	})
+	var backup []byte
+	for i, c := range chunks {
+		if int(c[0]) != i+1 || int(c[1]) != len(chunks) {
+			return nil, NoExifBackupError
+		}
+		backup = append(backup, c[2:]...)
+	}
+	if !isExifSegment(markerAPP1, backup) {
+		return nil, NoExifBackupError
+	}
+
+	return backup, nil
+}
+
+// backupSegments returns the APP15 segments backing up the EXIF data of the
+// JPEG image of the given size in r under o.  An image that already carries a
+// backup keeps it, so that the data backed up is always that of the very
+// first original.
+func (o *Options) backupSegments(r io.ReaderAt, size int64) ([]jpegenc.Segment, error) {
+	if !o.BackupExif {
+		return nil, nil
+	}
+
+	exif, err := readExifBackupAt(r, size)
+	if err == NoExifBackupError {
+		exif, err = findExifSegment(r, size)
+	}
+	if err == NoExifError {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	count := (len(exif) + maxBackupChunk - 1) / maxBackupChunk
+	segments := make([]jpegenc.Segment, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := exif[i*maxBackupChunk : min((i+1)*maxBackupChunk, len(exif))]
+
+		payload := make([]byte, 0, len(backupHeader)+2+len(chunk))
+		payload = append(payload, backupHeader...)
+		payload = append(payload, byte(i+1), byte(count))
+		payload = append(payload, chunk...)
+		segments = append(segments, jpegenc.Segment{Marker: markerAPP15, Payload: payload})
+	}
+
+	return segments, nil
+}
+
+// RestoreExif writes the JPEG image in r to w with the EXIF data backed up
+// under Options.BackupExif in place of its own, and without the backup.  The
+// pixels of r have already been corrected, so the orientation of the
+// restored data is set to 1 and its pixel dimensions to those of r; use
+// UndoFile to restore the original pixels as well.  The image is not
+// re-encoded.  NoExifBackupError is returned if r carries no backup.  When
+// finished, the internal position in r will be at io.SeekStart.
+func RestoreExif(r io.ReadSeeker, w io.Writer) error {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	r.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	ra := bytes.NewReader(data)
+	exif, err := readExifBackupAt(ra, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	info, err := GetInfoAt(ra, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	setExifOrientation(exif[len(exifHeader):], 1)
+	setExifDimensions(exif[len(exifHeader):], info.Width, info.Height)
+
+	// The EXIF data of r may span several segments, as may the backup, so
+	// rather than being replaced in place it is dropped and added anew.
+	keep := func(marker byte, payload []byte) ([]byte, bool) {
+		return payload, !isExifSegment(marker, standardExifPayload(marker, payload)) && !isBackupSegment(marker, payload)
+	}
+
+	return jpegenc.Transform(w, data, jpegenc.Transformation{}, keep, exifSegments(exif))
+}
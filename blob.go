@@ -0,0 +1,131 @@
+package exiflign
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore stores blobs under the Hash of their content, as
+// content-addressable storage systems do.
+type BlobStore interface {
+	// Put stores the blob read from r under h, the Hash of its content.
+	// Stores that already hold h may return without reading r.
+	Put(ctx context.Context, h Hash, r io.Reader) error
+}
+
+// NormalizeBlob normalizes the JPEG image in r into store, under the Hash of
+// the normalized image, which it returns.  The hash is computed as the
+// normalized image is written, so it is produced and read only once.  The
+// normalized image is kept in memory until it has been put.
+func NormalizeBlob(ctx context.Context, r io.ReadSeeker, store BlobStore, opts *Options) (Hash, *Result, error) {
+	var buffer bytes.Buffer
+	d := sha256.New()
+	res, err := NormalizeWithOptions(r, io.MultiWriter(&buffer, d), opts)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+
+	var h Hash
+	d.Sum(h[:0])
+
+	return h, res, store.Put(ctx, h, &buffer)
+}
+
+// NormalizeDirBlobs walks the directory tree rooted at src and normalizes
+// every file with a .jpg or .jpeg extension into store with NormalizeBlob.
+// Each file is reported to opts.OnFile with dst set to the hexadecimal Hash
+// of its output, which is how it can be found in store.  Only the Options,
+// Filter and OnFile fields of opts are used.  Files that were quarantined or
+// are filtered out by opts.Filter are not treated as errors.
+func NormalizeDirBlobs(ctx context.Context, src string, store BlobStore, opts *FileOptions) error {
+	if opts == nil {
+		opts = &FileOptions{}
+	}
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !isJPEGName(path) {
+			return nil
+		}
+
+		var dst string
+		var res *Result
+		match, err := opts.Filter.Match(path)
+		if err == nil && !match {
+			err = FilteredError
+		}
+		if err == nil {
+			var h Hash
+			h, res, err = normalizeFileBlob(ctx, path, store, &opts.Options)
+			if err == nil {
+				dst = h.String()
+			}
+		}
+		if opts.OnFile != nil {
+			err = opts.OnFile(path, dst, res, err)
+		}
+		if err == QuarantinedError || err == FilteredError {
+			return nil
+		}
+
+		return err
+	})
+}
+
+// normalizeFileBlob normalizes the JPEG image at path into store.
+func normalizeFileBlob(ctx context.Context, path string, store BlobStore, opts *Options) (Hash, *Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+	defer f.Close()
+
+	return NormalizeBlob(ctx, f, store, opts)
+}
+
+// DirBlobStore is a BlobStore that keeps each blob as a file in the directory
+// it names, under the hexadecimal form of its Hash, in a subdirectory named
+// after the first two digits, as Git does.
+type DirBlobStore string
+
+// Path returns the path of the blob with the given hash in d.
+func (d DirBlobStore) Path(h Hash) string {
+	name := h.String()
+	return filepath.Join(string(d), name[:2], name)
+}
+
+// Put implements BlobStore.  Blobs are written to a temporary file which is
+// then renamed, so a blob is never left partially written.
+func (d DirBlobStore) Put(ctx context.Context, h Hash, r io.Reader) error {
+	path := d.Path(h)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".exiflign-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
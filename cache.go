@@ -0,0 +1,163 @@
+package exiflign
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+)
+
+// Hash identifies the content of an image by its SHA-256 digest.
+type Hash [sha256.Size]byte
+
+// String returns the hexadecimal encoding of h.
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// MarshalText implements encoding.TextMarshaler, encoding h in hexadecimal.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// HashOf reads r to the end and returns the Hash of its content.  When
+// finished, the internal position in r will be at io.SeekStart.
+func HashOf(r io.ReadSeeker) (Hash, error) {
+	var h Hash
+
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return h, err
+	}
+
+	d := sha256.New()
+	_, err = io.Copy(d, r)
+	if err != nil {
+		return h, err
+	}
+	d.Sum(h[:0])
+
+	_, err = r.Seek(0, io.SeekStart)
+	return h, err
+}
+
+// OrientationCache stores previously detected orientations by content hash,
+// so that services which repeatedly handle the same originals only need to
+// scan each of them once.  Implementations must be safe for concurrent use.
+type OrientationCache interface {
+	// GetOrientation returns the orientation tag stored for h, and whether
+	// one was found.  A stored tag of 0 records that the image has no EXIF
+	// orientation information.
+	GetOrientation(h Hash) (uint16, bool)
+
+	// PutOrientation stores tag as the orientation of the image with hash h.
+	PutOrientation(h Hash, tag uint16)
+}
+
+// GetOrientationTagCached behaves as GetOrientationTag, but consults cache
+// before scanning r and stores the result afterwards.  It also returns the
+// content hash of r, which it must compute to use the cache, reading r in
+// full; callers that already know it should use GetOrientationTagByHash.
+// When finished, the internal position in r will be at io.SeekStart.
+func GetOrientationTagCached(r io.ReadSeeker, cache OrientationCache) (uint16, Hash, error) {
+	h, err := HashOf(r)
+	if err != nil {
+		return 0, h, err
+	}
+
+	tag, err := GetOrientationTagByHash(r, h, cache)
+	return tag, h, err
+}
+
+// GetOrientationTagByHash is like GetOrientationTagCached, but takes the
+// Hash identifying r from the caller, such as a digest stored alongside the
+// image or one derived from its ETag, so that a cache hit reads nothing from
+// r.  When finished, the internal position in r will be at io.SeekStart.
+func GetOrientationTagByHash(r io.ReadSeeker, h Hash, cache OrientationCache) (uint16, error) {
+	if tag, ok := cache.GetOrientation(h); ok {
+		if tag == 0 {
+			return 0, NoExifError
+		}
+		return tag, nil
+	}
+
+	tag, err := GetOrientationTag(r)
+	if err != nil && err != NoExifError {
+		return 0, err
+	}
+	cache.PutOrientation(h, tag)
+
+	_, serr := r.Seek(0, io.SeekStart)
+	if serr != nil {
+		return 0, serr
+	}
+
+	return tag, err
+}
+
+// LRUOrientationCache is an in-memory OrientationCache that holds a fixed
+// number of entries, evicting the least recently used entry when full.  It is
+// safe for concurrent use.
+type LRUOrientationCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[Hash]*list.Element
+}
+
+type lruOrientationEntry struct {
+	hash Hash
+	tag  uint16
+}
+
+// NewLRUOrientationCache creates an LRUOrientationCache holding at most size
+// entries.
+func NewLRUOrientationCache(size int) *LRUOrientationCache {
+	return &LRUOrientationCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[Hash]*list.Element),
+	}
+}
+
+// GetOrientation implements OrientationCache.
+func (c *LRUOrientationCache) GetOrientation(h Hash) (uint16, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[h]
+	if !ok {
+		return 0, false
+	}
+	c.order.MoveToFront(e)
+
+	return e.Value.(*lruOrientationEntry).tag, true
+}
+
+// PutOrientation implements OrientationCache.
+func (c *LRUOrientationCache) PutOrientation(h Hash, tag uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[h]; ok {
+		e.Value.(*lruOrientationEntry).tag = tag
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[h] = c.order.PushFront(&lruOrientationEntry{h, tag})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.entries, e.Value.(*lruOrientationEntry).hash)
+	}
+}
+
+// Len returns the number of entries currently held by c.
+func (c *LRUOrientationCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,251 @@
+package exiflign
+
+import (
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// MismatchConfidence is the confidence above which CheckOrientation reports a
+// mismatch between the orientation tag and the pixel content.
+const MismatchConfidence = 0.5
+
+// checkGridSize is the number of cells along the longest side of the
+// luminance grid used by the orientation heuristics.
+const checkGridSize = 48
+
+// OrientationCheck is the result of comparing an image's orientation tag
+// against a heuristic reading of its pixel content.
+type OrientationCheck struct {
+	// Tag is the orientation tag recorded in the image, or 1 if it has none.
+	Tag uint16
+
+	// Suggested is the orientation tag that the heuristic considers most
+	// likely to produce an upright image.  The heuristic cannot tell mirrored
+	// images apart, so Suggested always keeps the mirroring of Tag and only
+	// differs from it in rotation.
+	Suggested uint16
+
+	// Confidence is a value between 0 and 1 describing how strongly the
+	// heuristic prefers Suggested over Tag.  It is 0 when they are equal.
+	Confidence float64
+
+	// Mismatch is set when Suggested differs from Tag and Confidence is at
+	// least MismatchConfidence.
+	Mismatch bool
+}
+
+// CheckOrientation decodes the JPEG image in r and estimates whether applying
+// its orientation tag produces an upright image.  This is useful for catching
+// files whose pixels have already been rotated by some other tool but whose
+// tag was left in place, which Normalize would otherwise rotate a second time.
+// The heuristic is statistical and works best on photographs of natural
+// scenes, which tend to be brighter at the top than at the bottom; treat the
+// result as advice rather than fact.  When finished, the internal position in
+// r will be at io.SeekStart.
+func CheckOrientation(r io.ReadSeeker) (*OrientationCheck, error) {
+	tag, err := GetOrientationTag(r)
+	if err == NoExifError {
+		tag = 1
+	} else if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := jpeg.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	check := CheckImageOrientation(img, tag)
+	return &check, nil
+}
+
+// CheckImageOrientation performs the same check as CheckOrientation on an
+// already decoded img whose orientation tag is tag.  img must be the raw,
+// untransformed pixels as stored in the file.
+func CheckImageOrientation(img image.Image, tag uint16) OrientationCheck {
+	if tag < 1 || tag > 8 {
+		tag = 1
+	}
+
+	scores := uprightScores(TransformForTag(img, tag))
+
+	best := 0
+	for rot := 1; rot < 4; rot++ {
+		if scores[rot] > scores[best] {
+			best = rot
+		}
+	}
+
+	check := OrientationCheck{Tag: tag, Suggested: tag}
+	if best == 0 {
+		return check
+	}
+
+	check.Suggested = rotateTag(tag, best)
+	check.Confidence = 1 - math.Exp(-(scores[best] - scores[0]))
+	check.Mismatch = check.Confidence >= MismatchConfidence
+
+	return check
+}
+
+// uprightScores rates how upright img would appear after being rotated
+// clockwise by 0, 90, 180 and 270 degrees respectively.  Each score is the
+// difference in mean luminance between the top and bottom thirds of the
+// rotated image, measured in standard deviations of the whole image.
+func uprightScores(img image.Image) [4]float64 {
+	grid, w, h := luminanceGrid(img, checkGridSize)
+
+	var sum, sumSq float64
+	for _, v := range grid {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(grid))
+	stddev := math.Sqrt(math.Max(sumSq/n-(sum/n)*(sum/n), 0)) + 1e-6
+
+	band := func(x0, y0, x1, y1 int) float64 {
+		var total float64
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				total += grid[y*w+x]
+			}
+		}
+		return total / float64((x1-x0)*(y1-y0))
+	}
+
+	tw, th := max(w/3, 1), max(h/3, 1)
+	top := band(0, 0, w, th)
+	bottom := band(0, h-th, w, h)
+	left := band(0, 0, tw, h)
+	right := band(w-tw, 0, w, h)
+
+	return [4]float64{
+		(top - bottom) / stddev,
+		(left - right) / stddev,
+		(bottom - top) / stddev,
+		(right - left) / stddev,
+	}
+}
+
+// luminanceGrid averages the luminance of img over a grid of cells whose
+// longest side has size cells, returning the cells in row-major order along
+// with the grid dimensions.  Luminance values are in the range [0, 1].
+func luminanceGrid(img image.Image, size int) ([]float64, int, int) {
+	b := img.Bounds()
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = max(size*b.Dy()/b.Dx(), 1)
+	} else {
+		w = max(size*b.Dx()/b.Dy(), 1)
+	}
+	w, h = min(w, b.Dx()), min(h, b.Dy())
+
+	grid := make([]float64, w*h)
+	for gy := 0; gy < h; gy++ {
+		y0, y1 := b.Min.Y+gy*b.Dy()/h, b.Min.Y+(gy+1)*b.Dy()/h
+		for gx := 0; gx < w; gx++ {
+			x0, x1 := b.Min.X+gx*b.Dx()/w, b.Min.X+(gx+1)*b.Dx()/w
+
+			// Sample at most a 4x4 lattice of pixels per cell.
+			sx, sy := max((x1-x0)/4, 1), max((y1-y0)/4, 1)
+			var total float64
+			var count int
+			for y := y0; y < y1; y += sy {
+				for x := x0; x < x1; x += sx {
+					r, g, bl, _ := img.At(x, y).RGBA()
+					total += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+					count++
+				}
+			}
+			grid[gy*w+gx] = total / float64(count) / 0xffff
+		}
+	}
+
+	return grid, w, h
+}
+
+// DoubleOrientationCheck is the evidence gathered by CheckDoubleOrientation
+// that an image's orientation has already been applied to its pixels.
+type DoubleOrientationCheck struct {
+	// Tag is the orientation tag recorded in the image, or 1 if it has none.
+	Tag uint16
+
+	// DimensionsSwapped is set when the tag describes a quarter turn and the
+	// pixel dimensions recorded in the EXIF data are those of the frame with
+	// width and height exchanged, which happens when a tool rotates the
+	// pixels without updating the metadata.
+	DimensionsSwapped bool
+
+	// Confidence is a value between 0 and 1 describing how strongly the pixel
+	// content suggests that the image is already upright without applying
+	// Tag.  Tags that only mirror the image cannot be judged from content,
+	// and have a Confidence of 0.
+	Confidence float64
+
+	// Suspect is set when DimensionsSwapped is set or Confidence is at least
+	// MismatchConfidence, meaning the file should be reviewed by hand rather
+	// than normalized.
+	Suspect bool
+}
+
+// CheckDoubleOrientation estimates whether some earlier tool rotated the
+// pixels of the JPEG image in r but left its orientation tag in place, as old
+// image viewers commonly did, so that normalizing it would rotate it a second
+// time.  It combines the metadata left behind by such tools with the content
+// heuristic of CheckOrientation, and shares its caveats.  When finished, the
+// internal position in r will be at io.SeekStart.
+func CheckDoubleOrientation(r io.ReadSeeker) (*DoubleOrientationCheck, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+	info, err := GetInfoAt(ra, size)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &DoubleOrientationCheck{Tag: 1}
+	if !info.HasExif || info.Orientation < 2 || info.Orientation > 8 {
+		_, err = r.Seek(0, io.SeekStart)
+		return check, err
+	}
+	check.Tag = info.Orientation
+
+	x, err := ReadExifAt(ra, size)
+	if err == nil && check.Tag >= 5 && x.PixelXDimension != x.PixelYDimension &&
+		x.PixelXDimension == info.Height && x.PixelYDimension == info.Width {
+		check.DimensionsSwapped = true
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := uprightScores(img)[0]
+	tagged := uprightScores(TransformForTag(img, check.Tag))[0]
+	if raw > tagged {
+		check.Confidence = 1 - math.Exp(-(raw - tagged))
+	}
+	check.Suspect = check.DimensionsSwapped || check.Confidence >= MismatchConfidence
+
+	return check, nil
+}
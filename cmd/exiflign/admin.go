@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/luke-park/exiflign"
+)
+
+// serveStats counts the requests served by the serve command since it
+// started, across reloads.
+type serveStats struct {
+	started     time.Time
+	inFlight    atomic.Int64
+	requests    atomic.Int64
+	succeeded   atomic.Int64
+	quarantined atomic.Int64
+	rejected    atomic.Int64
+	failed      atomic.Int64
+	bytes       atomic.Int64
+}
+
+// debugStats is the response of /debug/stats.
+type debugStats struct {
+	Uptime      string               `json:"uptime"`
+	InFlight    int64                `json:"in_flight"`
+	Requests    int64                `json:"requests"`
+	Succeeded   int64                `json:"succeeded"`
+	Quarantined int64                `json:"quarantined"`
+	Rejected    int64                `json:"rejected"`
+	Failed      int64                `json:"failed"`
+	Bytes       int64                `json:"bytes"`
+	Cache       *exiflign.CacheStats `json:"cache,omitempty"`
+}
+
+// record counts a finished request whose response went through w.
+// Quarantined images count separately from other client errors.
+func (s *serveStats) record(w *statusWriter) {
+	s.requests.Add(1)
+	s.bytes.Add(w.bytes)
+	switch {
+	case w.status == http.StatusUnprocessableEntity:
+		s.quarantined.Add(1)
+	case w.status >= 500:
+		s.failed.Add(1)
+	case w.status >= 400:
+		s.rejected.Add(1)
+	default:
+		s.succeeded.Add(1)
+	}
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for use by
+// http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveDebug serves the administrative endpoints of s under the
+// configuration h: GET /debug/stats reports what s has served and how its
+// cache is used, and POST /debug/cache/flush empties the cache.  They answer
+// 404 Not Found unless h has an admin token, and 401 Unauthorized to
+// requests that do not carry it.
+func (s *server) serveDebug(w http.ResponseWriter, r *http.Request, h *serveHandler) {
+	if h.adminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/debug/stats" && r.Method == http.MethodGet:
+		stats := &debugStats{
+			Uptime:      time.Since(s.stats.started).Round(time.Second).String(),
+			InFlight:    s.stats.inFlight.Load(),
+			Requests:    s.stats.requests.Load(),
+			Succeeded:   s.stats.succeeded.Load(),
+			Quarantined: s.stats.quarantined.Load(),
+			Rejected:    s.stats.rejected.Load(),
+			Failed:      s.stats.failed.Load(),
+			Bytes:       s.stats.bytes.Load(),
+		}
+		if h.cache != nil {
+			cs := h.cache.Stats()
+			stats.Cache = &cs
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	case r.URL.Path == "/debug/cache/flush" && r.Method == http.MethodPost:
+		if h.cache != nil {
+			h.cache.Flush()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
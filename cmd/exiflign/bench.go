@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luke-park/exiflign"
+)
+
+var benchCommand = &command{
+	name:        "bench",
+	usage:       "bench [-n iterations] [-workers n] [-stages list] <file or dir>...",
+	summary:     "measure detection, lossless and re-encoding throughput over sample images",
+	run:         runBench,
+	defineFlags: func(fs *flag.FlagSet) { defineBenchFlags(fs) },
+}
+
+// benchStage is a single measured step of the normalization pipeline.
+type benchStage struct {
+	name string
+	run  func(r io.ReadSeeker) error
+}
+
+var benchStages = []benchStage{
+	{"detect", func(r io.ReadSeeker) error {
+		_, err := exiflign.GetOrientationTag(r)
+		if err == exiflign.NoExifError {
+			return nil
+		}
+		return err
+	}},
+	{"normalize", func(r io.ReadSeeker) error {
+		return exiflign.Normalize(r, io.Discard)
+	}},
+	{"lossless", func(r io.ReadSeeker) error {
+		_, err := exiflign.NormalizeWithOptions(r, io.Discard, &exiflign.Options{Mode: exiflign.ModeLossless})
+		return err
+	}},
+	// The remaining stages re-encode every image, including untagged ones,
+	// to compare the codecs: image/jpeg, the internal encoder used to keep
+	// the tables and subsampling of the original, and image/jpeg with
+	// Options.Decoders falling back to every format registered with package
+	// image, for samples that are not JPEG images despite their names.
+	{"reencode", func(r io.ReadSeeker) error {
+		return benchReencode(r, &exiflign.Options{})
+	}},
+	{"preserve", func(r io.ReadSeeker) error {
+		return benchReencode(r, &exiflign.Options{PreserveQuantization: true, PreserveSubsampling: true})
+	}},
+	{"fallback", func(r io.ReadSeeker) error {
+		return benchReencode(r, &exiflign.Options{Decoders: []exiflign.Decoder{exiflign.DecoderFunc(func(r io.Reader) (image.Image, error) {
+			img, _, err := image.Decode(r)
+			return img, err
+		})}})
+	}},
+}
+
+// benchReencode normalizes the image in r under opts with a hook that
+// returns the image as it is, which forces it through decoding and
+// re-encoding.
+func benchReencode(r io.ReadSeeker, opts *exiflign.Options) error {
+	opts.Hook = func(img image.Image, x *exiflign.Exif) (image.Image, error) {
+		return img, nil
+	}
+
+	_, err := exiflign.NormalizeWithOptions(r, io.Discard, opts)
+	return err
+}
+
+// benchFlags holds the flags of the bench command.
+type benchFlags struct {
+	iterations *int
+	workers    *int
+	stages     *string
+}
+
+// defineBenchFlags defines the flags of the bench command on fs.
+func defineBenchFlags(fs *flag.FlagSet) *benchFlags {
+	return &benchFlags{
+		iterations: fs.Int("n", 3, "number of passes over the sample set per stage"),
+		workers:    fs.Int("workers", runtime.GOMAXPROCS(0), "number of concurrent workers"),
+		stages:     fs.String("stages", benchStageNames(), "comma-separated list of the stages to measure"),
+	}
+}
+
+func runBench(c *command, args []string) error {
+	fs := c.flags()
+	f := defineBenchFlags(fs)
+	c.parse(fs, args)
+	if fs.NArg() == 0 || *f.iterations < 1 || *f.workers < 1 {
+		fs.Usage()
+		os.Exit(exitInvalid)
+	}
+
+	stages, err := selectBenchStages(*f.stages)
+	if err != nil {
+		return err
+	}
+
+	samples, err := loadSamples(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no JPEG files found")
+	}
+
+	var total int64
+	for _, s := range samples {
+		total += int64(len(s))
+	}
+	fmt.Printf("%d files, %.1f MB, %d workers, %d passes\n\n", len(samples), float64(total)/1e6, *f.workers, *f.iterations)
+
+	for _, stage := range stages {
+		elapsed, err := benchRun(stage, samples, *f.iterations, *f.workers)
+		if err != nil {
+			return fmt.Errorf("%s: %v", stage.name, err)
+		}
+
+		files := float64(len(samples) * *f.iterations)
+		size := float64(total * int64(*f.iterations))
+		secs := elapsed.Seconds()
+		fmt.Printf("%-10s %10.1f files/s %10.1f MB/s\n", stage.name, files/secs, size/secs/1e6)
+	}
+
+	return nil
+}
+
+// benchStageNames returns the names of every stage, comma-separated.
+func benchStageNames() string {
+	names := make([]string, len(benchStages))
+	for i, stage := range benchStages {
+		names[i] = stage.name
+	}
+
+	return strings.Join(names, ",")
+}
+
+// selectBenchStages returns the stages named in the comma-separated list,
+// in the order given.
+func selectBenchStages(list string) ([]benchStage, error) {
+	var stages []benchStage
+	for _, name := range strings.Split(list, ",") {
+		i := slices.IndexFunc(benchStages, func(stage benchStage) bool {
+			return stage.name == name
+		})
+		if i < 0 {
+			return nil, invalidf("unknown stage %q", name)
+		}
+		stages = append(stages, benchStages[i])
+	}
+
+	return stages, nil
+}
+
+// benchRun runs stage over every sample iterations times, distributing the
+// work over the given number of workers, and returns the wall-clock time.
+func benchRun(stage benchStage, samples [][]byte, iterations, workers int) (time.Duration, error) {
+	jobs := make(chan []byte)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				err := stage.run(bytes.NewReader(s))
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := 0; i < iterations; i++ {
+		for _, s := range samples {
+			select {
+			case jobs <- s:
+			case err = <-errs:
+				break feed
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+
+	return elapsed, err
+}
+
+// loadSamples reads every JPEG named by paths into memory, descending into
+// directories, so that disk throughput does not skew the measurements.
+func loadSamples(paths []string) ([][]byte, error) {
+	var samples [][]byte
+
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			ext := strings.ToLower(filepath.Ext(path))
+			if path != root && ext != ".jpg" && ext != ".jpeg" {
+				return nil
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			samples = append(samples, data)
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return samples, nil
+}
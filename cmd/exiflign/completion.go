@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var completionCommand = &command{
+	name:    "completion",
+	usage:   "completion bash|zsh|fish",
+	summary: "print a shell completion script for exiflign",
+	run:     runCompletion,
+}
+
+func runCompletion(c *command, args []string) error {
+	fs := c.flags()
+	c.parse(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitInvalid)
+	}
+
+	switch fs.Arg(0) {
+	case "bash":
+		writeBashCompletion()
+	case "zsh":
+		writeZshCompletion()
+	case "fish":
+		writeFishCompletion()
+	default:
+		return invalidf("unknown shell %q", fs.Arg(0))
+	}
+
+	return nil
+}
+
+// commandFlags returns the flags of c in lexical order.
+func commandFlags(c *command) []*flag.Flag {
+	var flags []*flag.Flag
+	c.describe().VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+
+	return flags
+}
+
+// isBoolFlag reports whether f takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func writeBashCompletion() {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+
+	fmt.Printf("# bash completion for exiflign\n\n")
+	fmt.Printf("_exiflign() {\n")
+	fmt.Printf("\tlocal cur=${COMP_WORDS[COMP_CWORD]} flags\n")
+	fmt.Printf("\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Printf("\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Printf("\t\treturn\n")
+	fmt.Printf("\tfi\n\n")
+	fmt.Printf("\tcase ${COMP_WORDS[1]} in\n")
+	for _, c := range commands {
+		var flags []string
+		for _, f := range commandFlags(c) {
+			flags = append(flags, "-"+f.Name)
+		}
+		fmt.Printf("\t%s) flags=%q ;;\n", c.name, strings.Join(flags, " "))
+	}
+	fmt.Printf("\tesac\n\n")
+	fmt.Printf("\tif [[ $cur == -* ]]; then\n")
+	fmt.Printf("\t\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	fmt.Printf("\telse\n")
+	fmt.Printf("\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Printf("\tfi\n")
+	fmt.Printf("}\n\n")
+	fmt.Printf("complete -o filenames -F _exiflign exiflign\n")
+}
+
+func writeZshCompletion() {
+	// zshDescription escapes s for use within brackets in a single-quoted
+	// _arguments specification.
+	zshDescription := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace
+
+	fmt.Printf("#compdef exiflign\n\n")
+	fmt.Printf("_exiflign() {\n")
+	fmt.Printf("\tlocal -a commands\n")
+	fmt.Printf("\tcommands=(\n")
+	for _, c := range commands {
+		fmt.Printf("\t\t'%s:%s'\n", c.name, zshDescription(c.summary))
+	}
+	fmt.Printf("\t)\n\n")
+	fmt.Printf("\tif (( CURRENT == 2 )); then\n")
+	fmt.Printf("\t\t_describe 'command' commands\n")
+	fmt.Printf("\t\treturn\n")
+	fmt.Printf("\tfi\n\n")
+	fmt.Printf("\tcase $words[2] in\n")
+	for _, c := range commands {
+		fmt.Printf("\t%s)\n", c.name)
+		fmt.Printf("\t\t_arguments")
+		for _, f := range commandFlags(c) {
+			value := ":value:"
+			if isBoolFlag(f) {
+				value = ""
+			}
+			fmt.Printf(" \\\n\t\t\t'-%s[%s]%s'", f.Name, zshDescription(f.Usage), value)
+		}
+		fmt.Printf(" \\\n\t\t\t'*:file:_files'\n")
+		fmt.Printf("\t\t;;\n")
+	}
+	fmt.Printf("\tesac\n")
+	fmt.Printf("}\n\n")
+	fmt.Printf("_exiflign \"$@\"\n")
+}
+
+func writeFishCompletion() {
+	// fishQuote quotes s as a single-quoted fish string.
+	fishQuote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+	}
+
+	fmt.Printf("# fish completion for exiflign\n\n")
+	for _, c := range commands {
+		fmt.Printf("complete -c exiflign -f -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	for _, c := range commands {
+		for _, f := range commandFlags(c) {
+			requires := " -r"
+			if isBoolFlag(f) {
+				requires = ""
+			}
+			fmt.Printf("complete -c exiflign -n '__fish_seen_subcommand_from %s' -o %s%s -d %s\n", c.name, f.Name, requires, fishQuote(f.Usage))
+		}
+	}
+}
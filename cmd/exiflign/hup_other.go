@@ -0,0 +1,9 @@
+//go:build js || wasip1
+
+package main
+
+import "os"
+
+// notifyHangup does nothing, as WebAssembly hosts deliver no SIGHUP, so the
+// configuration is only reloaded when its file is modified.
+func notifyHangup(c chan<- os.Signal) {}
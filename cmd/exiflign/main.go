@@ -0,0 +1,146 @@
+// Command exiflign is a command line front-end for the exiflign package.
+//
+// Usage:
+//
+//	exiflign <command> [arguments]
+//
+// Run "exiflign help" for the list of available commands.
+//
+// The exit status is 0 on success, 1 on failure, 2 if no input carried EXIF
+// orientation information, 3 if only some inputs failed and 4 if the inputs or
+// arguments were invalid.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Exit codes of the exiflign command.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitNoExif  = 2
+	exitPartial = 3
+	exitInvalid = 4
+)
+
+// statusError is an error that ends the command with a particular exit code.
+type statusError struct {
+	code int
+	err  error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// invalidf returns a statusError with exitInvalid for a usage error.
+func invalidf(format string, args ...any) error {
+	return &statusError{exitInvalid, fmt.Errorf(format, args...)}
+}
+
+// command describes a single exiflign subcommand.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(c *command, args []string) error
+
+	// defineFlags, if non-nil, defines the flags of the command on fs, as
+	// run does before parsing its arguments.
+	defineFlags func(fs *flag.FlagSet)
+}
+
+// describe returns the flag set of c, with its flags defined but not parsed,
+// without running it.
+func (c *command) describe() *flag.FlagSet {
+	fs := c.flags()
+	if c.defineFlags != nil {
+		c.defineFlags(fs)
+	}
+
+	return fs
+}
+
+// flags returns a new flag set for c whose usage message is derived from the
+// command definition.
+func (c *command) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: exiflign %s\n\n%s.\n\n", c.usage, c.summary)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parse parses args with fs, exiting with exitInvalid if they are not valid.
+func (c *command) parse(fs *flag.FlagSet, args []string) {
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		os.Exit(exitOK)
+	} else if err != nil {
+		os.Exit(exitInvalid)
+	}
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		normalizeCommand,
+		statsCommand,
+		benchCommand,
+		serveCommand,
+		undoCommand,
+		workerCommand,
+		completionCommand,
+		manCommand,
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitInvalid)
+	}
+	if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "--help" {
+		usage()
+		os.Exit(exitOK)
+	}
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			err := c.run(c, os.Args[2:])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "exiflign %s: %v\n", c.name, err)
+				code := exitFailure
+				var s *statusError
+				if errors.As(err, &s) {
+					code = s.code
+				}
+				os.Exit(code)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "exiflign: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(exitInvalid)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n\n\texiflign <command> [arguments]\n\nCommands:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var manCommand = &command{
+	name:    "man",
+	usage:   "man",
+	summary: "print the manual page of exiflign in roff format",
+	run:     runMan,
+}
+
+// roff escapes s for use as text in a roff document.
+var roff = strings.NewReplacer(`\`, `\e`, "-", `\-`, "\n.", "\n\\&.", "\n'", "\n\\&'").Replace
+
+func runMan(c *command, args []string) error {
+	fs := c.flags()
+	c.parse(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitInvalid)
+	}
+
+	fmt.Printf(".TH EXIFLIGN 1\n")
+	fmt.Printf(".SH NAME\n")
+	fmt.Printf("exiflign \\- correct the orientation of JPEG images tagged with an EXIF orientation\n")
+	fmt.Printf(".SH SYNOPSIS\n")
+	fmt.Printf(".B exiflign\n")
+	fmt.Printf(".I command\n")
+	fmt.Printf("[\\fIarguments\\fR]\n")
+	fmt.Printf(".SH COMMANDS\n")
+	for _, c := range commands {
+		fmt.Printf(".SS %s\n", c.name)
+		fmt.Printf(".B exiflign %s\n", roff(c.usage))
+		fmt.Printf(".PP\n")
+		fmt.Printf("%s.\n", roff(strings.ToUpper(c.summary[:1])+c.summary[1:]))
+		for _, f := range commandFlags(c) {
+			fmt.Printf(".TP\n")
+			if isBoolFlag(f) {
+				fmt.Printf(".B \\-%s\n", roff(f.Name))
+			} else {
+				fmt.Printf(".BI \\-%s \" value\"\n", roff(f.Name))
+			}
+			fmt.Printf("%s", roff(f.Usage))
+			if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+				fmt.Printf(" (default %s)", roff(f.DefValue))
+			}
+			fmt.Printf(".\n")
+		}
+	}
+	fmt.Printf(".SH EXIT STATUS\n")
+	fmt.Printf(".TP\n.B 0\nSuccess.\n")
+	fmt.Printf(".TP\n.B 1\nFailure.\n")
+	fmt.Printf(".TP\n.B 2\nNo input carried EXIF orientation information.\n")
+	fmt.Printf(".TP\n.B 3\nSome, but not all, inputs failed.\n")
+	fmt.Printf(".TP\n.B 4\nThe inputs or arguments were invalid.\n")
+
+	return nil
+}
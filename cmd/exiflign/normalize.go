@@ -0,0 +1,309 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/luke-park/exiflign"
+)
+
+// webhookTimeout bounds how long a single webhook notification may take.
+const webhookTimeout = 30 * time.Second
+
+var normalizeCommand = &command{
+	name:        "normalize",
+	usage:       "normalize [flags] <src> [dst]",
+	summary:     "normalize a JPEG file or directory of JPEGs, in place if dst is omitted",
+	run:         runNormalize,
+	defineFlags: func(fs *flag.FlagSet) { defineNormalizeFlags(fs) },
+}
+
+// normalizeFlags holds the flags of the normalize command.
+type normalizeFlags struct {
+	stamp              *bool
+	verify             *bool
+	exact              *bool
+	minPSNR            *float64
+	minSSIM            *float64
+	suggest            *bool
+	document           *bool
+	lossless           *bool
+	perfect            *bool
+	trim               *bool
+	auto               *bool
+	maxOutput          *int64
+	timeout            *time.Duration
+	segments           *string
+	comments           *string
+	comment            *string
+	sidecar            *bool
+	quarantine         *string
+	shiftTime          *time.Duration
+	template           *string
+	duplicates         *string
+	format             *string
+	webhook            *string
+	webhookSecret      *string
+	webhookBatch       *bool
+	lenient            *bool
+	minWidth           *int
+	minHeight          *int
+	maxWidth           *int
+	maxHeight          *int
+	minMegapixels      *float64
+	maxMegapixels      *float64
+	minBytes           *int64
+	maxBytes           *int64
+	exif               *string
+	preserveAttributes *bool
+	collisions         *string
+	followSymlinks     *bool
+	since              *string
+	until              *string
+	timeSource         *string
+}
+
+// defineNormalizeFlags defines the flags of the normalize command on fs.
+func defineNormalizeFlags(fs *flag.FlagSet) *normalizeFlags {
+	return &normalizeFlags{
+		stamp:              fs.Bool("stamp", false, "stamp written files with an extended attribute and skip stamped inputs"),
+		verify:             fs.Bool("verify", false, "compare each output against its original before replacing dst"),
+		exact:              fs.Bool("exact", false, "with -verify, require outputs to match pixel for pixel"),
+		minPSNR:            fs.Float64("min-psnr", exiflign.DefaultMinPSNR, "with -verify, the minimum acceptable PSNR in dB"),
+		minSSIM:            fs.Float64("min-ssim", exiflign.DefaultMinSSIM, "with -verify, the minimum acceptable structural similarity"),
+		suggest:            fs.Bool("suggest", false, "guess the orientation of images without EXIF data from their content"),
+		document:           fs.Bool("document", false, "with -suggest, guess orientations from the text lines of scanned documents and receipts rather than from scenery"),
+		lossless:           fs.Bool("lossless", false, "transform baseline JPEGs without re-encoding them where possible"),
+		perfect:            fs.Bool("perfect", false, "transform JPEGs without re-encoding them, failing for those that cannot be"),
+		trim:               fs.Bool("trim", false, "with -lossless, -auto or -perfect, drop the partial blocks at the edges of images that could otherwise not be transformed losslessly"),
+		auto:               fs.Bool("auto", false, "choose between rewriting the orientation tag, lossless transformation and re-encoding for each image from its headers"),
+		maxOutput:          fs.Int64("max-output-bytes", 0, "lower the quality of re-encoded images as far as needed for them to take at most this many bytes"),
+		timeout:            fs.Duration("timeout", 0, "give up on images that take longer than this to normalize, such as 30s"),
+		segments:           fs.String("segments", "all", "with -lossless, the APPn and COM segments to keep: all, known or none"),
+		comments:           fs.String("comments", "default", "what to do with JPEG comments: default, keep or strip"),
+		comment:            fs.String("comment", "", "replace the comments of every output with this text"),
+		sidecar:            fs.Bool("sidecar", false, "write a JSON file describing the change and the original EXIF data next to each output"),
+		quarantine:         fs.String("quarantine", "", "copy suspicious files, such as those with invalid EXIF data or absurd dimensions, to this directory instead of normalizing them"),
+		shiftTime:          fs.Duration("shift-time", 0, "add this duration, such as -9h, to the EXIF timestamps of every output"),
+		template:           fs.String("output-template", "", "write each output to this path under dst, with fields such as {date}, {camera}, {orientation}, {hash}, {name} and {ext} filled in"),
+		duplicates:         fs.String("duplicates", "ignore", "what to do with files duplicating an earlier one, even if rotated: ignore, report or skip"),
+		format:             fs.String("format", "text", "how to report results: text, printing failures to stderr, or json, printing a line per file to stdout"),
+		webhook:            fs.String("webhook", "", "POST the JSON result of every file to this URL"),
+		webhookSecret:      fs.String("webhook-secret", "", "with -webhook, sign every notification with this key"),
+		webhookBatch:       fs.Bool("webhook-batch", false, "with -webhook, POST the results of all files at once at the end of the run"),
+		lenient:            fs.Bool("lenient", false, "read orientations whose EXIF byte order marker contradicts the data in whichever byte order makes sense"),
+		minWidth:           fs.Int("min-width", 0, "only normalize images at least this many pixels wide, as displayed"),
+		minHeight:          fs.Int("min-height", 0, "only normalize images at least this many pixels high, as displayed"),
+		maxWidth:           fs.Int("max-width", 0, "only normalize images at most this many pixels wide, as displayed"),
+		maxHeight:          fs.Int("max-height", 0, "only normalize images at most this many pixels high, as displayed"),
+		minMegapixels:      fs.Float64("min-megapixels", 0, "only normalize images of at least this many megapixels"),
+		maxMegapixels:      fs.Float64("max-megapixels", 0, "only normalize images of at most this many megapixels"),
+		minBytes:           fs.Int64("min-bytes", 0, "only normalize files of at least this many bytes"),
+		maxBytes:           fs.Int64("max-bytes", 0, "only normalize files of at most this many bytes"),
+		exif:               fs.String("exif", "any", "only normalize files whose EXIF orientation is: any, present or absent"),
+		preserveAttributes: fs.Bool("preserve-attributes", false, "copy extended attributes, such as Finder tags, or alternate data streams on Windows, to each output"),
+		collisions:         fs.String("collisions", "overwrite", "what to do when an output already exists: overwrite, skip, version, writing photo-1.jpg and so on, or error"),
+		followSymlinks:     fs.Bool("follow-symlinks", false, "follow symbolic links when normalizing a directory, rather than skipping them"),
+		since:              fs.String("since", "", "only normalize files dated at or after this time: a date, an RFC 3339 time or a duration ago, such as 24h"),
+		until:              fs.String("until", "", "only normalize files dated before this time, in the same forms as -since"),
+		timeSource:         fs.String("time-source", "modified", "the date -since and -until compare: modified, the file's modification time, or taken, its EXIF date"),
+	}
+}
+
+func runNormalize(c *command, args []string) error {
+	fs := c.flags()
+	f := defineNormalizeFlags(fs)
+	c.parse(fs, args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(exitInvalid)
+	}
+
+	src, dst := fs.Arg(0), fs.Arg(0)
+	if fs.NArg() == 2 {
+		dst = fs.Arg(1)
+	}
+
+	opts := &exiflign.FileOptions{Stamp: *f.stamp, Sidecar: *f.sidecar, QuarantineDir: *f.quarantine}
+	opts.LenientEndianness = *f.lenient
+	opts.TimeShift = *f.shiftTime
+	opts.OutputTemplate = *f.template
+	opts.FollowSymlinks = *f.followSymlinks
+	opts.PreserveAttributes = *f.preserveAttributes
+	if *f.quarantine != "" {
+		opts.QuarantinePolicy = exiflign.BasicQuarantinePolicy{}
+	}
+	if *f.suggest {
+		opts.Suggester = exiflign.HorizonSuggester{}
+		if *f.document {
+			opts.Suggester = exiflign.DocumentSuggester{}
+		}
+	}
+	if *f.lossless {
+		opts.Mode = exiflign.ModeLossless
+	}
+	if *f.auto {
+		opts.Mode = exiflign.ModeAuto
+	}
+	if *f.perfect {
+		opts.Mode = exiflign.ModePerfect
+	}
+	opts.Trim = *f.trim
+	opts.MaxBytes = *f.maxOutput
+	opts.Timeout = *f.timeout
+	switch *f.segments {
+	case "all":
+		opts.Segments = exiflign.KeepAll
+	case "known":
+		opts.Segments = exiflign.KeepKnown
+	case "none":
+		opts.Segments = exiflign.StripAll
+	default:
+		return invalidf("unknown segment policy %q", *f.segments)
+	}
+	switch *f.comments {
+	case "default":
+	case "keep":
+		opts.Comments = exiflign.CommentsPreserve
+	case "strip":
+		opts.Comments = exiflign.CommentsStrip
+	default:
+		return invalidf("unknown comment policy %q", *f.comments)
+	}
+	if *f.comment != "" {
+		opts.Comments, opts.Comment = exiflign.CommentsReplace, *f.comment
+	}
+	switch *f.collisions {
+	case "overwrite":
+	case "skip":
+		opts.Collisions = exiflign.CollisionSkip
+	case "version":
+		opts.Collisions = exiflign.CollisionVersion
+	case "error":
+		opts.Collisions = exiflign.CollisionError
+	default:
+		return invalidf("unknown collision policy %q", *f.collisions)
+	}
+	switch *f.duplicates {
+	case "ignore":
+	case "report":
+		opts.Duplicates = exiflign.DuplicatesReport
+	case "skip":
+		opts.Duplicates = exiflign.DuplicatesSkip
+	default:
+		return invalidf("unknown duplicate policy %q", *f.duplicates)
+	}
+	filter := &exiflign.FileFilter{
+		MinWidth:      *f.minWidth,
+		MinHeight:     *f.minHeight,
+		MaxWidth:      *f.maxWidth,
+		MaxHeight:     *f.maxHeight,
+		MinMegapixels: *f.minMegapixels,
+		MaxMegapixels: *f.maxMegapixels,
+		MinBytes:      *f.minBytes,
+		MaxBytes:      *f.maxBytes,
+	}
+	switch *f.exif {
+	case "any":
+	case "present":
+		filter.Exif = exiflign.ExifPresent
+	case "absent":
+		filter.Exif = exiflign.ExifAbsent
+	default:
+		return invalidf("unknown EXIF filter %q", *f.exif)
+	}
+	switch *f.timeSource {
+	case "modified":
+	case "taken":
+		filter.TimeSource = exiflign.TimeTaken
+	default:
+		return invalidf("unknown time source %q", *f.timeSource)
+	}
+	var err error
+	filter.Since, err = parseTimeFlag("since", *f.since)
+	if err != nil {
+		return err
+	}
+	filter.Until, err = parseTimeFlag("until", *f.until)
+	if err != nil {
+		return err
+	}
+	if *filter != (exiflign.FileFilter{TimeSource: filter.TimeSource}) {
+		opts.Filter = filter
+	}
+	opts.OnDuplicate = func(path, original string) {
+		fmt.Fprintf(os.Stderr, "%s: duplicate of %s\n", path, original)
+	}
+	if *f.verify {
+		opts.Verify = &exiflign.VerifyOptions{Exact: *f.exact, MinPSNR: *f.minPSNR, MinSSIM: *f.minSSIM}
+	}
+	rep, err := newReporter(*f.format)
+	if err != nil {
+		return err
+	}
+	opts.OnFile = rep.report
+	rep.skipExisting = opts.Collisions == exiflign.CollisionSkip
+	if *f.webhook != "" {
+		rep.webhook = &exiflign.Webhook{URL: *f.webhook, Secret: *f.webhookSecret, Client: &http.Client{Timeout: webhookTimeout}}
+		rep.batch = *f.webhookBatch
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return &statusError{exitInvalid, err}
+	}
+	if info.IsDir() {
+		err = exiflign.NormalizeDir(src, dst, opts)
+		if err != nil {
+			return err
+		}
+		rep.finish()
+		return rep.status()
+	}
+
+	if *f.template != "" {
+		if fs.NArg() < 2 {
+			return invalidf("-output-template requires dst")
+		}
+		rel, err := exiflign.ExpandOutputTemplate(*f.template, src)
+		if err != nil {
+			return err
+		}
+		dst = filepath.Join(dst, rel)
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = exiflign.NormalizeFile(src, dst, opts)
+	if err != nil {
+		return err
+	}
+	rep.finish()
+
+	return rep.status()
+}
+
+// parseTimeFlag parses the value of the time flag name: a date, an RFC 3339
+// time or a duration before now.  An empty value is the zero time.
+func parseTimeFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, invalidf("invalid -%s time %q", name, value)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io/fs"
+	"os"
+
+	"github.com/luke-park/exiflign"
+)
+
+// fileResult is a single line of the JSON results stream of the normalize
+// command.
+type fileResult struct {
+	Src         string `json:"src"`
+	Dst         string `json:"dst,omitempty"`
+	Status      string `json:"status"`
+	Orientation uint16 `json:"orientation,omitempty"`
+	Suggested   bool   `json:"suggested,omitempty"`
+	Lossless    bool   `json:"lossless,omitempty"`
+	Copied      bool   `json:"copied,omitempty"`
+	Method      string `json:"method,omitempty"`
+	Trimmed     bool   `json:"trimmed,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// batchResult is the notification sent at the end of a normalize run with
+// -webhook-batch.
+type batchResult struct {
+	Files   int          `json:"files"`
+	Failed  int          `json:"failed"`
+	NoExif  int          `json:"no_exif"`
+	Results []fileResult `json:"results"`
+}
+
+// reporter collects the outcome of every file of a normalize run, printing
+// failures to stderr, or every outcome to stdout as JSON lines, and derives
+// the exit status of the run from them.  If webhook is set, every outcome is
+// also sent to it, or all of them at once at the end if batch is set.
+type reporter struct {
+	enc     *json.Encoder
+	webhook *exiflign.Webhook
+	batch   bool
+
+	// skipExisting reports files whose output exists as skipped rather than
+	// failed, under -collisions skip.
+	skipExisting bool
+
+	files, noExif, failed, invalid int
+	results                        []fileResult
+}
+
+// newReporter creates a reporter for the given -format.
+func newReporter(format string) (*reporter, error) {
+	switch format {
+	case "text":
+		return &reporter{}, nil
+	case "json":
+		return &reporter{enc: json.NewEncoder(os.Stdout)}, nil
+	}
+
+	return nil, invalidf("unknown format %q", format)
+}
+
+// report implements exiflign.FileOptions.OnFile.  It always returns nil, so
+// that a run carries on past files that fail.
+func (r *reporter) report(src, dst string, res *exiflign.Result, err error) error {
+	result := fileResult{Src: src, Dst: dst}
+	switch {
+	case err == exiflign.StampedError || err == exiflign.QuarantinedError || err == exiflign.DuplicateError || err == exiflign.FilteredError:
+		result.Status, result.Error = "skipped", err.Error()
+	case err == exiflign.OutputExistsError && r.skipExisting:
+		result.Status, result.Error = "skipped", err.Error()
+	case err != nil:
+		r.files++
+		r.failed++
+		if isInvalidInput(err) {
+			r.invalid++
+		}
+		result.Status, result.Error = "failed", err.Error()
+		if r.enc == nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", src, err)
+		}
+	default:
+		r.files++
+		result.Status = "normalized"
+		if res.NoExif && !res.Suggested {
+			r.noExif++
+			result.Status = "no-exif"
+		}
+		result.Orientation, result.Suggested = res.Orientation, res.Suggested
+		result.Lossless, result.Copied = res.Lossless, res.Copied
+		result.Method, result.Trimmed = res.Method.String(), res.Trimmed
+	}
+
+	if r.webhook != nil && r.batch {
+		r.results = append(r.results, result)
+	} else if r.webhook != nil {
+		r.notify(result)
+	}
+	if r.enc != nil {
+		return r.enc.Encode(result)
+	}
+
+	return nil
+}
+
+// finish sends the batch notification, if there is one.
+func (r *reporter) finish() {
+	if r.webhook != nil && r.batch {
+		r.notify(&batchResult{Files: r.files, Failed: r.failed, NoExif: r.noExif, Results: r.results})
+	}
+}
+
+// notify sends v to the webhook.  Failures are printed to stderr rather than
+// failing the run, since the files have been written regardless.
+func (r *reporter) notify(v any) {
+	err := r.webhook.Post(context.Background(), v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "webhook: %v\n", err)
+	}
+}
+
+// status returns the error, carrying the exit code, that the run should end
+// with, or nil if it succeeded.
+func (r *reporter) status() error {
+	switch {
+	case r.failed == 0 && r.files > 0 && r.noExif == r.files:
+		return &statusError{exitNoExif, errors.New("no file carried EXIF orientation information")}
+	case r.failed == 0:
+		return nil
+	case r.invalid == r.files:
+		return &statusError{exitInvalid, fmt.Errorf("%d of %d files were not valid JPEG images", r.failed, r.files)}
+	case r.failed < r.files:
+		return &statusError{exitPartial, fmt.Errorf("%d of %d files failed", r.failed, r.files)}
+	}
+
+	return fmt.Errorf("%d of %d files failed", r.failed, r.files)
+}
+
+// isInvalidInput reports whether err was caused by the input itself, rather
+// than by a failure to read or write it.
+func isInvalidInput(err error) bool {
+	var format jpeg.FormatError
+	var unsupported jpeg.UnsupportedError
+
+	return errors.As(err, &format) || errors.As(err, &unsupported) || errors.Is(err, fs.ErrNotExist) ||
+		err == exiflign.InvalidTIFFError || err == exiflign.MemoryBudgetExceededError
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/luke-park/exiflign"
+)
+
+var serveCommand = &command{
+	name:        "serve",
+	usage:       "serve [-addr addr | -socket path [-socket-mode mode]] [-tls-cert file -tls-key file [-tls-client-ca file]] [-config file] [-shutdown-timeout d] <dir>",
+	summary:     "serve a directory over HTTP, normalizing JPEGs as they are requested",
+	run:         runServe,
+	defineFlags: func(fs *flag.FlagSet) { defineServeFlags(fs) },
+}
+
+// configPollInterval is how often the configuration file is checked for
+// changes.
+const configPollInterval = 2 * time.Second
+
+// serveConfig holds the settings of the serve command that can be changed
+// while it is running, read from the JSON file given by -config.
+type serveConfig struct {
+	// Quality is the JPEG quality of re-encoded images.
+	Quality int `json:"quality"`
+
+	// PreserveExif, PreserveICC and PreserveIPTC carry the respective
+	// metadata of the originals into re-encoded images.
+	PreserveExif bool `json:"preserve_exif"`
+	PreserveICC  bool `json:"preserve_icc"`
+	PreserveIPTC bool `json:"preserve_iptc"`
+
+	// Comments is what to do with JPEG comments: default, keep or strip.
+	Comments string `json:"comments"`
+
+	// Concurrency is the number of requests served at once.  If zero, it is
+	// the number of CPUs.
+	Concurrency int `json:"concurrency"`
+
+	// Quarantine refuses to serve suspicious images, such as those with
+	// invalid EXIF data or absurd dimensions, and QuarantineDir, if set, is
+	// where copies of them are stored for inspection.
+	Quarantine    bool   `json:"quarantine"`
+	QuarantineDir string `json:"quarantine_dir"`
+
+	// Webhook, if set, is a URL that the audit record of every normalized
+	// image is POSTed to, signed with WebhookSecret if that is set.
+	Webhook       string `json:"webhook"`
+	WebhookSecret string `json:"webhook_secret"`
+
+	// Rescan lists directories and buckets that are periodically scanned for
+	// images that have not been normalized yet, which are normalized in
+	// place, for storage that cannot report new files as they arrive.
+	Rescan []rescanConfig `json:"rescan"`
+
+	// MaxUploadBytes, MinQuality, MaxQuality, MaxSize and AllowMetadata
+	// bound what callers of POST /normalize may send and override, as
+	// described by exiflign.NormalizeHandlerOptions.
+	MaxUploadBytes int64 `json:"max_upload_bytes"`
+	MinQuality     int   `json:"min_quality"`
+	MaxQuality     int   `json:"max_quality"`
+	MaxSize        int   `json:"max_size"`
+	AllowMetadata  bool  `json:"allow_metadata"`
+
+	// CacheBytes, if positive, is the size of an in-memory cache of
+	// normalized files.  The cache is emptied on reload.
+	CacheBytes int64 `json:"cache_bytes"`
+
+	// AdminToken enables the /debug endpoints for requests that carry it as
+	// a bearer token.  If empty, they are disabled.
+	AdminToken string `json:"admin_token"`
+}
+
+// rescanConfig describes a directory or bucket to re-scan.  Exactly one of
+// Dir and S3 is set.
+type rescanConfig struct {
+	// Dir is a directory whose images are normalized in place and stamped
+	// with exiflign.Stamp, so that it needs extended attributes.
+	Dir string `json:"dir"`
+
+	// S3 is a bucket whose images are normalized in place and marked with
+	// stamp objects.  The credentials are read from AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY.
+	S3 *rescanS3Config `json:"s3"`
+
+	// Interval is how long to wait between scans, such as "5m".
+	Interval string `json:"interval"`
+
+	interval time.Duration
+}
+
+// rescanS3Config locates the objects of an S3-compatible bucket.
+type rescanS3Config struct {
+	Endpoint      string `json:"endpoint"`
+	Region        string `json:"region"`
+	Bucket        string `json:"bucket"`
+	Prefix        string `json:"prefix"`
+	VirtualHosted bool   `json:"virtual_hosted"`
+}
+
+// loadServeConfig reads the configuration file at path, or returns the
+// default configuration if path is empty.
+func loadServeConfig(path string) (*serveConfig, error) {
+	cfg := &serveConfig{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if cfg.Quality < 0 || cfg.Quality > 100 {
+		return nil, fmt.Errorf("quality %d is not between 1 and 100", cfg.Quality)
+	}
+	if cfg.MinQuality < 0 || cfg.MaxQuality > 100 || cfg.MinQuality > cfg.MaxQuality {
+		return nil, fmt.Errorf("quality bounds %d to %d are invalid", cfg.MinQuality, cfg.MaxQuality)
+	}
+	if cfg.MaxSize < 0 {
+		return nil, fmt.Errorf("max_size %d is negative", cfg.MaxSize)
+	}
+	if cfg.CacheBytes < 0 {
+		return nil, fmt.Errorf("cache_bytes %d is negative", cfg.CacheBytes)
+	}
+	if cfg.Concurrency < 0 {
+		return nil, fmt.Errorf("concurrency %d is negative", cfg.Concurrency)
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = runtime.GOMAXPROCS(0)
+	}
+	for i := range cfg.Rescan {
+		rc := &cfg.Rescan[i]
+		if (rc.Dir == "") == (rc.S3 == nil) {
+			return nil, fmt.Errorf("rescan %d must have exactly one of dir and s3", i)
+		}
+		d, err := time.ParseDuration(rc.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("rescan %d: %v", i, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("rescan %d: interval %v is not positive", i, d)
+		}
+		rc.interval = d
+	}
+
+	return cfg, nil
+}
+
+// options returns the normalization options described by cfg.
+func (cfg *serveConfig) options() (*exiflign.Options, error) {
+	opts := &exiflign.Options{
+		Quality:      cfg.Quality,
+		PreserveExif: cfg.PreserveExif,
+		PreserveICC:  cfg.PreserveICC,
+		PreserveIPTC: cfg.PreserveIPTC,
+	}
+	switch cfg.Comments {
+	case "", "default":
+	case "keep":
+		opts.Comments = exiflign.CommentsPreserve
+	case "strip":
+		opts.Comments = exiflign.CommentsStrip
+	default:
+		return nil, fmt.Errorf("unknown comment policy %q", cfg.Comments)
+	}
+	if cfg.Webhook != "" {
+		opts.Audit = webhookSink{&exiflign.Webhook{URL: cfg.Webhook, Secret: cfg.WebhookSecret, Client: &http.Client{Timeout: webhookTimeout}}}
+	}
+	if cfg.Quarantine || cfg.QuarantineDir != "" {
+		opts.QuarantinePolicy = exiflign.BasicQuarantinePolicy{}
+		opts.Quarantine = func(r io.Reader, reason string) error {
+			return quarantine(cfg.QuarantineDir, r, reason)
+		}
+	}
+
+	return opts, nil
+}
+
+// quarantine logs that an image was quarantined for reason and, if dir is
+// set, stores a copy of it read from r there.
+func quarantine(dir string, r io.Reader, reason string) error {
+	if dir == "" {
+		log.Printf("quarantined an image: %s", reason)
+		return nil
+	}
+
+	f, err := os.CreateTemp(dir, "*.jpg")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	log.Printf("quarantined an image as %s: %s", f.Name(), reason)
+
+	return err
+}
+
+// webhookSink sends audit records to a webhook in the background, so that
+// requests are not held up by it, logging failures rather than failing the
+// request that produced them.
+type webhookSink struct {
+	*exiflign.Webhook
+}
+
+// Record implements exiflign.AuditSink.
+func (s webhookSink) Record(rec *exiflign.AuditRecord) error {
+	go func() {
+		err := s.Webhook.Record(rec)
+		if err != nil {
+			log.Printf("webhook: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// serveHandler serves requests with a single configuration, with files and
+// POST /normalize with upload.  cache, if non-nil, is the cache of h.
+type serveHandler struct {
+	h          http.Handler
+	upload     http.Handler
+	slots      chan struct{}
+	cache      *exiflign.MemoryCache
+	adminToken string
+}
+
+// server is the handler of the serve command.  Its configuration is replaced
+// as a whole on reload, and every request is served to completion by the
+// configuration that was current when it arrived, so reloading never drops
+// or alters requests in flight.  Requests in flight under the previous
+// configuration do not count towards the concurrency of the new one.
+type server struct {
+	dir     string
+	config  string
+	current atomic.Pointer[serveHandler]
+	ready   atomic.Bool
+	stats   serveStats
+
+	// stopRescans stops the re-scans of the current configuration.  It is
+	// only used by reload, which is never run concurrently.
+	stopRescans context.CancelFunc
+}
+
+// ServeHTTP implements http.Handler.  /readyz reports whether s has a warmed
+// up configuration, for orchestrators to hold traffic back until it does,
+// POST /normalize answers with the normalized image in the request body, and
+// /debug/ holds the endpoints of serveDebug.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/readyz" {
+		if !s.ready.Load() {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	h := s.current.Load()
+	if h == nil {
+		http.Error(w, "warming up", http.StatusServiceUnavailable)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/debug/") {
+		s.serveDebug(w, r, h)
+		return
+	}
+
+	s.stats.inFlight.Add(1)
+	defer s.stats.inFlight.Add(-1)
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	defer s.stats.record(sw)
+	w = sw
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-r.Context().Done():
+		return
+	}
+	defer func() { <-h.slots }()
+
+	if r.URL.Path == "/normalize" && r.Method == http.MethodPost {
+		h.upload.ServeHTTP(w, r)
+		return
+	}
+	h.h.ServeHTTP(w, r)
+}
+
+// reload reads the configuration file of s, warms its codecs up and starts
+// serving new requests with it.  If the configuration is invalid or cannot
+// normalize images, s keeps its current one.
+func (s *server) reload() error {
+	cfg, err := loadServeConfig(s.config)
+	if err != nil {
+		return err
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		return err
+	}
+	n, err := exiflign.NewNormalizer(opts)
+	if err != nil {
+		return err
+	}
+	err = n.WarmUp()
+	if err != nil {
+		return fmt.Errorf("warming up: %v", err)
+	}
+
+	var cache *exiflign.MemoryCache
+	var rc exiflign.ResultCache
+	if cfg.CacheBytes > 0 {
+		cache = exiflign.NewMemoryCache(cfg.CacheBytes)
+		rc = cache
+	}
+
+	s.current.Store(&serveHandler{
+		h: n.FileServer(os.DirFS(s.dir), rc),
+		upload: exiflign.NormalizeHandler(&exiflign.NormalizeHandlerOptions{
+			Options:       *opts,
+			MaxBytes:      cfg.MaxUploadBytes,
+			MinQuality:    cfg.MinQuality,
+			MaxQuality:    cfg.MaxQuality,
+			MaxSize:       cfg.MaxSize,
+			AllowMetadata: cfg.AllowMetadata,
+		}),
+		slots:      make(chan struct{}, cfg.Concurrency),
+		cache:      cache,
+		adminToken: cfg.AdminToken,
+	})
+	s.ready.Store(true)
+
+	if s.stopRescans != nil {
+		s.stopRescans()
+	}
+	var ctx context.Context
+	ctx, s.stopRescans = context.WithCancel(context.Background())
+	for _, rc := range cfg.Rescan {
+		go rescan(ctx, rc, opts)
+	}
+
+	return nil
+}
+
+// rescan normalizes the images of the directory or bucket described by rc
+// with opts every rc.interval until ctx is done, starting straight away.
+// Images already normalized by an earlier scan are skipped.  Failures are
+// logged and do not stop later scans.
+func rescan(ctx context.Context, rc rescanConfig, opts *exiflign.Options) {
+	ticker := time.NewTicker(rc.interval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		if rc.Dir != "" {
+			err = rescanDir(rc.Dir, opts)
+		} else {
+			err = rescanS3(ctx, rc.S3, opts)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("rescan: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rescanDir normalizes the images of dir that have not been stamped.
+func rescanDir(dir string, opts *exiflign.Options) error {
+	return exiflign.NormalizeDir(dir, dir, &exiflign.FileOptions{
+		Options: *opts,
+		Stamp:   true,
+		OnFile: func(src, dst string, res *exiflign.Result, err error) error {
+			if err != nil && err != exiflign.StampedError && err != exiflign.QuarantinedError {
+				log.Printf("rescan: %s: %v", src, err)
+			}
+			return nil
+		},
+	})
+}
+
+// rescanS3 normalizes the images of the bucket described by cfg that have no
+// stamp object.
+func rescanS3(ctx context.Context, cfg *rescanS3Config, opts *exiflign.Options) error {
+	store := &exiflign.S3Storage{
+		Endpoint:        cfg.Endpoint,
+		Region:          cfg.Region,
+		Bucket:          cfg.Bucket,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		VirtualHosted:   cfg.VirtualHosted,
+	}
+
+	return exiflign.NormalizeStorageStamped(ctx, store, store, cfg.Prefix, opts)
+}
+
+// watch reloads the configuration of s whenever the process receives SIGHUP
+// or the configuration file is modified.  Failed reloads are logged.
+func (s *server) watch() {
+	hup := make(chan os.Signal, 1)
+	notifyHangup(hup)
+
+	var modified time.Time
+	if info, err := os.Stat(s.config); err == nil {
+		modified = info.ModTime()
+	}
+	ticker := time.NewTicker(configPollInterval)
+
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			info, err := os.Stat(s.config)
+			if s.config == "" || err != nil || info.ModTime().Equal(modified) {
+				continue
+			}
+			modified = info.ModTime()
+		}
+
+		err := s.reload()
+		if err != nil {
+			log.Printf("reloading configuration: %v", err)
+			continue
+		}
+		log.Printf("reloaded configuration")
+	}
+}
+
+// serveFlags holds the flags of the serve command.
+type serveFlags struct {
+	addr        *string
+	config      *string
+	grace       *time.Duration
+	socket      *string
+	socketMode  *string
+	tlsCert     *string
+	tlsKey      *string
+	tlsClientCA *string
+}
+
+// defineServeFlags defines the flags of the serve command on fs.
+func defineServeFlags(fs *flag.FlagSet) *serveFlags {
+	return &serveFlags{
+		addr:        fs.String("addr", ":8080", "the address to listen on"),
+		config:      fs.String("config", "", "a JSON configuration file, reloaded on SIGHUP or when it changes"),
+		grace:       fs.Duration("shutdown-timeout", 30*time.Second, "how long to wait for requests in flight on SIGTERM"),
+		socket:      fs.String("socket", "", "listen on this unix socket instead of -addr"),
+		socketMode:  fs.String("socket-mode", "0660", "the permissions of the -socket file, in octal"),
+		tlsCert:     fs.String("tls-cert", "", "serve over TLS with this PEM certificate chain"),
+		tlsKey:      fs.String("tls-key", "", "the PEM private key of -tls-cert"),
+		tlsClientCA: fs.String("tls-client-ca", "", "require client certificates signed by one of the PEM certificates in this file"),
+	}
+}
+
+func runServe(c *command, args []string) error {
+	fs := c.flags()
+	f := defineServeFlags(fs)
+	c.parse(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitInvalid)
+	}
+	mode, err := strconv.ParseUint(*f.socketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return invalidf("invalid socket mode %q", *f.socketMode)
+	}
+	if (*f.tlsCert == "") != (*f.tlsKey == "") {
+		return invalidf("-tls-cert and -tls-key must be given together")
+	}
+	if *f.tlsClientCA != "" && *f.tlsCert == "" {
+		return invalidf("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+
+	info, err := os.Stat(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", fs.Arg(0))
+	}
+
+	// The configuration is validated up front, but warmed up once the server
+	// is listening, which /readyz reports on.
+	s := &server{dir: fs.Arg(0), config: *f.config}
+	s.stats.started = time.Now()
+	cfg, err := loadServeConfig(s.config)
+	if err == nil {
+		_, err = cfg.options()
+	}
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := s.reload()
+		if err != nil {
+			log.Fatalf("starting: %v", err)
+		}
+		s.watch()
+	}()
+
+	var ln net.Listener
+	if *f.socket != "" {
+		ln, err = listenUnix(*f.socket, os.FileMode(mode))
+	} else {
+		ln, err = net.Listen("tcp", *f.addr)
+	}
+	if err != nil {
+		return err
+	}
+	if *f.tlsCert != "" {
+		cfg, err := tlsConfig(*f.tlsCert, *f.tlsKey, *f.tlsClientCA)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, cfg)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	return serve(ctx, &http.Server{Handler: s}, ln, *f.grace)
+}
+
+// listenUnix listens on the unix socket at path, with the given permissions.
+// A socket left behind at path by an earlier run is replaced, but any other
+// file is not.  The socket is removed when the listener is closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// tlsConfig returns the TLS configuration of a server with the certificate
+// chain and private key in the PEM files cert and key.  If clientCA is set,
+// clients must present a certificate signed by one of the PEM certificates
+// in it.
+func tlsConfig(cert, key, clientCA string) (*tls.Config, error) {
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		data, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s holds no PEM certificates", clientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// serve runs srv on ln until ctx is done, then shuts it down gracefully: it
+// stops accepting connections and waits up to grace for the requests in
+// flight to finish before closing their connections.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, grace time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down, waiting up to %v for requests in flight", grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		srv.Close()
+		return fmt.Errorf("requests still in flight after %v were abandoned", grace)
+	}
+
+	return err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/luke-park/exiflign"
+)
+
+var statsCommand = &command{
+	name:    "stats",
+	usage:   "stats <dir>",
+	summary: "report orientation statistics for a directory of JPEGs",
+	run:     runStats,
+}
+
+func runStats(c *command, args []string) error {
+	fs := c.flags()
+	c.parse(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitInvalid)
+	}
+
+	s, err := exiflign.ScanStats(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("files:          %d\n", s.Files)
+	fmt.Printf("no exif:        %d\n", s.NoExif)
+	fmt.Printf("errors:         %d\n", s.Errors)
+	fmt.Printf("little-endian:  %d\n", s.LittleEndian)
+	fmt.Printf("big-endian:     %d\n", s.BigEndian)
+	fmt.Println()
+	for tag := 1; tag <= 8; tag++ {
+		fmt.Printf("orientation %d:  %d\n", tag, s.Orientations[tag])
+	}
+	fmt.Println()
+	fmt.Printf("re-encode files:  %d\n", s.ReencodeFiles)
+	fmt.Printf("re-encode bytes:  %d\n", s.ReencodeBytes)
+	fmt.Printf("re-encode pixels: %d (%.1f MP)\n", s.ReencodePixels, float64(s.ReencodePixels)/1e6)
+
+	return nil
+}
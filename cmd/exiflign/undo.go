@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+
+	"github.com/luke-park/exiflign"
+)
+
+var undoCommand = &command{
+	name:    "undo",
+	usage:   "undo <file or dir>...",
+	summary: "revert files normalized with -sidecar to their original orientation",
+	run:     runUndo,
+}
+
+func runUndo(c *command, args []string) error {
+	fs := c.flags()
+	c.parse(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitInvalid)
+	}
+
+	for _, path := range fs.Args() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			err = exiflign.UndoDir(path, nil)
+		} else {
+			err = exiflign.UndoFile(path, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
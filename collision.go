@@ -0,0 +1,68 @@
+package exiflign
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CollisionPolicy controls what NormalizeFile and NormalizeDir do when the
+// output path of a file already exists, other than when normalizing it in
+// place.
+type CollisionPolicy int
+
+const (
+	// CollisionOverwrite replaces the existing file.
+	CollisionOverwrite CollisionPolicy = iota
+
+	// CollisionSkip leaves the existing file alone and skips the file,
+	// which is reported with OutputExistsError and not treated as an error
+	// by NormalizeDir.
+	CollisionSkip
+
+	// CollisionVersion writes the output next to the existing file, under
+	// its name with the first free number added, such as "photo-1.jpg" for
+	// "photo.jpg".
+	CollisionVersion
+
+	// CollisionError fails the file with OutputExistsError.
+	CollisionError
+)
+
+var OutputExistsError error = errors.New("The output file already exists.")
+
+// resolveOutput applies opts.Collisions to dst, the output of src, returning
+// the path to write to.
+func (o *FileOptions) resolveOutput(src, dst string) (string, error) {
+	if o.Collisions == CollisionOverwrite {
+		return dst, nil
+	}
+
+	dstInfo, err := os.Stat(longPath(dst))
+	if os.IsNotExist(err) {
+		return dst, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if srcInfo, err := os.Stat(longPath(src)); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return dst, nil
+	}
+	if o.Collisions != CollisionVersion {
+		return "", OutputExistsError
+	}
+
+	ext := filepath.Ext(dst)
+	base := dst[:len(dst)-len(ext)]
+	for i := 1; ; i++ {
+		versioned := fmt.Sprintf("%s-%d%s", base, i, ext)
+		_, err := os.Stat(longPath(versioned))
+		if os.IsNotExist(err) {
+			return versioned, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package exiflign
+
+import (
+	"io"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// CommentPolicy controls what happens to the COM segments of an image, which
+// hold free-form text comments.
+type CommentPolicy int
+
+const (
+	// CommentsDefault drops comments from re-encoded images, and treats them
+	// as any other segment under Options.Segments when an image is
+	// transformed losslessly.  Images that are copied through keep them.
+	CommentsDefault CommentPolicy = iota
+
+	// CommentsPreserve keeps the comments of the original, including in
+	// re-encoded images.
+	CommentsPreserve
+
+	// CommentsStrip removes all comments, including from images that would
+	// otherwise be copied through unchanged.
+	CommentsStrip
+
+	// CommentsReplace removes all comments and writes Options.Comment in
+	// their place, for example to record how an image was processed.
+	CommentsReplace
+)
+
+// maxSegmentPayload is the largest payload a single marker segment can hold.
+const maxSegmentPayload = 0xffff - 2
+
+// extraComments returns the COM segments to add to every image under o,
+// splitting Options.Comment across as many segments as it needs.
+func (o *Options) extraComments() []jpegenc.Segment {
+	if o.Comments != CommentsReplace {
+		return nil
+	}
+
+	var segments []jpegenc.Segment
+	comment := []byte(o.Comment)
+	for len(comment) > 0 {
+		n := min(len(comment), maxSegmentPayload)
+		segments = append(segments, jpegenc.Segment{Marker: markerCOM, Payload: comment[:n]})
+		comment = comment[n:]
+	}
+
+	return segments
+}
+
+// reencodedComments returns the COM segments to write into a re-encoded
+// version of r under o.
+func (o *Options) reencodedComments(r io.ReadSeeker) ([]jpegenc.Segment, error) {
+	if o.Comments != CommentsPreserve {
+		return o.extraComments(), nil
+	}
+
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []jpegenc.Segment
+	err = walkSegments(ra, size, func(s segment) bool {
+		if s.marker != markerCOM {
+			return true
+		}
+
+		var payload []byte
+		payload, err = readSegment(ra, s)
+		if err != nil {
+			return false
+		}
+		segments = append(segments, jpegenc.Segment{Marker: markerCOM, Payload: payload})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	return segments, err
+}
+
+// segmentWriter inserts marker segments into a JPEG stream immediately after
+// its SOI marker.
+type segmentWriter struct {
+	w        io.Writer
+	segments []jpegenc.Segment
+	n        int
+}
+
+func (s *segmentWriter) Write(p []byte) (int, error) {
+	if s.segments == nil {
+		return s.w.Write(p)
+	}
+
+	k := min(2-s.n, len(p))
+	n, err := s.w.Write(p[:k])
+	s.n += n
+	if err != nil || s.n < 2 {
+		return n, err
+	}
+
+	for _, seg := range s.segments {
+		n := len(seg.Payload) + 2
+		_, err = s.w.Write(append([]byte{0xff, seg.Marker, byte(n >> 8), byte(n)}, seg.Payload...))
+		if err != nil {
+			return k, err
+		}
+	}
+	s.segments = nil
+
+	m, err := s.w.Write(p[k:])
+	return k + m, err
+}
@@ -0,0 +1,151 @@
+package exiflign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tags of the maker note of Apple devices.
+const (
+	tagAppleBurstUUID         = 0x000b
+	tagAppleContentIdentifier = 0x0011
+)
+
+var appleMakerNoteHeader = []byte("Apple iOS\x00")
+
+// parseAppleMakerNote fills in the fields of x read from note, the maker note
+// of the image, if it was written by an Apple device.  Apple maker notes hold
+// a TIFF byte order mark after a version number, followed by an IFD whose
+// offsets are relative to the start of the note.
+func parseAppleMakerNote(x *Exif, note []byte) {
+	if !bytes.HasPrefix(note, appleMakerNoteHeader) || len(note) < 16 {
+		return
+	}
+
+	t := &tiffReader{data: note, order: binary.BigEndian}
+	switch string(note[12:14]) {
+	case "MM":
+	case "II":
+		t.order = binary.LittleEndian
+	default:
+		return
+	}
+
+	entries, _, err := t.ifd(14)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		switch e.tag {
+		case tagAppleBurstUUID:
+			x.BurstUUID = t.string(e)
+		case tagAppleContentIdentifier:
+			x.ContentIdentifier = t.string(e)
+		}
+	}
+}
+
+// CompanionVideoExts lists the extensions, in lower case, of the videos that
+// accompany still images as the motion of Live Photos and similar formats.
+var CompanionVideoExts = []string{".mov", ".mp4"}
+
+// CompanionGroup is a still image along with the files captured with it,
+// which must be corrected the same way as the still for the group to stay
+// consistent.  exiflign only normalizes the still, and the group tells
+// callers how to correct the others, or that they are stills to normalize
+// too.
+type CompanionGroup struct {
+	// Still is the path of the still image the group was found from.
+	Still string
+
+	// Orientation is the orientation tag of Still, or 1 if it has none, and
+	// Transform the operations that correct it.
+	Orientation uint16
+	Transform   Transform
+
+	// ContentIdentifier and BurstUUID are those of Still, as described in
+	// Exif.
+	ContentIdentifier string
+	BurstUUID         string
+
+	// Videos holds the paths of the videos named after Still, with one of
+	// CompanionVideoExts, such as IMG_0001.MOV for IMG_0001.JPG.
+	Videos []string
+
+	// Burst holds the paths of the other JPEG images in the directory of
+	// Still that share its BurstUUID, if it has one.
+	Burst []string
+}
+
+// FindCompanions returns the CompanionGroup of the JPEG image at path, so
+// that the companions of a still, such as the video of a Live Photo or the
+// other photos of a burst, can be given the same correction as the still.
+// Companions are found by name, and by the identifiers Apple devices record
+// in the maker note.  The orientation is that of path, whether or not it has
+// been normalized already, so groups should be found before normalizing.
+func FindCompanions(path string) (*CompanionGroup, error) {
+	x, err := readExifFile(path)
+	if err != nil && err != NoExifError {
+		return nil, err
+	}
+
+	g := &CompanionGroup{Still: path, Orientation: 1}
+	if x != nil {
+		if x.Orientation >= 1 && x.Orientation <= 8 {
+			g.Orientation = x.Orientation
+		}
+		g.ContentIdentifier, g.BurstUUID = x.ContentIdentifier, x.BurstUUID
+	}
+	g.Transform = TransformFor(g.Orientation)
+
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, entry := range entries {
+		name := entry.Name()
+		other := filepath.Join(dir, name)
+		if !entry.Type().IsRegular() || other == path {
+			continue
+		}
+
+		ext := strings.ToLower(filepath.Ext(name))
+		if strings.EqualFold(strings.TrimSuffix(name, filepath.Ext(name)), stem) && isCompanionVideoExt(ext) {
+			g.Videos = append(g.Videos, other)
+		}
+		if g.BurstUUID != "" && isJPEGName(name) {
+			if y, err := readExifFile(other); err == nil && y.BurstUUID == g.BurstUUID {
+				g.Burst = append(g.Burst, other)
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// isCompanionVideoExt reports whether ext is one of CompanionVideoExts.
+func isCompanionVideoExt(ext string) bool {
+	for _, e := range CompanionVideoExts {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readExifFile parses the EXIF data of the JPEG image at path.
+func readExifFile(path string) (*Exif, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadExif(f)
+}
@@ -0,0 +1,66 @@
+package exiflign
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+var InvalidCropError error = errors.New("The given crop rectangle is empty or not within the image.")
+
+// StoredRect maps rect, given in display coordinates, those of the image as
+// shown once its orientation tag is applied, onto the stored pixels of an
+// image of the given width and height, as found in the file, whose
+// orientation tag is tag.  Tags outside of the range 1 to 8 leave rect as it
+// is.
+func StoredRect(rect image.Rectangle, width, height int, tag uint16) image.Rectangle {
+	if tag < 2 || tag > 8 || rect.Empty() {
+		return rect
+	}
+
+	_, _, dw, dh := transformPoint(0, 0, width, height, tag)
+	inverse := inverseTag(tag)
+	x0, y0, _, _ := transformPoint(rect.Min.X, rect.Min.Y, dw, dh, inverse)
+	x1, y1, _, _ := transformPoint(rect.Max.X-1, rect.Max.Y-1, dw, dh, inverse)
+
+	return image.Rect(min(x0, x1), min(y0, y1), max(x0, x1)+1, max(y0, y1)+1)
+}
+
+// CropNormalized writes to w the part of the JPEG image in r within rect, as
+// an upright JPEG image without orientation tag, like Normalize would write
+// it.  rect is given in display coordinates, as chosen by users cropping the
+// image as their browser shows it, and is mapped onto the stored pixels with
+// StoredRect, so that only the cropped pixels are transformed.
+// InvalidCropError is returned if rect is empty or reaches outside of the
+// image.
+func CropNormalized(r io.ReadSeeker, rect image.Rectangle, w io.Writer) error {
+	tag, err := GetOrientationTag(r)
+	if err == NoExifError {
+		tag = 1
+	} else if err != nil {
+		return err
+	}
+
+	opts := &Options{}
+	res := &Result{Orientation: tag}
+	img, err := decode(r, opts, res)
+	if err != nil {
+		return err
+	}
+
+	b := img.Bounds()
+	_, _, dw, dh := transformPoint(0, 0, b.Dx(), b.Dy(), tag)
+	if rect.Empty() || !rect.In(image.Rect(0, 0, dw, dh)) {
+		return InvalidCropError
+	}
+	stored := StoredRect(rect, b.Dx(), b.Dy(), tag).Add(b.Min)
+
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return InvalidCropError
+	}
+
+	return encode(w, TransformForTag(sub.SubImage(stored), tag), nil, nil, opts, res)
+}
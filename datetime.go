@@ -0,0 +1,58 @@
+package exiflign
+
+import (
+	"time"
+)
+
+// exifTimeLayout is the layout of EXIF timestamps, which carry no time zone.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// shiftExifTimes adds d to the DateTime tag of IFD0 and the DateTimeOriginal
+// and DateTimeDigitized tags of the Exif sub-IFD of the TIFF structure data,
+// in place.  Timestamps that cannot be parsed, such as the blank ones some
+// cameras write, are left alone.
+func shiftExifTimes(data []byte, d time.Duration) {
+	t, err := newTIFFReader(data)
+	if err != nil {
+		return
+	}
+	ifd0, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return
+	}
+
+	for _, e := range ifd0 {
+		switch e.tag {
+		case tagDateTime:
+			shiftExifTime(e, d)
+		case tagExifIFD:
+			entries, _, err := t.ifd(t.uint(e, 0))
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				if e.tag == tagDateTimeOriginal || e.tag == tagDateTimeDigitized {
+					shiftExifTime(e, d)
+				}
+			}
+		}
+	}
+}
+
+// shiftExifTime adds d to the timestamp held by e, an ASCII entry, in place.
+func shiftExifTime(e ifdEntry, d time.Duration) {
+	if e.typ != tiffASCII || len(e.value) < len(exifTimeLayout) {
+		return
+	}
+
+	value := e.value[:len(exifTimeLayout)]
+	ts, err := time.Parse(exifTimeLayout, string(value))
+	if err != nil {
+		return
+	}
+
+	shifted := ts.Add(d).Format(exifTimeLayout)
+	if len(shifted) == len(value) {
+		copy(value, shifted)
+	}
+}
@@ -0,0 +1,54 @@
+package exiflign
+
+import (
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// Decoder decodes an image, as an alternative to image/jpeg for files that it
+// rejects, such as a more tolerant pure Go decoder or a cgo binding to
+// libjpeg.
+type Decoder interface {
+	Decode(r io.Reader) (image.Image, error)
+}
+
+// DecoderFunc adapts a function to the Decoder interface.
+type DecoderFunc func(r io.Reader) (image.Image, error)
+
+// Decode implements Decoder.
+func (f DecoderFunc) Decode(r io.Reader) (image.Image, error) {
+	return f(r)
+}
+
+// decode decodes the JPEG image in r from its start with image/jpeg, falling
+// back to each of opts.Decoders in turn if it fails.  The decoder that
+// succeeded is recorded in res.  If every decoder fails, the error from
+// image/jpeg is returned.
+func decode(r io.ReadSeeker, opts *Options, res *Result) (_ image.Image, err error) {
+	defer recoverPanic("decode", &err)
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	img, jerr := jpeg.Decode(r)
+	if jerr == nil {
+		return img, nil
+	}
+
+	for _, d := range opts.Decoders {
+		_, err = r.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		img, err = d.Decode(r)
+		if err == nil {
+			res.Decoder = d
+			return img, nil
+		}
+	}
+
+	return nil, jerr
+}
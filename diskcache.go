@@ -0,0 +1,200 @@
+package exiflign
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiskCache is a ResultCache that stores entries as files in a directory,
+// keeping the total size of those files below a limit by evicting the least
+// recently used entries.  It is safe for concurrent use, but the directory
+// must not be shared with other DiskCache instances or processes.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	entries map[string]*diskCacheEntry
+	hits    int64
+	misses  int64
+}
+
+type diskCacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// NewDiskCache creates a DiskCache in dir, which is created if it does not
+// exist, holding at most maxBytes of data.  Entries left in dir by a previous
+// DiskCache are kept, with their modification times used as their last use.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{dir: dir, maxBytes: maxBytes, entries: make(map[string]*diskCacheEntry)}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() || len(f.Name()) != 2*sha256.Size {
+			continue
+		}
+
+		c.entries[f.Name()] = &diskCacheEntry{size: info.Size(), used: info.ModTime()}
+		c.bytes += info.Size()
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+// Get implements ResultCache.
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	name := diskCacheName(key)
+
+	// The entry is shared with other calls, so its usage time is copied
+	// while the lock is held.
+	var used time.Time
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	if ok {
+		used = time.Now()
+		e.used = used
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	c.mu.Lock()
+	if err != nil {
+		c.misses++
+	} else {
+		c.hits++
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, false
+	}
+
+	// Keep the modification time in step so a future DiskCache sees the same
+	// usage order.
+	os.Chtimes(filepath.Join(c.dir, name), used, used)
+
+	return data, true
+}
+
+// Put implements ResultCache.  Entries larger than the cache itself, and
+// entries that cannot be written, are not stored.
+func (c *DiskCache) Put(key string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+
+	name := diskCacheName(key)
+	f, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	cerr := f.Close()
+	if err != nil || cerr != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = os.Rename(f.Name(), filepath.Join(c.dir, name))
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	if e, ok := c.entries[name]; ok {
+		c.bytes -= e.size
+	}
+	c.entries[name] = &diskCacheEntry{size: size, used: time.Now()}
+	c.bytes += size
+	c.evict()
+}
+
+// Size returns the total size of the entries currently held by c.
+func (c *DiskCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+// Stats returns the statistics of c.
+func (c *DiskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Bytes: c.bytes}
+}
+
+// Flush removes every entry from c, along with its file.  Its hit and miss
+// counts are kept.
+func (c *DiskCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name := range c.entries {
+		os.Remove(filepath.Join(c.dir, name))
+	}
+	c.entries = make(map[string]*diskCacheEntry)
+	c.bytes = 0
+}
+
+// evict removes the least recently used entries until c is within its size
+// limit.  The caller must hold c.mu.
+func (c *DiskCache) evict() {
+	if c.bytes <= c.maxBytes {
+		return
+	}
+
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return c.entries[names[i]].used.Before(c.entries[names[j]].used)
+	})
+
+	for _, name := range names {
+		if c.bytes <= c.maxBytes {
+			break
+		}
+
+		os.Remove(filepath.Join(c.dir, name))
+		c.bytes -= c.entries[name].size
+		delete(c.entries, name)
+	}
+}
+
+// diskCacheName maps a cache key to a file name.
+func diskCacheName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
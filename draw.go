@@ -0,0 +1,91 @@
+package exiflign
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Draw draws src, as it is displayed under the orientation tag, onto the
+// rectangle r of dst with op, scaling it to fill r.  Each pixel of dst is
+// read straight from src, so no oriented or scaled copy of src is made, which
+// lets compositing pipelines place a decoded image without first
+// normalizing it at full size.  Downscaled pixels are the average of the
+// source pixels they cover, upscaled ones are the nearest source pixel.
+// Only the part of r within the bounds of dst is drawn.  Tags outside of the
+// range 1 to 8 are treated as 1.
+func Draw(dst draw.Image, r image.Rectangle, src image.Image, tag uint16, op draw.Op) {
+	if tag < 1 || tag > 8 {
+		tag = 1
+	}
+	inverse := inverseTag(tag)
+
+	sb := src.Bounds()
+	_, _, dw, dh := transformPoint(0, 0, sb.Dx(), sb.Dy(), tag)
+	rw, rh := r.Dx(), r.Dy()
+	clip := r.Intersect(dst.Bounds())
+	if clip.Empty() || dw == 0 || dh == 0 {
+		return
+	}
+
+	for y := clip.Min.Y; y < clip.Max.Y; y++ {
+		j := y - r.Min.Y
+		v0, v1 := scaledSpan(j, rh, dh)
+		for x := clip.Min.X; x < clip.Max.X; x++ {
+			i := x - r.Min.X
+			u0, u1 := scaledSpan(i, rw, dw)
+
+			var sr, sg, sbl, sa, n uint64
+			for v := v0; v < v1; v++ {
+				for u := u0; u < u1; u++ {
+					px, py, _, _ := transformPoint(u, v, dw, dh, inverse)
+					c := rgba64At(src, sb.Min.X+px, sb.Min.Y+py)
+					sr += uint64(c.R)
+					sg += uint64(c.G)
+					sbl += uint64(c.B)
+					sa += uint64(c.A)
+					n++
+				}
+			}
+			c := color.RGBA64{uint16(sr / n), uint16(sg / n), uint16(sbl / n), uint16(sa / n)}
+
+			if op == draw.Over && c.A != 0xffff {
+				d := rgba64At(dst, x, y)
+				k := uint32(0xffff - c.A)
+				c.R += uint16(uint32(d.R) * k / 0xffff)
+				c.G += uint16(uint32(d.G) * k / 0xffff)
+				c.B += uint16(uint32(d.B) * k / 0xffff)
+				c.A += uint16(uint32(d.A) * k / 0xffff)
+			}
+			if d, ok := dst.(draw.RGBA64Image); ok {
+				d.SetRGBA64(x, y, c)
+			} else {
+				dst.Set(x, y, c)
+			}
+		}
+	}
+}
+
+// scaledSpan returns the range of positions, in a dimension of size n, that
+// position i of the same dimension scaled to size m covers.  The range is
+// never empty.
+func scaledSpan(i, m, n int) (int, int) {
+	lo := int(int64(i) * int64(n) / int64(m))
+	hi := int(int64(i+1) * int64(n) / int64(m))
+	if hi <= lo {
+		hi = lo + 1
+	}
+
+	return lo, hi
+}
+
+// rgba64At returns the alpha-premultiplied color of img at (x, y), without
+// allocating when img implements image.RGBA64Image.
+func rgba64At(img image.Image, x, y int) color.RGBA64 {
+	if i, ok := img.(image.RGBA64Image); ok {
+		return i.RGBA64At(x, y)
+	}
+
+	r, g, b, a := img.At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
@@ -0,0 +1,120 @@
+package exiflign
+
+import (
+	"errors"
+	"image"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// DuplicatePolicy controls what NormalizeDir does with files that duplicate
+// one it has already seen.
+type DuplicatePolicy int
+
+const (
+	// DuplicatesIgnore disables duplicate detection.
+	DuplicatesIgnore DuplicatePolicy = iota
+
+	// DuplicatesReport normalizes duplicates as usual, reporting each to
+	// FileOptions.OnDuplicate.
+	DuplicatesReport
+
+	// DuplicatesSkip reports duplicates to FileOptions.OnDuplicate without
+	// writing them.
+	DuplicatesSkip
+)
+
+var DuplicateError error = errors.New("The given file duplicates one already normalized.")
+
+// DefaultDuplicateDistance is the PHash distance within which two images are
+// considered duplicates when FileOptions.DuplicateDistance is zero.
+const DefaultDuplicateDistance = 4
+
+// duplicateIndex remembers the files seen by a batch run, to find later files
+// that duplicate them.  Files are exact duplicates when their content hashes
+// are equal, and visual duplicates when the PHash of the orientation-corrected
+// pixels of one is within the distance of that of the other in any of the
+// eight orientations, so that copies rotated by an editor rather than tagged
+// are found as well.
+type duplicateIndex struct {
+	opts     *Options
+	distance int
+
+	exact  map[Hash]string
+	visual []visualEntry
+}
+
+type visualEntry struct {
+	path   string
+	hashes [9]PerceptualHash
+}
+
+// newDuplicateIndex creates an empty duplicateIndex for files normalized
+// under opts.
+func newDuplicateIndex(opts *FileOptions) *duplicateIndex {
+	distance := opts.DuplicateDistance
+	if distance == 0 {
+		distance = DefaultDuplicateDistance
+	}
+
+	return &duplicateIndex{opts: &opts.Options, distance: distance, exact: make(map[Hash]string)}
+}
+
+// check returns the path of an earlier file that the file at path duplicates,
+// or "" if there is none, in which case path is remembered for later files.
+// Images that cannot be decoded are only compared by their content.
+func (d *duplicateIndex) check(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h, err := HashOf(f)
+	if err != nil {
+		return "", err
+	}
+	if original, ok := d.exact[h]; ok {
+		return original, nil
+	}
+	d.exact[h] = path
+	if d.distance < 0 {
+		return "", nil
+	}
+
+	tag, err := getOrientationTag(f, d.opts)
+	if err != nil && err != NoExifError {
+		return "", nil
+	}
+	img, err := decode(f, d.opts, &Result{})
+	if err != nil {
+		return "", nil
+	}
+
+	entry := visualEntry{path: path, hashes: orientedHashes(TransformForTag(img, tag))}
+	for _, e := range d.visual {
+		for _, other := range e.hashes[1:] {
+			if entry.hashes[1].Distance(other) <= d.distance {
+				return e.path, nil
+			}
+		}
+	}
+	d.visual = append(d.visual, entry)
+
+	return "", nil
+}
+
+// orientedHashes returns the PHash of img as it would appear under each
+// orientation tag, indexed by tag.  Index 0 is unused.  img is scaled down
+// before being transformed, since the hash only looks at a small grid.
+func orientedHashes(img image.Image) [9]PerceptualHash {
+	small := imaging.Resize(img, 64, 64, imaging.Box)
+
+	var hashes [9]PerceptualHash
+	for tag := uint16(1); tag <= 8; tag++ {
+		hashes[tag] = PHash(TransformForTag(small, tag))
+	}
+
+	return hashes
+}
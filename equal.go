@@ -0,0 +1,110 @@
+package exiflign
+
+import (
+	"image"
+	"io"
+)
+
+// EqualTolerance is the largest difference, in 8-bit units, that Equal allows
+// between corresponding color channels.  Decoders round differently depending
+// on where a block falls in the image, so the same shot stored with different
+// tags, or transformed losslessly, rarely decodes to exactly the same values.
+const EqualTolerance = 2
+
+// Equal reports whether the JPEG images in r1 and r2 depict the same pixels
+// once both are orientation-corrected, for example to find duplicates across
+// devices that store the same shot with different orientation tags.
+// Identical files are recognized by their content hash, and images whose
+// displayed dimensions differ are rejected from their headers, without
+// decoding either.  When finished, the internal positions in r1 and r2 will
+// be at io.SeekStart.
+func Equal(r1, r2 io.ReadSeeker) (bool, error) {
+	h1, err := HashOf(r1)
+	if err != nil {
+		return false, err
+	}
+	h2, err := HashOf(r2)
+	if err != nil {
+		return false, err
+	}
+	if h1 == h2 {
+		return true, nil
+	}
+
+	w1, ht1, err := displaySize(r1)
+	if err != nil {
+		return false, err
+	}
+	w2, ht2, err := displaySize(r2)
+	if err != nil {
+		return false, err
+	}
+	if w1 != w2 || ht1 != ht2 {
+		return false, nil
+	}
+
+	img1, err := decodeOriented(r1, 0, image.Point{})
+	if err != nil {
+		return false, err
+	}
+	img2, err := decodeOriented(r2, 0, image.Point{})
+	if err != nil {
+		return false, err
+	}
+
+	return equalPixels(img1, img2), nil
+}
+
+// displaySize returns the dimensions of the JPEG image in r as displayed,
+// from its headers alone.
+func displaySize(r io.ReadSeeker) (int, int, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	info, err := GetInfoAt(ra, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	w, h := info.DisplaySize()
+	return w, h, nil
+}
+
+// equalPixels reports whether two images of identical dimensions differ by
+// no more than EqualTolerance in any color channel.
+func equalPixels(img1, img2 image.Image) bool {
+	b1, b2 := img1.Bounds(), img2.Bounds()
+	if b1.Dx() != b2.Dx() || b1.Dy() != b2.Dy() {
+		return false
+	}
+
+	for y := 0; y < b1.Dy(); y++ {
+		for x := 0; x < b1.Dx(); x++ {
+			r1, g1, bl1, _ := img1.At(b1.Min.X+x, b1.Min.Y+y).RGBA()
+			r2, g2, bl2, _ := img2.At(b2.Min.X+x, b2.Min.Y+y).RGBA()
+
+			if channelDiff(r1, r2) > EqualTolerance || channelDiff(g1, g2) > EqualTolerance || channelDiff(bl1, bl2) > EqualTolerance {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// channelDiff returns the absolute difference between two 16-bit color
+// channels in 8-bit units.
+func channelDiff(c1, c2 uint32) uint32 {
+	c1, c2 = c1>>8, c2>>8
+	if c1 > c2 {
+		return c1 - c2
+	}
+
+	return c2 - c1
+}
@@ -0,0 +1,368 @@
+package exiflign
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// EXIF tag identifiers used by this package.
+const (
+	tagMake              = 0x010f
+	tagModel             = 0x0110
+	tagSoftware          = 0x0131
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagExposureTime      = 0x829a
+	tagFNumber           = 0x829d
+	tagISO               = 0x8827
+	tagExifVersion       = 0x9000
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagFocalLength       = 0x920a
+	tagMakerNote         = 0x927c
+	tagFlashPixVersion   = 0xa000
+	tagPixelXDimension   = 0xa002
+	tagPixelYDimension   = 0xa003
+	tagInteropIFD        = 0xa005
+	tagInteropIndex      = 0x0001
+)
+
+// Exif holds the commonly used fields of an image's EXIF data.  Fields that
+// are not present in the image are left at their zero value.
+type Exif struct {
+	// Orientation is the orientation tag as stored, which may be outside of
+	// the valid range of 1 to 8.
+	Orientation uint16
+
+	Make     string
+	Model    string
+	Software string
+
+	// DateTime and DateTimeOriginal are in the EXIF "YYYY:MM:DD HH:MM:SS"
+	// format, without any time zone.
+	DateTime         string
+	DateTimeOriginal string
+
+	// ExifVersion is the version of the EXIF standard, such as "0231".
+	ExifVersion string
+
+	// ISO is the ISO speed rating.
+	ISO int
+
+	// ExposureTime is the exposure time in seconds.
+	ExposureTime float64
+
+	// FNumber is the f-number of the lens.
+	FNumber float64
+
+	// FocalLength is the focal length of the lens in millimetres.
+	FocalLength float64
+
+	// FlashPixVersion is the version of the FlashPix format supported, such
+	// as "0100".
+	FlashPixVersion string
+
+	// InteropIndex is the interoperability rule the image follows, such as
+	// "R98" for DCF basic files, read from the Interoperability IFD.
+	InteropIndex string
+
+	// PixelXDimension and PixelYDimension are the dimensions of the image
+	// as recorded by the camera, which tools that rotate the pixels often
+	// fail to update.
+	PixelXDimension int
+	PixelYDimension int
+
+	// GPS is the location the image was taken at, or nil if it has none.
+	GPS *GPS
+
+	// ContentIdentifier and BurstUUID are read from the maker note of photos
+	// taken by Apple devices.  ContentIdentifier is shared by the still and
+	// the video of a Live Photo, and BurstUUID by the photos of a burst.
+	ContentIdentifier string
+	BurstUUID         string
+
+	// LittleEndian is set when the EXIF data is little-endian encoded.
+	LittleEndian bool
+
+	// hasOrientation is set when IFD0 has an orientation tag, and
+	// exifIFDOrientation holds one misplaced in the Exif sub-IFD, for use by
+	// quirks.
+	hasOrientation        bool
+	exifIFDOrientation    uint16
+	hasExifIFDOrientation bool
+}
+
+// ReadExif parses the EXIF data of the JPEG image in r.  NoExifError is
+// returned if the image has no EXIF segment.  When finished, the internal
+// position in r will be at io.SeekStart.
+func ReadExif(r io.ReadSeeker) (*Exif, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := ReadExifAt(ra, size)
+	_, serr := r.Seek(0, io.SeekStart)
+	if err == nil {
+		err = serr
+	}
+
+	return x, err
+}
+
+// ReadExifAt is like ReadExif, for a JPEG image of the given size in r.
+func ReadExifAt(r io.ReaderAt, size int64) (*Exif, error) {
+	payload, err := findExifSegment(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseExif(payload[len(exifHeader):])
+}
+
+// findExifSegment returns the payload of the first EXIF segment of the JPEG
+// image in r, including its identifier, along with that of any segments
+// continuing it.
+func findExifSegment(r io.ReaderAt, size int64) ([]byte, error) {
+	var payload []byte
+	var serr error
+
+	err := walkSegments(r, size, func(s segment) bool {
+		if s.marker != markerAPP1 || s.length < len(exifHeader) {
+			return true
+		}
+
+		var p []byte
+		p, serr = readSegment(r, s)
+		if serr != nil {
+			return false
+		}
+		p = standardExifPayload(s.marker, p)
+		if isExifSegment(s.marker, p) {
+			payload, serr = appendExifContinuation(r, s, p)
+			return false
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	if payload == nil {
+		return nil, NoExifError
+	}
+
+	return payload, nil
+}
+
+// parseExif extracts the fields of Exif from the TIFF structure data.
+func parseExif(data []byte) (*Exif, error) {
+	t, err := newTIFFReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ifd0, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Exif{LittleEndian: t.littleEndian()}
+	var exifIFD, gpsIFD uint32
+	for _, e := range ifd0 {
+		switch e.tag {
+		case tagOrientation:
+			x.Orientation = uint16(t.uint(e, 0))
+			x.hasOrientation = true
+		case tagMake:
+			x.Make = t.string(e)
+		case tagModel:
+			x.Model = t.string(e)
+		case tagSoftware:
+			x.Software = t.string(e)
+		case tagDateTime:
+			x.DateTime = t.string(e)
+		case tagExifIFD:
+			exifIFD = t.uint(e, 0)
+		case tagGPSIFD:
+			gpsIFD = t.uint(e, 0)
+		}
+	}
+
+	if gpsIFD != 0 {
+		x.GPS = parseGPS(t, gpsIFD)
+	}
+	if exifIFD == 0 {
+		return x, nil
+	}
+
+	// A broken Exif sub-IFD should not hide the fields already found.
+	entries, _, err := t.ifd(exifIFD)
+	if err != nil {
+		return x, nil
+	}
+	for _, e := range entries {
+		switch e.tag {
+		case tagExposureTime:
+			x.ExposureTime = t.float(e, 0)
+		case tagFNumber:
+			x.FNumber = t.float(e, 0)
+		case tagISO:
+			x.ISO = int(t.uint(e, 0))
+		case tagExifVersion:
+			x.ExifVersion = string(e.value)
+		case tagDateTimeOriginal:
+			x.DateTimeOriginal = t.string(e)
+		case tagFocalLength:
+			x.FocalLength = t.float(e, 0)
+		case tagPixelXDimension:
+			x.PixelXDimension = int(t.uint(e, 0))
+		case tagPixelYDimension:
+			x.PixelYDimension = int(t.uint(e, 0))
+		case tagOrientation:
+			x.exifIFDOrientation = uint16(t.uint(e, 0))
+			x.hasExifIFDOrientation = true
+		case tagFlashPixVersion:
+			x.FlashPixVersion = string(e.value)
+		case tagMakerNote:
+			parseAppleMakerNote(x, e.value)
+		case tagInteropIFD:
+			x.InteropIndex = parseInteropIndex(t, t.uint(e, 0))
+		}
+	}
+
+	return x, nil
+}
+
+// parseInteropIndex reads the interoperability index from the
+// Interoperability IFD at offset.
+func parseInteropIndex(t *tiffReader, offset uint32) string {
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return ""
+	}
+
+	for _, e := range entries {
+		if e.tag == tagInteropIndex {
+			return t.string(e)
+		}
+	}
+
+	return ""
+}
+
+// reencodedExif returns the EXIF segment of r prepared for a re-encoded
+// version of the image with the given bounds, normalized as described by res.
+// The orientation is reset to 1, the pixel dimensions updated and the edits
+// requested by o applied, all in place so that the offsets within the
+// EXIF data stay valid.  When finished, the internal position in r will be at
+// io.SeekStart.
+func (o *Options) reencodedExif(r io.ReadSeeker, res *Result, b image.Rectangle) ([]jpegenc.Segment, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := findExifSegment(ra, size)
+	if err == NoExifError {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	setExifOrientation(payload[len(exifHeader):], 1)
+	setExifDimensions(payload[len(exifHeader):], b.Dx(), b.Dy())
+	payload = o.editExif(payload, res)
+
+	_, err = r.Seek(0, io.SeekStart)
+	return exifSegments(payload), err
+}
+
+// string returns the value of an ASCII entry, without its terminating NUL and
+// surrounding spaces, which some cameras pad fields with.
+func (t *tiffReader) string(e ifdEntry) string {
+	if e.typ != tiffASCII {
+		return ""
+	}
+
+	value := e.value
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+
+	return strings.TrimSpace(string(value))
+}
+
+// float returns the i'th value of a numeric entry as a float64, or 0 if there
+// is none.
+func (t *tiffReader) float(e ifdEntry, i int) float64 {
+	if uint32(i) >= e.count {
+		return 0
+	}
+
+	switch e.typ {
+	case tiffRational:
+		num, den := t.order.Uint32(e.value[8*i:]), t.order.Uint32(e.value[8*i+4:])
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	case tiffSRational:
+		num, den := int32(t.order.Uint32(e.value[8*i:])), int32(t.order.Uint32(e.value[8*i+4:]))
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	case tiffFloat:
+		return float64(math.Float32frombits(t.order.Uint32(e.value[4*i:])))
+	case tiffDouble:
+		return math.Float64frombits(t.order.Uint64(e.value[8*i:]))
+	}
+
+	return float64(t.uint(e, i))
+}
+
+// seekReaderAt adapts an io.ReadSeeker to io.ReaderAt.  It is not safe for
+// concurrent use, and moves the position of the underlying reader.
+type seekReaderAt struct {
+	r io.ReadSeeker
+}
+
+// ReadAt implements io.ReaderAt.
+func (s seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	_, err := s.r.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(s.r, p)
+}
+
+// readerAt returns an io.ReaderAt over the content of r and its size.  If r
+// implements io.ReaderAt it is used directly, otherwise reads are performed by
+// seeking r.
+func readerAt(r io.ReadSeeker) (io.ReaderAt, int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if ra, ok := r.(io.ReaderAt); ok {
+		return ra, size, nil
+	}
+
+	return seekReaderAt{r}, size, nil
+}
@@ -0,0 +1,274 @@
+package exiflign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// testEntry is a field of an IFD built by buildTIFF.  Its type is derived
+// from that of value: uint16 is SHORT, uint32 LONG, string ASCII, []byte
+// UNDEFINED and testIFD a LONG offset to that IFD.
+type testEntry struct {
+	tag   uint16
+	value any
+}
+
+// testIFD is an IFD built by buildTIFF, with its entries in tag order.
+type testIFD []testEntry
+
+// buildTIFF returns the TIFF structure with ifd0 as its only top-level IFD,
+// encoded in order.  Sub-IFDs and values too large for their entries are
+// laid out after the IFD referring to them.
+func buildTIFF(order binary.ByteOrder, ifd0 testIFD) []byte {
+	data := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	if order == binary.LittleEndian {
+		data = []byte("II\x2a\x00\x08\x00\x00\x00")
+	}
+
+	return appendTestIFD(data, order, ifd0)
+}
+
+// appendTestIFD appends ifd, and everything it refers to, to data.
+func appendTestIFD(data []byte, order binary.ByteOrder, ifd testIFD) []byte {
+	start := len(data)
+	data = append(data, make([]byte, 2+12*len(ifd)+4)...)
+	order.PutUint16(data[start:], uint16(len(ifd)))
+
+	for i, e := range ifd {
+		p := start + 2 + 12*i
+		var typ uint16
+		var value []byte
+		switch v := e.value.(type) {
+		case uint16:
+			typ, value = tiffShort, make([]byte, 2)
+			order.PutUint16(value, v)
+		case uint32:
+			typ, value = tiffLong, make([]byte, 4)
+			order.PutUint32(value, v)
+		case string:
+			typ, value = tiffASCII, append([]byte(v), 0)
+		case []byte:
+			typ, value = tiffUndefined, v
+		case testIFD:
+			typ, value = tiffLong, make([]byte, 4)
+			order.PutUint32(value, uint32(len(data)))
+			data = appendTestIFD(data, order, v)
+		}
+
+		count := len(value) / tiffTypeSizes[typ]
+		order.PutUint16(data[p:], e.tag)
+		order.PutUint16(data[p+2:], typ)
+		order.PutUint32(data[p+4:], uint32(count))
+		if len(value) <= 4 {
+			copy(data[p+8:], value)
+			continue
+		}
+
+		// Values start on a word boundary, as TIFF requires.
+		if len(data)%2 != 0 {
+			data = append(data, 0)
+		}
+		order.PutUint32(data[p+8:], uint32(len(data)))
+		data = append(data, value...)
+	}
+
+	return data
+}
+
+// buildJPEG returns a w by h JPEG image whose quadrants differ in color,
+// with the given APP segments, each including its identifier, inserted
+// after the SOI marker.
+func buildJPEG(t *testing.T, w, h int, segments map[byte][]byte) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{A: 0xff}
+			if x >= w/2 {
+				c.R = 0xff
+			}
+			if y >= h/2 {
+				c.B = 0xff
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := append([]byte(nil), buf.Bytes()[:2]...)
+	for _, marker := range []byte{markerAPP1, markerAPP2} {
+		payload, ok := segments[marker]
+		if !ok {
+			continue
+		}
+		out = append(out, 0xff, marker, 0, 0)
+		binary.BigEndian.PutUint16(out[len(out)-2:], uint16(len(payload)+2))
+		out = append(out, payload...)
+	}
+
+	return append(out, buf.Bytes()[2:]...)
+}
+
+// dcfSample describes the EXIF data of a photo as written by a DCF
+// compliant camera, with an Interoperability IFD, a FlashPix version and a
+// maker note, which is stored out of line.
+type dcfSample struct {
+	name         string
+	order        binary.ByteOrder
+	make, model  string
+	orientation  uint16
+	exifVersion  string
+	interopIndex string
+	makerNote    []byte
+}
+
+var dcfSamples = []dcfSample{
+	{"canon", binary.LittleEndian, "Canon", "Canon EOS 5D Mark IV", 6, "0230", "R98", []byte("\x01\x00\x02\x00\x03\x00\x04\x00canon")},
+	{"nikon", binary.BigEndian, "NIKON CORPORATION", "NIKON D750", 8, "0230", "R98", []byte("Nikon\x00\x02\x10\x00\x00MM\x00\x2a")},
+	{"olympus", binary.LittleEndian, "OLYMPUS IMAGING CORP.", "E-M5", 3, "0221", "R98", []byte("OLYMP\x00\x01\x00\x00\x00")},
+	{"fujifilm", binary.LittleEndian, "FUJIFILM", "X-T3", 5, "0230", "R98", []byte("FUJIFILM\x0c\x00\x00\x00")},
+	{"sony adobe rgb", binary.BigEndian, "SONY", "ILCE-7M3", 7, "0231", "R03", []byte("SONY DSC \x00\x00\x00")},
+	{"pentax upright", binary.BigEndian, "RICOH IMAGING COMPANY, LTD.", "PENTAX K-1", 2, "0230", "R98", []byte("AOC\x00MM\x00\x01")},
+}
+
+// exif returns the payload of the EXIF segment of a w by h photo taken by s.
+func (s dcfSample) exif(w, h int) []byte {
+	tiff := buildTIFF(s.order, testIFD{
+		{tagMake, s.make},
+		{tagModel, s.model},
+		{tagOrientation, s.orientation},
+		{tagExifIFD, testIFD{
+			{tagExifVersion, []byte(s.exifVersion)},
+			{tagMakerNote, s.makerNote},
+			{tagFlashPixVersion, []byte("0100")},
+			{tagPixelXDimension, uint32(w)},
+			{tagPixelYDimension, uint32(h)},
+			{tagInteropIFD, testIFD{
+				{tagInteropIndex, s.interopIndex},
+			}},
+		}},
+	})
+
+	return append(append([]byte(nil), exifHeader...), tiff...)
+}
+
+func TestPreserveExifDCF(t *testing.T) {
+	const w, h = 32, 16
+	profile := append([]byte("test profile "), make([]byte, 200)...)
+	icc := append(append([]byte(nil), iccHeader...), 1, 1)
+	icc = append(icc, profile...)
+
+	modes := []struct {
+		name string
+		mode Mode
+	}{
+		{"reencode", ModeReencode},
+		{"lossless", ModeLossless},
+	}
+
+	for _, s := range dcfSamples {
+		for _, m := range modes {
+			for _, preserveICC := range []bool{false, true} {
+				name := s.name + "/" + m.name
+				if preserveICC {
+					name += "/icc"
+				}
+
+				t.Run(name, func(t *testing.T) {
+					in := buildJPEG(t, w, h, map[byte][]byte{markerAPP1: s.exif(w, h), markerAPP2: icc})
+
+					var out bytes.Buffer
+					opts := &Options{Mode: m.mode, PreserveExif: true, PreserveICC: preserveICC}
+					res, err := NormalizeWithOptions(bytes.NewReader(in), &out, opts)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if res.Orientation != s.orientation {
+						t.Errorf("Orientation = %d, want %d", res.Orientation, s.orientation)
+					}
+					if m.mode == ModeLossless && !res.Lossless {
+						t.Errorf("image was not transformed losslessly")
+					}
+
+					wantW, wantH := w, h
+					if s.orientation >= 5 {
+						wantW, wantH = h, w
+					}
+					cfg, err := jpeg.DecodeConfig(bytes.NewReader(out.Bytes()))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if cfg.Width != wantW || cfg.Height != wantH {
+						t.Errorf("decoded size = %dx%d, want %dx%d", cfg.Width, cfg.Height, wantW, wantH)
+					}
+
+					x, err := ReadExif(bytes.NewReader(out.Bytes()))
+					if err != nil {
+						t.Fatal(err)
+					}
+					checks := []struct {
+						field     string
+						got, want any
+					}{
+						{"Orientation", x.Orientation, uint16(1)},
+						{"Make", x.Make, s.make},
+						{"Model", x.Model, s.model},
+						{"ExifVersion", x.ExifVersion, s.exifVersion},
+						{"FlashPixVersion", x.FlashPixVersion, "0100"},
+						{"InteropIndex", x.InteropIndex, s.interopIndex},
+						{"PixelXDimension", x.PixelXDimension, wantW},
+						{"PixelYDimension", x.PixelYDimension, wantH},
+						{"LittleEndian", x.LittleEndian, s.order == binary.LittleEndian},
+					}
+					for _, c := range checks {
+						if c.got != c.want {
+							t.Errorf("%s = %v, want %v", c.field, c.got, c.want)
+						}
+					}
+
+					payload, err := findExifSegment(bytes.NewReader(out.Bytes()), int64(out.Len()))
+					if err != nil {
+						t.Fatal(err)
+					}
+					tr, err := newTIFFReader(payload[len(exifHeader):])
+					if err != nil {
+						t.Fatal(err)
+					}
+					entries, err := tr.exifIFD()
+					if err != nil {
+						t.Fatal(err)
+					}
+					var note []byte
+					for _, e := range entries {
+						if e.tag == tagMakerNote {
+							note = e.value
+						}
+					}
+					if !bytes.Equal(note, s.makerNote) {
+						t.Errorf("maker note = %q, want %q", note, s.makerNote)
+					}
+
+					if preserveICC {
+						got, err := ReadICCProfile(bytes.NewReader(out.Bytes()))
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(got, profile) {
+							t.Errorf("ICC profile was not preserved")
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -9,18 +9,11 @@ package exiflign
 import (
 	"errors"
 	"image"
-	"image/jpeg"
 	"io"
 
 	"github.com/disintegration/imaging"
 )
 
-const bufferSize = 32
-
-var bufferExif = []int{0x45, 0x78, 0x69, 0x66, 0x00, 0x00}
-var bufferOrienLittle = []int{0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, -1, 0x00}
-var bufferOrienBig = []int{0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, -1 }
-
 var NoExifError error = errors.New("The given file does not contain any EXIF orientation information.")
 
 // Normalize is the "quick-fix" function of this package.  It requires an
@@ -30,31 +23,29 @@ var NoExifError error = errors.New("The given file does not contain any EXIF ori
 // simply copied to w.  When finished, the internal position in r will be at
 // io.SeekStart.
 func Normalize(r io.ReadSeeker, w io.Writer) error {
-	tag, err := GetOrientationTag(r)
-	if err == NoExifError {
-		_, err = io.Copy(w, r)
-		if err != nil {
-			return err
-		}
-	}
+	_, err := NormalizeWithOptions(r, w, nil)
+	return err
+}
 
-	img1, err := jpeg.Decode(r)
-	if err != nil {
-		return err
+// TransformForTag performs the neccessary transformation on img that will
+// facilitate removal of the orientation tag.  Grayscale images are kept as
+// *image.Gray so that they are encoded as single channel images, and YCbCr
+// images as decoded from JPEG files are kept as *image.YCbCr so that they are
+// not converted to RGB and back.
+func TransformForTag(img image.Image, tag uint16) image.Image {
+	if tag < 2 || tag > 8 {
+		return img
 	}
 
-	img2 := TransformForTag(img1, tag)
-	err = jpeg.Encode(w, img2, nil)
-	if err != nil {
-		return err
+	switch src := img.(type) {
+	case *image.Gray:
+		return transformGray(src, tag)
+	case *image.YCbCr:
+		if dst, ok := transformYCbCr(src, tag); ok {
+			return dst
+		}
 	}
 
-	return nil
-}
-
-// TransformForTag performs the neccessary transformation on img that will
-// facilitate removal of the orientation tag.
-func TransformForTag(img image.Image, tag uint16) image.Image {
 	switch tag {
 	default:
 		return img
@@ -75,87 +66,121 @@ func TransformForTag(img image.Image, tag uint16) image.Image {
 	}
 }
 
+// TransformForTagNRGBA performs the same transformation as TransformForTag,
+// but always returns a new *image.NRGBA whose bounds start at (0, 0),
+// regardless of the type and bounds of img or of which operations the tag
+// requires.  This suits code that type-asserts the result or indexes its Pix
+// directly.  Grayscale images are converted as well, use TransformForTag to
+// keep them single channel.
+func TransformForTagNRGBA(img image.Image, tag uint16) *image.NRGBA {
+	out := TransformForTag(img, tag)
+	if n, ok := out.(*image.NRGBA); ok && out != img {
+		return n
+	}
+
+	return imaging.Clone(out)
+}
+
 // GetOrientationTag produces a value between 1 and 8, inclusive, for a given
 // JPEG image in r.  This value describes the transformations required to
 // produce the correct image.  The excellent article by Magnus Hoff covers this
 // in more detail:
 //
 // https://magnushoff.com/jpeg-orientation.html
+//
+// GIF and JPEG XL images cannot be normalized, and are reported with
+// GIFExifError or JXLExifError if GetGIFOrientationTag or
+// GetJXLOrientationTag finds an orientation in them, or GIFError or JXLError
+// otherwise, rather than NoExifError or as a damaged JPEG image.
 func GetOrientationTag(r io.ReadSeeker) (uint16, error) {
-	endr, err := splitSearch(r, bufferExif, 8)
-	if err != nil {
-		return 0, NoExifError
-	}
-	r.Seek(0, io.SeekStart)
+	tag, _, err := getOrientation(r, false)
+	return tag, err
+}
 
-	littleEndian := endr[6] == 0x49 && endr[7] == 0x49
-	bufferOrien := bufferOrienBig
-	if littleEndian {
-		bufferOrien = bufferOrienLittle
-	}
+// GetOrientationTagLenient is like GetOrientationTag, but tolerates EXIF data
+// whose byte order marker contradicts the byte order its values were written
+// in, choosing the interpretation that yields an orientation between 1 and 8.
+func GetOrientationTagLenient(r io.ReadSeeker) (uint16, error) {
+	tag, _, err := getOrientation(r, true)
+	return tag, err
+}
 
-	res, err := splitSearch(r, bufferOrien, 10)
+// getOrientation behaves as GetOrientationTag, or GetOrientationTagLenient if
+// lenient is set, but also reports whether the EXIF data was little-endian
+// encoded according to its byte order marker.  The JPEG marker segments are
+// walked rather than the file scanned, so EXIF data is found regardless of
+// the order and size of the segments preceding it, and text resembling EXIF
+// data in other segments is ignored.
+func getOrientation(r io.ReadSeeker, lenient bool) (uint16, bool, error) {
+	ra, size, err := readerAt(r)
 	if err != nil {
-		return 0, NoExifError
-	}
-	r.Seek(0, io.SeekStart)
-
-	tagr := res[8:]
-	if littleEndian {
-		tagr[0], tagr[1] = tagr[1], tagr[0]
+		return 0, false, NoExifError
 	}
 
 	var tag uint16
-	tag |= uint16(tagr[1])
-	tag += uint16(tagr[0]) << 8
+	var littleEndian, found bool
+	err = walkSegments(ra, size, func(s segment) bool {
+		if s.marker != markerAPP1 || s.length < len(exifHeader) {
+			return true
+		}
 
-	if tag < 1 || tag > 8 {
-		tag = 1
-	}
+		payload, err := readSegment(ra, s)
+		payload = standardExifPayload(s.marker, payload)
+		if err != nil || !isExifSegment(s.marker, payload) {
+			return err == nil
+		}
+		payload, err = appendExifContinuation(ra, s, payload)
+		if err != nil {
+			return false
+		}
 
-	return tag, nil
-}
-func splitSearch(r io.ReadSeeker, sequence []int, length uint) ([]byte, error) {
-	var buffer [bufferSize]byte
-	v1 := buffer[:bufferSize/2]
-	v2 := buffer[bufferSize/2:]
+		data := payload[len(exifHeader):]
+		if lenient {
+			tag, found = lenientExifOrientation(data)
+			littleEndian = len(data) > 0 && data[0] == 'I'
+			return !found
+		}
 
-	_, err := r.Read(buffer[:])
-	if err != nil {
-		return nil, err
+		t, err := newTIFFReader(data)
+		if err != nil {
+			return true
+		}
+		tag, found = exifOrientation(data)
+		littleEndian = t.littleEndian()
+		return !found
+	})
+	if err == NotJPEGError {
+		err = otherFormatError(ra, size)
+		r.Seek(0, io.SeekStart)
+		return 0, false, err
 	}
-
-	var res []byte = find(buffer, sequence, length)
-	if res != nil {
-		return res, nil
+	r.Seek(0, io.SeekStart)
+	if err != nil || !found {
+		return 0, littleEndian, NoExifError
 	}
 
-	for res = nil; res == nil; res = find(buffer, sequence, length) {
-		copy(v1, v2)
-
-		_, err := r.Read(v2)
-		if err != nil {
-			return nil, err
-		}
+	if tag < 1 || tag > 8 {
+		tag = 1
 	}
 
-	return res, nil
+	return tag, littleEndian, nil
 }
-func find(buffer [bufferSize]byte, sequence []int, length uint) []byte {
-	for i := 0; i <= len(buffer)-int(length); i++ {
-		didMatch := true
-
-		for j := 0; j < len(sequence); j++ {
-			if buffer[i+j] != byte(sequence[j]) && sequence[j] != -1 {
-				didMatch = false
-				break
-			}
-		}
 
-		if didMatch {
-			return buffer[i : i+int(length)]
+// otherFormatError returns the error GetOrientationTag reports for the image
+// of the given size in r, which is not a JPEG image.
+func otherFormatError(r io.ReaderAt, size int64) error {
+	switch detectFormat(r) {
+	case FormatGIF:
+		if _, found, _ := gifOrientation(r, size); found {
+			return GIFExifError
+		}
+		return GIFError
+	case FormatJXL:
+		if _, found, _ := jxlOrientation(r, size); found {
+			return JXLExifError
 		}
+		return JXLError
 	}
 
-	return nil
+	return NoExifError
 }
@@ -0,0 +1,151 @@
+package exiflign
+
+import (
+	"errors"
+)
+
+// EXIF versions accepted by Options.ExifVersion.
+const (
+	ExifVersion22  = "0220"
+	ExifVersion23  = "0230"
+	ExifVersion231 = "0231"
+	ExifVersion232 = "0232"
+	ExifVersion30  = "0300"
+)
+
+// tiffUTF8 is the UTF-8 field type introduced by EXIF 3.0.
+const tiffUTF8 = 129
+
+var InvalidExifVersionError error = errors.New("The EXIF version must be four digits, such as \"0232\".")
+
+// exifTagVersions gives the EXIF version that introduced each Exif sub-IFD
+// tag added since 2.2.  Tags not listed are valid in every version.
+var exifTagVersions = map[uint16]string{
+	0x8830: ExifVersion23,  // SensitivityType
+	0x8831: ExifVersion23,  // StandardOutputSensitivity
+	0x8832: ExifVersion23,  // RecommendedExposureIndex
+	0x8833: ExifVersion23,  // ISOSpeed
+	0x8834: ExifVersion23,  // ISOSpeedLatitudeyyy
+	0x8835: ExifVersion23,  // ISOSpeedLatitudezzz
+	0xa430: ExifVersion23,  // CameraOwnerName
+	0xa431: ExifVersion23,  // BodySerialNumber
+	0xa432: ExifVersion23,  // LensSpecification
+	0xa433: ExifVersion23,  // LensMake
+	0xa434: ExifVersion23,  // LensModel
+	0xa435: ExifVersion23,  // LensSerialNumber
+	0xa500: ExifVersion23,  // Gamma
+	0x9010: ExifVersion231, // OffsetTime
+	0x9011: ExifVersion231, // OffsetTimeOriginal
+	0x9012: ExifVersion231, // OffsetTimeDigitized
+	0x9400: ExifVersion231, // Temperature
+	0x9401: ExifVersion231, // Humidity
+	0x9402: ExifVersion231, // Pressure
+	0x9403: ExifVersion231, // WaterDepth
+	0x9404: ExifVersion231, // Acceleration
+	0x9405: ExifVersion231, // CameraElevationAngle
+	0xa460: ExifVersion232, // CompositeImage
+	0xa461: ExifVersion232, // SourceImageNumberOfCompositeImage
+	0xa462: ExifVersion232, // SourceExposureTimesOfCompositeImage
+	0xa436: ExifVersion30,  // ImageTitle
+	0xa437: ExifVersion30,  // Photographer
+	0xa438: ExifVersion30,  // ImageEditor
+	0xa439: ExifVersion30,  // CameraFirmware
+	0xa43a: ExifVersion30,  // RAWDevelopingSoftware
+	0xa43b: ExifVersion30,  // ImageEditingSoftware
+	0xa43c: ExifVersion30,  // MetadataEditingSoftware
+}
+
+// validExifVersion reports whether v has the form of an EXIF version.
+func validExifVersion(v string) bool {
+	if len(v) != 4 {
+		return false
+	}
+	for _, c := range []byte(v) {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setExifVersion rewrites the ExifVersion tag of the TIFF structure data in
+// place to version, and removes the Exif sub-IFD entries that are not valid
+// in that version, along with UTF-8 entries anywhere before 3.0.  The values
+// of removed entries are left in place, so no other offsets change.
+func setExifVersion(data []byte, version string) {
+	t, err := newTIFFReader(data)
+	if err != nil {
+		return
+	}
+	ifd0, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return
+	}
+
+	utf8 := version >= ExifVersion30
+	for _, e := range ifd0 {
+		if e.tag != tagExifIFD || e.count < 1 {
+			continue
+		}
+
+		offset := t.uint(e, 0)
+		entries, _, err := t.ifd(offset)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if e.tag == tagExifVersion && e.typ == tiffUndefined && e.count == 4 {
+				copy(e.value, version)
+			}
+		}
+
+		t.filterIFD(offset, func(e ifdEntry) bool {
+			introduced, ok := exifTagVersions[e.tag]
+			return (!ok || introduced <= version) && (utf8 || e.typ != tiffUTF8)
+		})
+	}
+
+	// IFD0 is filtered last, since moving its entries invalidates ifd0.
+	t.filterIFD(t.firstIFD(), func(e ifdEntry) bool {
+		return utf8 || e.typ != tiffUTF8
+	})
+}
+
+// filterIFD removes the entries of the IFD at offset for which keep returns
+// false, in place.  The remaining entries are moved up and the pointer to the
+// next IFD follows them.
+func (t *tiffReader) filterIFD(offset uint32, keep func(e ifdEntry) bool) {
+	if int64(offset)+2 > int64(len(t.data)) {
+		return
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+12*n+4 > len(t.data) {
+		return
+	}
+
+	kept := 0
+	for i := 0; i < n; i++ {
+		p := start + 12*i
+		e := ifdEntry{
+			tag:   t.order.Uint16(t.data[p:]),
+			typ:   t.order.Uint16(t.data[p+2:]),
+			count: t.order.Uint32(t.data[p+4:]),
+		}
+		if !keep(e) {
+			continue
+		}
+
+		copy(t.data[start+12*kept:], t.data[p:p+12])
+		kept++
+	}
+	if kept == n {
+		return
+	}
+
+	next := t.order.Uint32(t.data[start+12*n:])
+	t.order.PutUint16(t.data[offset:], uint16(kept))
+	t.order.PutUint32(t.data[start+12*kept:], next)
+	clear(t.data[start+12*kept+4 : start+12*n+4])
+}
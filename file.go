@@ -0,0 +1,346 @@
+package exiflign
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var StampedError error = errors.New("The given file has already been normalized.")
+var AttributesUnsupportedError error = errors.New("Copying extended attributes is not supported on this platform.")
+
+// FileOptions controls the behaviour of NormalizeFile and NormalizeDir.  A nil
+// *FileOptions is equivalent to the zero value.
+type FileOptions struct {
+	// Options controls how each image is normalized.
+	Options
+
+	// Stamp causes every written file to be marked with Stamp, and any file
+	// whose input or existing output is already stamped to be skipped.  This
+	// makes repeated runs over the same files idempotent without having to
+	// rewrite their EXIF data, whether they are normalized in place or into
+	// another tree.
+	Stamp bool
+
+	// Verify, if non-nil, causes every normalized file to be compared against
+	// its original with Verify before it replaces dst, with the original
+	// oriented, and trimmed, as it was normalized regardless of
+	// Verify.Orientation and Verify.Trimmed.  Files that fail verification
+	// are left untouched and the VerificationError is returned.
+	Verify *VerifyOptions
+
+	// Sidecar causes a Sidecar describing the change to be written next to
+	// every normalized file, at SidecarPath of the file.
+	Sidecar bool
+
+	// XMPSidecar causes the XMP sidecar of every file, as found by
+	// XMPSidecarPath, to be consulted and updated along with its embedded
+	// metadata, as digital asset management tools record orientation there.
+	// A tiff:Orientation in the sidecar takes precedence over the embedded
+	// orientation, and once the file is normalized its sidecar is written
+	// next to dst with the orientation reset to 1.
+	XMPSidecar bool
+
+	// PreserveAttributes causes the extended attributes of every file, in
+	// which desktop applications keep labels such as Finder tags, to be
+	// copied to its output, which would otherwise lose them as it is a new
+	// file even when normalizing in place.  On Windows, the alternate data
+	// streams of the file are copied instead.  On platforms other than
+	// Linux, macOS and Windows, AttributesUnsupportedError is returned.
+	PreserveAttributes bool
+
+	// QuarantineDir, if set, is the directory that originals found
+	// suspicious by Options.QuarantinePolicy are copied to, under their base
+	// name and alongside a text file with the same name plus ".txt" giving
+	// the reason.  It is not used if Options.Quarantine is set.
+	QuarantineDir string
+
+	// OutputTemplate, if set, causes NormalizeDir to write each file to the
+	// path given by ExpandOutputTemplate for it, relative to dst, rather than
+	// to the same relative location as in src, for reorganizing photo dumps
+	// into a library.  Directories are created as required.  Files whose
+	// templates expand to the same path overwrite each other, so templates
+	// should include {hash} or {name} when that matters.
+	OutputTemplate string
+
+	// Filter, if non-nil, selects the files that are normalized.  Others
+	// are left untouched and reported with FilteredError.
+	Filter *FileFilter
+
+	// Collisions controls what happens when the output path of a file
+	// already exists, so that runs into an existing output directory need
+	// not clean it out first.
+	Collisions CollisionPolicy
+
+	// FollowSymlinks causes NormalizeDir to follow symbolic links, walking
+	// each directory only once however many links lead to it, so that
+	// cycles are harmless.  A link to a file normalized in place is kept,
+	// and the file it points to normalized instead, once.  Without it,
+	// symbolic links are skipped, so that they are never replaced by
+	// regular files.
+	FollowSymlinks bool
+
+	// Duplicates controls how NormalizeDir treats files that are exact or
+	// visual duplicates of one it has already normalized, including copies
+	// that were rotated rather than tagged.  Finding visual duplicates
+	// requires decoding every file once more.
+	Duplicates DuplicatePolicy
+
+	// DuplicateDistance is the maximum PHash distance between visual
+	// duplicates.  If zero, DefaultDuplicateDistance is used, and if
+	// negative, only exact duplicates are detected.
+	DuplicateDistance int
+
+	// OnDuplicate, if non-nil, is called by NormalizeDir with the path of
+	// every duplicate and that of the file it duplicates.
+	OnDuplicate func(path, original string)
+
+	// OnFile, if non-nil, is called with the outcome of every file
+	// NormalizeFile processes, including those NormalizeDir processes
+	// through it, with res nil unless it was normalized.  The error OnFile
+	// returns is returned by NormalizeFile in place of err, so returning nil
+	// for a file that failed lets NormalizeDir carry on with the rest of the
+	// tree.  Files skipped by NormalizeDir as duplicates are reported with
+	// DuplicateError.
+	OnFile func(src, dst string, res *Result, err error) error
+}
+
+// NormalizeFile normalizes the JPEG image at src and writes the result to dst.
+// src and dst may be the same path, in which case the file is normalized in
+// place.  On Windows, paths longer than MAX_PATH are supported.  The output is
+// written to a temporary file alongside dst which is then renamed over dst, so
+// dst is never left partially written.  If opts.Stamp is set and src or dst has
+// already been stamped, StampedError is returned and nothing is written.  Under
+// opts.Sidecar and opts.XMPSidecar, the sidecars are written once dst has been
+// replaced.  The outcome is passed to opts.OnFile, if set.
+func NormalizeFile(src, dst string, opts *FileOptions) error {
+	if opts == nil {
+		opts = &FileOptions{}
+	}
+
+	var res *Result
+	out, err := opts.resolveOutput(src, dst)
+	if err == nil {
+		dst = out
+		res, err = normalizePath(src, dst, opts)
+	}
+	if opts.OnFile != nil {
+		return opts.OnFile(src, dst, res, err)
+	}
+
+	return err
+}
+
+// normalizePath performs the work of NormalizeFile, reporting what was done.
+func normalizePath(src, dst string, opts *FileOptions) (*Result, error) {
+	source := src
+	src, dst = longPath(src), longPath(dst)
+
+	match, err := opts.Filter.Match(src)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, FilteredError
+	}
+
+	if opts.Stamp {
+		stamped, err := isStampedPair(src, dst)
+		if err != nil {
+			return nil, err
+		}
+		if stamped {
+			return nil, StampedError
+		}
+	}
+
+	fIn, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer fIn.Close()
+
+	info, err := fIn.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var sidecar *Sidecar
+	if opts.Sidecar {
+		sidecar, err = newSidecar(source, fIn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fOut, err := os.CreateTemp(filepath.Dir(dst), ".exiflign-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(fOut.Name())
+
+	var xmp *xmpSidecar
+	if opts.XMPSidecar {
+		xmp, err = readXMPSidecar(src)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	normalizeOpts := &opts.Options
+	if (opts.QuarantineDir != "" && opts.Quarantine == nil) || (xmp != nil && xmp.tag != 0) {
+		normalizeOpts = new(Options)
+		*normalizeOpts = opts.Options
+	}
+	if opts.QuarantineDir != "" && opts.Quarantine == nil {
+		normalizeOpts.Quarantine = func(r io.Reader, reason string) error {
+			return quarantineFile(opts.QuarantineDir, src, r, reason)
+		}
+	}
+	if xmp != nil {
+		normalizeOpts.orientation = xmp.tag
+	}
+
+	res, err := NormalizeWithOptions(fIn, fOut, normalizeOpts)
+	if err != nil {
+		if pe, ok := err.(*PanicError); ok {
+			pe.Path = src
+		}
+		fOut.Close()
+		return nil, err
+	}
+
+	if opts.Verify != nil {
+		verify := *opts.Verify
+		verify.Orientation = res.Orientation
+		verify.Trimmed = res.Trimmed
+		_, err = Verify(fIn, fOut, &verify)
+		if err != nil {
+			fOut.Close()
+			return nil, err
+		}
+	}
+
+	err = fOut.Chmod(info.Mode().Perm())
+	if err != nil {
+		fOut.Close()
+		return nil, err
+	}
+
+	err = fOut.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.PreserveAttributes {
+		err = copyAttributes(src, fOut.Name())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.Rename(fOut.Name(), dst)
+	if err != nil {
+		return nil, err
+	}
+
+	if xmp != nil {
+		err = xmp.write(dst)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sidecar != nil {
+		err = sidecar.write(dst, res)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Stamp {
+		err = Stamp(dst)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// isStampedPair reports whether src, or dst if it is another file that
+// exists, has been marked with Stamp.
+func isStampedPair(src, dst string) (bool, error) {
+	stamped, err := IsStamped(src)
+	if err != nil || stamped || dst == src {
+		return stamped, err
+	}
+
+	stamped, err = IsStamped(dst)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return stamped, err
+}
+
+// NormalizeDir walks the directory tree rooted at src and normalizes every
+// file with a .jpg or .jpeg extension into the same relative location under
+// dst, creating directories as required, or to the location given by
+// opts.OutputTemplate.  src and dst may be the same directory to normalize a
+// tree in place.  Files skipped because they are already stamped, were
+// quarantined, are filtered out by opts.Filter, have an existing output under
+// CollisionSkip or are duplicates under opts.Duplicates are not treated as
+// errors.
+//
+// Symbolic links are skipped unless opts.FollowSymlinks is set.  Files that
+// are hard links to the same file are normalized once, and their other paths
+// are made hard links to its output, without sidecars of their own, so that
+// deduplicated archives stay deduplicated.  This relies on file identities
+// that are only available on Unix systems.  Files that would be written to
+// paths differing only in case from that of an earlier file, and so
+// overwrite it on case-insensitive file systems, are reported with
+// CaseCollisionError and not written.
+func NormalizeDir(src, dst string, opts *FileOptions) error {
+	if opts == nil {
+		opts = &FileOptions{}
+	}
+
+	w := &dirWalker{src: src, dst: dst, opts: opts, links: make(map[fileKey]linkedFile), outputs: make(map[string]string)}
+	if opts.Duplicates != DuplicatesIgnore {
+		w.duplicates = newDuplicateIndex(opts)
+	}
+
+	return w.walk(src, src)
+}
+
+// quarantineFile copies the original at src, read from r, into dir, along
+// with the reason it was quarantined.
+func quarantineFile(dir, src string, r io.Reader, reason string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	dst := filepath.Join(dir, filepath.Base(src))
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst+".txt", []byte(reason+"\n"), 0644)
+}
+
+func isJPEGName(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jpg" || ext == ".jpeg"
+}
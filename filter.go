@@ -0,0 +1,169 @@
+package exiflign
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var FilteredError error = errors.New("The given file does not match the file filter.")
+
+// ExifFilter selects files by whether they carry EXIF orientation
+// information.
+type ExifFilter int
+
+const (
+	// ExifAny matches files whether or not they carry EXIF orientation
+	// information.
+	ExifAny ExifFilter = iota
+
+	// ExifPresent matches only files that carry EXIF orientation
+	// information.
+	ExifPresent
+
+	// ExifAbsent matches only files that carry no EXIF orientation
+	// information.
+	ExifAbsent
+)
+
+// TimeSource selects the time of a file that FileFilter.Since and
+// FileFilter.Until are compared against.
+type TimeSource int
+
+const (
+	// TimeModified is the modification time of the file.
+	TimeModified TimeSource = iota
+
+	// TimeTaken is the time the photo was taken, from the DateTimeOriginal
+	// EXIF tag, or DateTime if it has none, read in the local time zone as
+	// EXIF timestamps carry none.  Files with neither fall back to their
+	// modification time.
+	TimeTaken
+)
+
+// FileFilter selects the files NormalizeFile and NormalizeDir normalize, so
+// that operators can, for example, normalize only images the size of a
+// phone's photos and leave small thumbnails alone.  Every bound that is set
+// must be met, and bounds left at zero are not checked.  Dimensions are
+// those of the image as displayed, after its orientation tag is applied, and
+// are read from its headers without decoding it.
+type FileFilter struct {
+	// MinWidth, MinHeight, MaxWidth and MaxHeight bound the dimensions of
+	// the image in pixels.
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+
+	// MinMegapixels and MaxMegapixels bound the number of pixels of the
+	// image, in millions.
+	MinMegapixels float64
+	MaxMegapixels float64
+
+	// MinBytes and MaxBytes bound the size of the file.
+	MinBytes int64
+	MaxBytes int64
+
+	// Exif selects files by whether they carry EXIF orientation
+	// information.
+	Exif ExifFilter
+
+	// Since and Until bound the time of the file, as selected by
+	// TimeSource, to times at or after Since and before Until, so that
+	// incremental runs over an archive only pick up new photos.
+	Since time.Time
+	Until time.Time
+
+	// TimeSource selects the time Since and Until are compared against.
+	TimeSource TimeSource
+}
+
+// Match reports whether the JPEG image at path is selected by f.  A nil
+// *FileFilter selects every file.
+func (f *FileFilter) Match(path string) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := stat.Size()
+	if (f.MinBytes > 0 && size < f.MinBytes) || (f.MaxBytes > 0 && size > f.MaxBytes) {
+		return false, nil
+	}
+
+	info, err := GetInfoAt(file, size)
+	if err != nil {
+		return false, err
+	}
+	if !f.matchInfo(info) {
+		return false, nil
+	}
+
+	if f.Since.IsZero() && f.Until.IsZero() {
+		return true, nil
+	}
+	t := stat.ModTime()
+	if f.TimeSource == TimeTaken {
+		if taken, ok := takenTime(file, size); ok {
+			t = taken
+		}
+	}
+
+	return (f.Since.IsZero() || !t.Before(f.Since)) && (f.Until.IsZero() || t.Before(f.Until)), nil
+}
+
+// takenTime returns the time the photo of the given size in r was taken,
+// as described by TimeTaken, and whether it records one.
+func takenTime(r io.ReaderAt, size int64) (time.Time, bool) {
+	x, err := ReadExifAt(r, size)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	date := x.DateTimeOriginal
+	if date == "" {
+		date = x.DateTime
+	}
+	t, err := time.ParseInLocation(exifTimeLayout, date, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// matchInfo reports whether the image described by info meets the bounds of
+// f other than those on the size of its file.
+func (f *FileFilter) matchInfo(info *Info) bool {
+	switch {
+	case f.Exif == ExifPresent && !info.HasExif:
+		return false
+	case f.Exif == ExifAbsent && info.HasExif:
+		return false
+	}
+
+	w, h := info.DisplaySize()
+	if (f.MinWidth > 0 && w < f.MinWidth) || (f.MaxWidth > 0 && w > f.MaxWidth) {
+		return false
+	}
+	if (f.MinHeight > 0 && h < f.MinHeight) || (f.MaxHeight > 0 && h > f.MaxHeight) {
+		return false
+	}
+
+	megapixels := float64(w) * float64(h) / 1e6
+	if (f.MinMegapixels > 0 && megapixels < f.MinMegapixels) || (f.MaxMegapixels > 0 && megapixels > f.MaxMegapixels) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,88 @@
+package exiflign
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var UnsupportedFormatError error = errors.New("The given file is in an image format that cannot be normalized.")
+
+// Format is an image file format recognised by DetectFormat.
+type Format int
+
+const (
+	// FormatUnknown is any format not listed below, including damaged
+	// files.
+	FormatUnknown Format = iota
+
+	FormatJPEG
+	FormatGIF
+
+	// FormatJXL is JPEG XL, either in its container format or as a bare
+	// codestream.
+	FormatJXL
+
+	// FormatBMP and FormatPNM are BMP and binary PGM or PPM images, which
+	// NormalizeRaw converts.
+	FormatBMP
+	FormatPNM
+)
+
+// String returns the name of f.
+func (f Format) String() string {
+	switch f {
+	case FormatJPEG:
+		return "JPEG"
+	case FormatGIF:
+		return "GIF"
+	case FormatJXL:
+		return "JPEG XL"
+	case FormatBMP:
+		return "BMP"
+	case FormatPNM:
+		return "PNM"
+	}
+
+	return "unknown"
+}
+
+// DetectFormat recognises the format of the image in r from its signature,
+// so that pipelines handling mixed formats can route each file before
+// normalizing it.  Only JPEG images can be normalized, GetGIFOrientationTag
+// and GetJXLOrientationTag read the orientation of GIF and JPEG XL images,
+// and NormalizeRaw converts BMP and PNM images.
+// When finished, the internal position in r will be at io.SeekStart.
+func DetectFormat(r io.ReadSeeker) (Format, error) {
+	ra, _, err := readerAt(r)
+	if err != nil {
+		return FormatUnknown, err
+	}
+
+	f := detectFormat(ra)
+	_, err = r.Seek(0, io.SeekStart)
+
+	return f, err
+}
+
+// detectFormat is like DetectFormat, for r.
+func detectFormat(r io.ReaderAt) Format {
+	var header [12]byte
+	n, _ := r.ReadAt(header[:], 0)
+	data := header[:n]
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, markerSOI}):
+		return FormatJPEG
+	case bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a")):
+		return FormatGIF
+	case bytes.Equal(data, jxlContainer) || bytes.HasPrefix(data, jxlCodestream):
+		return FormatJXL
+	case bytes.HasPrefix(data, []byte("BM")):
+		return FormatBMP
+	case bytes.HasPrefix(data, []byte("P5")) || bytes.HasPrefix(data, []byte("P6")):
+		return FormatPNM
+	}
+
+	return FormatUnknown
+}
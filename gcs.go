@@ -0,0 +1,173 @@
+package exiflign
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// DefaultGCSEndpoint is the endpoint of Google Cloud Storage.
+const DefaultGCSEndpoint = "https://storage.googleapis.com"
+
+// GCSStorage is a Storage over a bucket of Google Cloud Storage, accessed
+// through its JSON API with OAuth 2.0 access tokens.  Objects are read into
+// memory when opened and held in memory until closed when created.  Buckets
+// can also be accessed through S3Storage with HMAC keys, using the
+// interoperability of the XML API.
+type GCSStorage struct {
+	// Endpoint is the base URL of the service.  If empty,
+	// DefaultGCSEndpoint is used.
+	Endpoint string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// Token returns the OAuth 2.0 access token requests are authorized
+	// with, for example from golang.org/x/oauth2/google.  If nil, requests
+	// are not authorized, which only suits public buckets and emulators.
+	Token func(ctx context.Context) (string, error)
+
+	// Client is the HTTP client requests are made with.  If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// gcsListResult is the response to an objects.list request.
+type gcsListResult struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// gcsRewriteResult is the response to an objects.rewrite request.
+type gcsRewriteResult struct {
+	Done         bool   `json:"done"`
+	RewriteToken string `json:"rewriteToken"`
+}
+
+// List implements Storage.
+func (s *GCSStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+	for {
+		var result gcsListResult
+		err := s.doJSON(ctx, http.MethodGet, s.objectPath(""), query, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			names = append(names, item.Name)
+		}
+
+		if result.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", result.NextPageToken)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Open implements Storage, reading the whole object into memory.
+func (s *GCSStorage) Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	return readObject(s.do(ctx, http.MethodGet, s.objectPath(name), url.Values{"alt": {"media"}}, nil))
+}
+
+// Create implements Storage.  The object is uploaded when the writer is
+// closed.
+func (s *GCSStorage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &uploadWriter{upload: func(data []byte) error {
+		query := url.Values{"uploadType": {"media"}, "name": {cleanName(name)}}
+		resp, err := s.do(ctx, http.MethodPost, "/upload"+s.objectPath(""), query, data)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}}, nil
+}
+
+// Rename implements Storage by rewriting oldName to newName and deleting
+// oldName, since Cloud Storage has no rename operation.  Large objects take
+// several rewrite requests.
+func (s *GCSStorage) Rename(ctx context.Context, oldName, newName string) error {
+	path := s.objectPath(oldName) + "/rewriteTo" + s.objectPath(newName)
+	query := url.Values{}
+	for {
+		var result gcsRewriteResult
+		err := s.doJSON(ctx, http.MethodPost, path, query, &result)
+		if err != nil {
+			return err
+		}
+		if result.Done {
+			break
+		}
+		query.Set("rewriteToken", result.RewriteToken)
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, s.objectPath(oldName), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// objectPath returns the escaped path of the object name within the JSON
+// API, or of the collection of objects of the bucket if name is empty.
+func (s *GCSStorage) objectPath(name string) string {
+	p := "/storage/v1/b/" + url.PathEscape(s.Bucket) + "/o"
+	if name != "" {
+		p += "/" + url.PathEscape(cleanName(name))
+	}
+
+	return p
+}
+
+// do performs an authorized request for the escaped path, returning an error
+// unless it succeeds.
+func (s *GCSStorage) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultGCSEndpoint
+	}
+
+	u := endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.Token != nil {
+		token, err := s.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return checkStatus(client.Do(req))
+}
+
+// doJSON is like do, decoding the JSON response into v.
+func (s *GCSStorage) doJSON(ctx context.Context, method, path string, query url.Values, v any) error {
+	resp, err := s.do(ctx, method, path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,129 @@
+package exiflign
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var GIFError error = errors.New("The given file is a GIF image, which cannot be normalized.")
+var GIFExifError error = errors.New("The given file is a GIF image with EXIF orientation information, which cannot be normalized.")
+var NotGIFError error = errors.New("The given file is not a GIF image.")
+
+// maxGIFExtension bounds how much of a single GIF application extension is
+// kept to look for EXIF data in.
+const maxGIFExtension = 1 << 20
+
+// GetGIFOrientationTag returns the orientation tag of EXIF data stored in an
+// application extension of the GIF image in r, ahead of its first frame, as
+// some converters do.  GIF has no standard place for EXIF data, so this is a
+// best effort: any application extension whose data holds a TIFF structure,
+// with or without the Exif identifier in front of it, is taken to be EXIF
+// data.  NotGIFError is returned if r does not contain a GIF image, and
+// NoExifError if none of its extensions carry an orientation.  When finished,
+// the internal position in r will be at io.SeekStart.
+func GetGIFOrientationTag(r io.ReadSeeker) (uint16, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return 0, err
+	}
+
+	tag, found, err := gifOrientation(ra, size)
+	r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, NoExifError
+	}
+	if tag < 1 || tag > 8 {
+		tag = 1
+	}
+
+	return tag, nil
+}
+
+// gifOrientation looks for an orientation tag in the application extensions
+// preceding the first frame of the GIF image of the given size in r.  A
+// damaged GIF image is searched as far as it can be read.
+func gifOrientation(r io.ReaderAt, size int64) (uint16, bool, error) {
+	var header [13]byte
+	_, err := r.ReadAt(header[:], 0)
+	if err != nil || (string(header[:6]) != "GIF87a" && string(header[:6]) != "GIF89a") {
+		return 0, false, NotGIFError
+	}
+
+	// The logical screen descriptor may be followed by a global color table
+	// of up to 256 three byte entries.
+	offset := int64(len(header))
+	if header[10]&0x80 != 0 {
+		offset += 3 << (header[10]&7 + 1)
+	}
+
+	var buffer [2]byte
+	for offset < size {
+		_, err = r.ReadAt(buffer[:], offset)
+		if err != nil || buffer[0] != 0x21 {
+			// An image descriptor, the trailer or anything unexpected ends
+			// the search at the first frame.
+			return 0, false, nil
+		}
+
+		var data []byte
+		app := buffer[1] == 0xff
+		data, offset, err = gifSubBlocks(r, offset+2, app)
+		if err != nil {
+			return 0, false, nil
+		}
+		// The first 11 bytes are the application identifier and
+		// authentication code.
+		if app && len(data) > 11 {
+			if tag, ok := gifExifOrientation(data[11:]); ok {
+				return tag, true, nil
+			}
+		}
+	}
+
+	return 0, false, nil
+}
+
+// gifSubBlocks reads the sequence of data sub-blocks starting at offset in r,
+// returning their data if keep is set, and the offset following them.  At
+// most maxGIFExtension bytes of data are kept.
+func gifSubBlocks(r io.ReaderAt, offset int64, keep bool) ([]byte, int64, error) {
+	var data []byte
+	var length [1]byte
+	for {
+		_, err := r.ReadAt(length[:], offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset++
+		if length[0] == 0 {
+			return data, offset, nil
+		}
+
+		if keep && len(data)+int(length[0]) <= maxGIFExtension {
+			block := make([]byte, length[0])
+			_, err = r.ReadAt(block, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			data = append(data, block...)
+		}
+		offset += int64(length[0])
+	}
+}
+
+// gifExifOrientation returns the orientation tag of data, the data of a GIF
+// application extension, if it holds EXIF data.
+func gifExifOrientation(data []byte) (uint16, bool) {
+	if i := bytes.Index(data, exifHeader); i >= 0 {
+		data = data[i+len(exifHeader):]
+	}
+	if !hasTIFFHeader(data) {
+		return 0, false
+	}
+
+	return exifOrientation(data)
+}
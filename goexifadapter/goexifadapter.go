@@ -0,0 +1,54 @@
+// Package goexifadapter reads the orientation tag from EXIF data already
+// parsed by github.com/rwcarlsen/goexif, so that programs which parse it for
+// their own needs do not pay for exiflign scanning the stream a second time.
+// It is kept apart from package exiflign so that only programs using it
+// depend on goexif.
+package goexifadapter
+
+import (
+	"errors"
+	"image"
+
+	"github.com/luke-park/exiflign"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Tag returns the orientation tag of x.  exiflign.NoExifError is returned if
+// x has no orientation tag, or one outside the range 1 to 8.
+func Tag(x *exif.Exif) (uint16, error) {
+	if x == nil {
+		return 0, exiflign.NoExifError
+	}
+
+	t, err := x.Get(exif.Orientation)
+	var missing exif.TagNotPresentError
+	if errors.As(err, &missing) {
+		return 0, exiflign.NoExifError
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := t.Int(0)
+	if err != nil || v < 1 || v > 8 {
+		return 0, exiflign.NoExifError
+	}
+
+	return uint16(v), nil
+}
+
+// TransformForExif performs the transformation on img that the orientation
+// tag of x calls for, as exiflign.TransformForTag does, and returns the tag.
+// Images whose EXIF data has no valid orientation tag are returned as they
+// are, with a tag of 1.
+func TransformForExif(img image.Image, x *exif.Exif) (image.Image, uint16, error) {
+	tag, err := Tag(x)
+	if err == exiflign.NoExifError {
+		return img, 1, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return exiflign.TransformForTag(img, tag), tag, nil
+}
@@ -0,0 +1,81 @@
+// Package goexifv3adapter reads the orientation tag from EXIF data already
+// parsed by github.com/dsoprea/go-exif/v3, so that programs which parse it for
+// their own needs do not pay for exiflign scanning the stream a second time.
+// It is kept apart from package exiflign so that only programs using it
+// depend on go-exif.
+package goexifv3adapter
+
+import (
+	"errors"
+	"image"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	"github.com/luke-park/exiflign"
+)
+
+// orientationTagID is the ID of the orientation tag in IFD0.
+const orientationTagID = 0x0112
+
+// Tag returns the orientation tag of ifd, which should be IFD0, the root IFD
+// of the index returned by exif.Collect.  exiflign.NoExifError is returned if
+// ifd has no orientation tag, or one outside the range 1 to 8.
+func Tag(ifd *exif.Ifd) (uint16, error) {
+	if ifd == nil {
+		return 0, exiflign.NoExifError
+	}
+
+	entries, err := ifd.FindTagWithId(orientationTagID)
+	if errors.Is(err, exif.ErrTagNotFound) || (err == nil && len(entries) == 0) {
+		return 0, exiflign.NoExifError
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := entries[0].Value()
+	if err != nil {
+		return 0, exiflign.NoExifError
+	}
+
+	return tagValue(value)
+}
+
+// TagFromFlat returns the orientation tag among tags, as returned by
+// exif.GetFlatExifData, taking only that of IFD0 into account.
+// exiflign.NoExifError is returned if there is none, or one outside the range
+// 1 to 8.
+func TagFromFlat(tags []exif.ExifTag) (uint16, error) {
+	for _, t := range tags {
+		if t.TagId == orientationTagID && t.IfdPath == "IFD" {
+			return tagValue(t.Value)
+		}
+	}
+
+	return 0, exiflign.NoExifError
+}
+
+// tagValue returns the orientation tag held by value, as decoded by go-exif.
+func tagValue(value any) (uint16, error) {
+	v, ok := value.([]uint16)
+	if !ok || len(v) == 0 || v[0] < 1 || v[0] > 8 {
+		return 0, exiflign.NoExifError
+	}
+
+	return v[0], nil
+}
+
+// TransformForExif performs the transformation on img that the orientation
+// tag of ifd calls for, as exiflign.TransformForTag does, and returns the tag.
+// Images whose EXIF data has no valid orientation tag are returned as they
+// are, with a tag of 1.
+func TransformForExif(img image.Image, ifd *exif.Ifd) (image.Image, uint16, error) {
+	tag, err := Tag(ifd)
+	if err == exiflign.NoExifError {
+		return img, 1, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return exiflign.TransformForTag(img, tag), tag, nil
+}
@@ -0,0 +1,220 @@
+package exiflign
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// GPS IFD tag identifiers used by this package.
+const (
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// earthRadius is the mean radius of the Earth in metres.
+const earthRadius = 6371008.8
+
+var NoGPSError error = errors.New("The given file does not contain any GPS coordinates.")
+
+// GPS holds the location an image was taken at.
+type GPS struct {
+	// Latitude and Longitude are in decimal degrees, negative to the south
+	// and west.
+	Latitude  float64
+	Longitude float64
+
+	// Altitude is the altitude in metres, negative below sea level.  It is 0
+	// if the image does not record one.
+	Altitude float64
+}
+
+// ReadGPS returns the GPS coordinates of the JPEG image in r.  NoExifError is
+// returned if the image has no EXIF segment, and NoGPSError if it has no
+// coordinates.  When finished, the internal position in r will be at
+// io.SeekStart.
+func ReadGPS(r io.ReadSeeker) (*GPS, error) {
+	x, err := ReadExif(r)
+	if err != nil {
+		return nil, err
+	}
+	if x.GPS == nil {
+		return nil, NoGPSError
+	}
+
+	return x.GPS, nil
+}
+
+// parseGPS reads the coordinates from the GPS IFD at offset, returning nil if
+// there are none.
+func parseGPS(t *tiffReader, offset uint32) *GPS {
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return nil
+	}
+
+	var latRef, lonRef string
+	var lat, lon []float64
+	g := &GPS{}
+	below := false
+	for _, e := range entries {
+		switch e.tag {
+		case tagGPSLatitudeRef:
+			latRef = t.string(e)
+		case tagGPSLatitude:
+			lat = t.floats(e)
+		case tagGPSLongitudeRef:
+			lonRef = t.string(e)
+		case tagGPSLongitude:
+			lon = t.floats(e)
+		case tagGPSAltitudeRef:
+			below = t.uint(e, 0) == 1
+		case tagGPSAltitude:
+			g.Altitude = t.float(e, 0)
+		}
+	}
+	if len(lat) != 3 || len(lon) != 3 {
+		return nil
+	}
+
+	g.Latitude = lat[0] + lat[1]/60 + lat[2]/3600
+	if latRef == "S" {
+		g.Latitude = -g.Latitude
+	}
+	g.Longitude = lon[0] + lon[1]/60 + lon[2]/3600
+	if lonRef == "W" {
+		g.Longitude = -g.Longitude
+	}
+	if below {
+		g.Altitude = -g.Altitude
+	}
+
+	return g
+}
+
+// floats returns every value of a numeric entry as a float64.
+func (t *tiffReader) floats(e ifdEntry) []float64 {
+	values := make([]float64, e.count)
+	for i := range values {
+		values[i] = t.float(e, i)
+	}
+
+	return values
+}
+
+// Geofence is a circular area on the surface of the Earth.
+type Geofence struct {
+	// Latitude and Longitude are the centre of the area in decimal degrees.
+	Latitude  float64
+	Longitude float64
+
+	// Radius is the radius of the area in metres.
+	Radius float64
+}
+
+// Contains reports whether g lies within f.
+func (f Geofence) Contains(g *GPS) bool {
+	return haversine(f.Latitude, f.Longitude, g.Latitude, g.Longitude) <= f.Radius
+}
+
+// haversine returns the great-circle distance in metres between two points
+// given in decimal degrees.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dlat, dlon := (lat2-lat1)*rad, (lon2-lon1)*rad
+
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// inGeofence reports whether g lies within any of fences.
+func inGeofence(g *GPS, fences []Geofence) bool {
+	for _, f := range fences {
+		if f.Contains(g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactGPS empties the GPS IFD of the TIFF structure data in place, zeroing
+// its entries and their values, and reports whether there was one.  The
+// pointer to the IFD is kept, so that no other offsets in the data change.
+func redactGPS(data []byte) bool {
+	t, err := newTIFFReader(data)
+	if err != nil {
+		return false
+	}
+	ifd0, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return false
+	}
+
+	var offset uint32
+	for _, e := range ifd0 {
+		if e.tag == tagGPSIFD {
+			offset = t.uint(e, 0)
+		}
+	}
+	if offset == 0 {
+		return false
+	}
+
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		clear(e.value)
+	}
+
+	n := int(t.order.Uint16(data[offset:]))
+	clear(data[offset : int(offset)+2+12*n])
+	return true
+}
+
+// redactExif returns payload, an EXIF segment including its identifier, with
+// its GPS data redacted if it lies within one of o.Geofences, recording the
+// redaction in res.  payload is not modified, and is returned as is if
+// nothing was redacted.
+func (o *Options) redactExif(payload []byte, res *Result) []byte {
+	if len(o.Geofences) == 0 {
+		return payload
+	}
+
+	x, err := parseExif(payload[len(exifHeader):])
+	if err != nil || x.GPS == nil || !inGeofence(x.GPS, o.Geofences) {
+		return payload
+	}
+
+	redacted := append([]byte(nil), payload...)
+	if !redactGPS(redacted[len(exifHeader):]) {
+		return payload
+	}
+
+	res.GPSRedacted = true
+	return redacted
+}
+
+// editExif applies the EXIF edits requested by o to payload, an EXIF segment
+// including its identifier, recording what was done in res.  payload is not
+// modified.
+func (o *Options) editExif(payload []byte, res *Result) []byte {
+	if o.ExifVersion != "" || o.TimeShift != 0 {
+		payload = append([]byte(nil), payload...)
+	}
+	if o.ExifVersion != "" {
+		setExifVersion(payload[len(exifHeader):], o.ExifVersion)
+	}
+	if o.TimeShift != 0 {
+		shiftExifTimes(payload[len(exifHeader):], o.TimeShift)
+	}
+
+	return o.redactExif(payload, res)
+}
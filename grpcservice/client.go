@@ -0,0 +1,72 @@
+package grpcservice
+
+import (
+	"context"
+	"io"
+
+	"github.com/luke-park/exiflign"
+	"google.golang.org/grpc"
+)
+
+// Normalize normalizes the JPEG image in r with the Normalizer service
+// reached through conn, streaming it in chunks of DefaultChunkSize bytes, and
+// writes the normalized image to w as it arrives.  opts may be nil.  The
+// Result reports the fields carried by the Result message only.
+func Normalize(ctx context.Context, conn grpc.ClientConnInterface, r io.Reader, w io.Writer, opts *Options) (*exiflign.Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/Normalize", grpc.ForceCodec(codec{}))
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, DefaultChunkSize)
+	for first := true; ; first = false {
+		n, rerr := io.ReadFull(r, buffer)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return nil, rerr
+		}
+
+		if n > 0 || first {
+			req := &normalizeRequest{chunk: buffer[:n]}
+			if first {
+				req.options = opts
+			}
+			err = stream.SendMsg(req)
+			if err == io.EOF {
+				// The server ended the call, with an error RecvMsg reports.
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if rerr != nil {
+			break
+		}
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var resp normalizeResponse
+		err = stream.RecvMsg(&resp)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = w.Write(resp.chunk)
+		if err != nil {
+			return nil, err
+		}
+		if resp.result != nil {
+			return resp.result, nil
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package grpcservice provides the normalization of package exiflign as a
+// gRPC service, described by exiflign.proto, whose Normalize RPC streams
+// images in and out in chunks, so that scans of hundreds of megabytes are
+// never held in memory whole by either side.  It is kept apart from package
+// exiflign so that only programs using it depend on gRPC.
+package grpcservice
+
+import (
+	"io"
+	"os"
+
+	"github.com/luke-park/exiflign"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the full name of the Normalizer service.
+const ServiceName = "exiflign.v1.Normalizer"
+
+// DefaultChunkSize is the size of the chunks images are streamed in when
+// Server.ChunkSize is not set, and by Normalize.
+const DefaultChunkSize = 64 << 10
+
+// Server implements the Normalizer service.  The image received by each call
+// is spooled to a temporary file, as normalizing it requires seeking, and the
+// normalized image is sent back as it is produced, so the memory a call takes
+// beyond that of normalization itself is bounded by ChunkSize.
+type Server struct {
+	// Options controls how images are normalized, as amended by the Options
+	// of each call.
+	Options exiflign.Options
+
+	// ChunkSize is the largest number of bytes sent in a single response.
+	// If zero, DefaultChunkSize is used.
+	ChunkSize int
+
+	// MaxInputBytes, if positive, bounds the size of the images received.
+	// Calls sending larger images fail with codes.ResourceExhausted.
+	MaxInputBytes int64
+
+	// TempDir is the directory images are spooled to.  If empty, the
+	// default directory for temporary files is used.
+	TempDir string
+}
+
+// normalizer is the interface of the implementations of the service.
+type normalizer interface {
+	normalize(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*normalizer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Normalize",
+		Handler:       normalizeHandler,
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+	Metadata: "exiflign.proto",
+}
+
+func normalizeHandler(srv any, stream grpc.ServerStream) error {
+	return srv.(normalizer).normalize(stream)
+}
+
+// ServerOption returns the option that gRPC servers s is registered with must
+// be created with, so that they encode the messages of the service.  The
+// messages of other services are encoded as they would be otherwise.
+func ServerOption() grpc.ServerOption {
+	return grpc.ForceServerCodec(codec{})
+}
+
+// Register registers s with r, typically a *grpc.Server created with
+// ServerOption.
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	r.RegisterService(&serviceDesc, s)
+}
+
+// normalize serves a single call of the Normalize RPC.
+func (s *Server) normalize(stream grpc.ServerStream) error {
+	f, err := os.CreateTemp(s.TempDir, "exiflign-*.jpg")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	opts := s.Options
+	var size int64
+	for first := true; ; first = false {
+		var req normalizeRequest
+		err = stream.RecvMsg(&req)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if first && req.options != nil {
+			req.options.apply(&opts)
+		}
+		size += int64(len(req.chunk))
+		if s.MaxInputBytes > 0 && size > s.MaxInputBytes {
+			return status.Errorf(codes.ResourceExhausted, "The image is larger than %d bytes.", s.MaxInputBytes)
+		}
+		_, err = f.Write(req.chunk)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	chunkSize := s.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	w := &chunkWriter{stream: stream, chunk: make([]byte, 0, chunkSize)}
+	res, err := exiflign.NormalizeWithOptions(f, w, &opts)
+	if err != nil {
+		return err
+	}
+
+	return stream.SendMsg(&normalizeResponse{chunk: w.chunk, result: res})
+}
+
+// chunkWriter sends what is written to it to stream in responses of at most
+// the capacity of chunk, holding back the last one, which is sent along with
+// the result.
+type chunkWriter struct {
+	stream grpc.ServerStream
+	chunk  []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.chunk) == cap(w.chunk) {
+			err := w.stream.SendMsg(&normalizeResponse{chunk: w.chunk})
+			if err != nil {
+				return n - len(p), err
+			}
+			w.chunk = w.chunk[:0]
+		}
+
+		c := min(len(p), cap(w.chunk)-len(w.chunk))
+		w.chunk = append(w.chunk, p[:c]...)
+		p = p[c:]
+	}
+
+	return n, nil
+}
@@ -0,0 +1,300 @@
+package grpcservice
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/luke-park/exiflign"
+	"google.golang.org/grpc/encoding"
+)
+
+var MalformedMessageError error = errors.New("The given message is not a valid message of the Normalizer service.")
+
+// The wire types of the protobuf encoding used by the messages.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// message is implemented by the messages of the service, which encode
+// themselves as described by exiflign.proto.
+type message interface {
+	marshal() []byte
+	unmarshal(data []byte) error
+}
+
+// codec encodes the messages of the service, and defers any other message to
+// the protobuf codec registered with gRPC, so that other services of the same
+// server are unaffected.  It is named after that codec, as the messages are
+// protobuf messages as far as clients are concerned.
+type codec struct{}
+
+func (codec) Name() string {
+	return "proto"
+}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	if m, ok := v.(message); ok {
+		return m.marshal(), nil
+	}
+	if c := encoding.GetCodec("proto"); c != nil {
+		return c.Marshal(v)
+	}
+
+	return nil, fmt.Errorf("Cannot marshal %T, which is not a message of the Normalizer service.", v)
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(message); ok {
+		return m.unmarshal(data)
+	}
+	if c := encoding.GetCodec("proto"); c != nil {
+		return c.Unmarshal(data, v)
+	}
+
+	return fmt.Errorf("Cannot unmarshal %T, which is not a message of the Normalizer service.", v)
+}
+
+// Options selects how the server normalizes an image.  Fields left at their
+// zero values keep the settings of the server.
+type Options struct {
+	// Quality is the JPEG quality re-encoded images are encoded with.
+	Quality int
+
+	// Mode selects how tagged images are transformed.
+	Mode exiflign.Mode
+
+	// PreserveExif and PreserveICC carry the EXIF data and ICC profile of the
+	// original into re-encoded images.
+	PreserveExif bool
+	PreserveICC  bool
+}
+
+// apply sets the fields of opts that o sets.
+func (o *Options) apply(opts *exiflign.Options) {
+	if o.Quality != 0 {
+		opts.Quality = o.Quality
+	}
+	if o.Mode != exiflign.ModeReencode {
+		opts.Mode = o.Mode
+	}
+	opts.PreserveExif = opts.PreserveExif || o.PreserveExif
+	opts.PreserveICC = opts.PreserveICC || o.PreserveICC
+}
+
+func (o *Options) marshal() []byte {
+	var b []byte
+	b = appendVarintField(b, 1, uint64(int64(int32(o.Quality))))
+	b = appendVarintField(b, 2, uint64(o.Mode))
+	b = appendBoolField(b, 3, o.PreserveExif)
+	return appendBoolField(b, 4, o.PreserveICC)
+}
+
+func (o *Options) unmarshal(data []byte) error {
+	return parseFields(data, func(num, typ int, v uint64, field []byte) error {
+		switch {
+		case num == 1 && typ == wireVarint:
+			o.Quality = int(int32(v))
+		case num == 2 && typ == wireVarint:
+			if v > uint64(exiflign.ModePerfect) {
+				return MalformedMessageError
+			}
+			o.Mode = exiflign.Mode(v)
+		case num == 3 && typ == wireVarint:
+			o.PreserveExif = v != 0
+		case num == 4 && typ == wireVarint:
+			o.PreserveICC = v != 0
+		}
+		return nil
+	})
+}
+
+// normalizeRequest is the NormalizeRequest message.
+type normalizeRequest struct {
+	options *Options
+	chunk   []byte
+}
+
+func (r *normalizeRequest) marshal() []byte {
+	var b []byte
+	if r.options != nil {
+		b = appendBytesField(b, 1, r.options.marshal())
+	}
+	if len(r.chunk) > 0 {
+		b = appendBytesField(b, 2, r.chunk)
+	}
+
+	return b
+}
+
+func (r *normalizeRequest) unmarshal(data []byte) error {
+	return parseFields(data, func(num, typ int, v uint64, field []byte) error {
+		switch {
+		case num == 1 && typ == wireBytes:
+			r.options = &Options{}
+			return r.options.unmarshal(field)
+		case num == 2 && typ == wireBytes:
+			r.chunk = append(r.chunk[:0], field...)
+		}
+		return nil
+	})
+}
+
+// normalizeResponse is the NormalizeResponse message.
+type normalizeResponse struct {
+	chunk  []byte
+	result *exiflign.Result
+}
+
+func (r *normalizeResponse) marshal() []byte {
+	var b []byte
+	if len(r.chunk) > 0 {
+		b = appendBytesField(b, 1, r.chunk)
+	}
+	if r.result != nil {
+		b = appendBytesField(b, 2, marshalResult(r.result))
+	}
+
+	return b
+}
+
+func (r *normalizeResponse) unmarshal(data []byte) error {
+	return parseFields(data, func(num, typ int, v uint64, field []byte) error {
+		switch {
+		case num == 1 && typ == wireBytes:
+			r.chunk = append(r.chunk[:0], field...)
+		case num == 2 && typ == wireBytes:
+			r.result = &exiflign.Result{}
+			return unmarshalResult(field, r.result)
+		}
+		return nil
+	})
+}
+
+// marshalResult encodes the fields of res carried by the Result message.
+func marshalResult(res *exiflign.Result) []byte {
+	var b []byte
+	b = appendVarintField(b, 1, uint64(res.Orientation))
+	b = appendBoolField(b, 2, res.NoExif)
+	b = appendBoolField(b, 3, res.Copied)
+	b = appendBoolField(b, 4, res.Lossless)
+	if res.Method != exiflign.MethodNone {
+		b = appendBytesField(b, 5, []byte(res.Method.String()))
+	}
+
+	return b
+}
+
+// unmarshalResult decodes the Result message in data into res.
+func unmarshalResult(data []byte, res *exiflign.Result) error {
+	return parseFields(data, func(num, typ int, v uint64, field []byte) error {
+		switch {
+		case num == 1 && typ == wireVarint:
+			if v > 8 {
+				return MalformedMessageError
+			}
+			res.Orientation = uint16(v)
+		case num == 2 && typ == wireVarint:
+			res.NoExif = v != 0
+		case num == 3 && typ == wireVarint:
+			res.Copied = v != 0
+		case num == 4 && typ == wireVarint:
+			res.Lossless = v != 0
+		case num == 5 && typ == wireBytes:
+			res.Method = parseMethod(string(field))
+		}
+		return nil
+	})
+}
+
+// parseMethod returns the Method whose String is s, or MethodNone if there is
+// none.
+func parseMethod(s string) exiflign.Method {
+	for m := exiflign.MethodCopy; m <= exiflign.MethodReencode; m++ {
+		if m.String() == s {
+			return m
+		}
+	}
+
+	return exiflign.MethodNone
+}
+
+// appendVarintField appends the field num holding v to b, unless v is 0,
+// which proto3 leaves out.
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+// appendBoolField appends the field num holding v to b, unless v is false.
+func appendBoolField(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarintField(b, num, 1)
+}
+
+// appendBytesField appends the field num holding data to b, which may be an
+// encoded message.
+func appendBytesField(b []byte, num int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// parseFields calls fn with every field of the message in data, along with
+// its wire type and value, which is v for varints and field for bytes.
+// Fields of other wire types are skipped, as unknown fields are.
+func parseFields(data []byte, fn func(num, typ int, v uint64, field []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 {
+			return MalformedMessageError
+		}
+		data = data[n:]
+
+		num, typ := int(key>>3), int(key&7)
+		var v uint64
+		var field []byte
+		switch typ {
+		case wireVarint:
+			v, n = binary.Uvarint(data)
+			if n <= 0 {
+				return MalformedMessageError
+			}
+			data = data[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return MalformedMessageError
+			}
+			field = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if typ == wireFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return MalformedMessageError
+			}
+			data = data[size:]
+			continue
+		default:
+			return MalformedMessageError
+		}
+
+		err := fn(num, typ, v, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,288 @@
+package exiflign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Headers through which callers of NormalizeHandler override its options.
+// Each can also be given as a query parameter: quality, metadata and
+// max_size respectively.  Headers take precedence over query parameters.
+const (
+	QualityHeader  = "X-Exiflign-Quality"
+	MetadataHeader = "X-Exiflign-Metadata"
+	MaxSizeHeader  = "X-Exiflign-Max-Size"
+)
+
+// NormalizeHandlerOptions controls the behaviour of NormalizeHandler.  A nil
+// *NormalizeHandlerOptions is equivalent to the zero value.  The bounds limit
+// what callers may override, so that a single deployment can serve callers
+// with different needs without any of them being able to exhaust it.
+type NormalizeHandlerOptions struct {
+	// Options controls how images are normalized when callers do not
+	// override it.
+	Options
+
+	// MaxBytes, if positive, is the largest request body accepted.  Larger
+	// bodies are answered with 413 Request Entity Too Large.
+	MaxBytes int64
+
+	// MinQuality and MaxQuality bound the JPEG quality callers may request.
+	// If MaxQuality is zero, callers may not override the quality.
+	MinQuality int
+	MaxQuality int
+
+	// MaxSize is the largest size callers may request, as the largest the
+	// width and height of the output may be.  If zero, callers may not
+	// request a size.
+	MaxSize int
+
+	// AllowMetadata lets callers choose whether metadata is kept, with the
+	// value "keep", which preserves the EXIF data, ICC profile, IPTC data
+	// and comments, or "strip", which re-encodes the image without any
+	// metadata, even if it needs no correction.
+	AllowMetadata bool
+
+	// Encoders adds output formats that callers may ask for in their Accept
+	// header, by media type, such as an Encoder wrapping a WebP encoder for
+	// "image/webp".  JPEG is always available and an entry for
+	// "image/jpeg" is ignored, and PNG is available through PNGEncoder
+	// unless Encoders has an entry for "image/png".
+	Encoders map[string]Encoder
+}
+
+// normalizeHandler is the handler returned by NormalizeHandler.
+type normalizeHandler struct {
+	opts *NormalizeHandlerOptions
+}
+
+// NormalizeHandler returns a handler that answers POST requests whose body is
+// a JPEG image with the normalized image.  Callers may override the quality,
+// the metadata policy and the size of the output, within the bounds of opts,
+// through the headers above, and are answered with 400 Bad Request if they
+// ask for more.  The output format is negotiated through the Accept header,
+// preferring JPEG when the caller has no preference, and callers accepting
+// none of the available formats are answered with 406 Not Acceptable.  Images
+// resized on request or output in a format other than JPEG are re-encoded
+// without any metadata, like thumbnails.  Bodies that are not JPEG images are
+// answered with 415 Unsupported Media Type, and images quarantined under
+// Options.QuarantinePolicy with 422 Unprocessable Entity.
+func NormalizeHandler(opts *NormalizeHandlerOptions) http.Handler {
+	if opts == nil {
+		opts = &NormalizeHandlerOptions{}
+	}
+
+	return &normalizeHandler{opts: opts}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *normalizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts, reencode, maxDim, err := h.requestOptions(r)
+	if err != nil {
+		http.Error(w, "400 Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+	typ, ok := negotiate(r.Header.Get("Accept"), h.types())
+	if !ok {
+		http.Error(w, "406 Not Acceptable", http.StatusNotAcceptable)
+		return
+	}
+	var enc Encoder
+	if typ != "image/jpeg" {
+		reencode, enc = true, h.encoder(typ)
+	}
+
+	body := r.Body
+	if h.opts.MaxBytes > 0 {
+		body = http.MaxBytesReader(w, body, h.opts.MaxBytes)
+	}
+	data, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "413 Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	var buffer bytes.Buffer
+	rs := bytes.NewReader(data)
+	_, err = GetInfoAt(rs, int64(len(data)))
+	if err == nil && !reencode {
+		_, err = NormalizeWithOptions(rs, &buffer, opts)
+	} else if err == nil && opts.QuarantinePolicy != nil {
+		err = opts.quarantine(rs)
+	}
+	if err == nil && reencode {
+		p := &Pipeline{Options: *opts, Outputs: []Output{{W: &buffer, MaxDim: maxDim, Encoder: enc}}}
+		_, err = p.Run(rs)
+	}
+	switch {
+	case err == NotJPEGError:
+		http.Error(w, "415 Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	case err == QuarantinedError:
+		http.Error(w, "422 Unprocessable Entity", http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", typ)
+	w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	w.Write(buffer.Bytes())
+}
+
+// requestOptions returns the options r asks for, whether the image must be
+// re-encoded without metadata through a Pipeline, and the size it asks for,
+// or zero if it asks for none.  An error describes an override that is not
+// allowed.
+func (h *normalizeHandler) requestOptions(r *http.Request) (*Options, bool, int, error) {
+	opts := h.opts.Options
+
+	if v := requestParam(r, QualityHeader, "quality"); v != "" {
+		q, err := strconv.Atoi(v)
+		if err != nil || h.opts.MaxQuality == 0 || q < h.opts.MinQuality || q > h.opts.MaxQuality {
+			return nil, false, 0, fmt.Errorf("quality %q is not allowed", v)
+		}
+		opts.Quality = q
+	}
+
+	var reencode bool
+	switch v := requestParam(r, MetadataHeader, "metadata"); {
+	case v == "":
+	case !h.opts.AllowMetadata:
+		return nil, false, 0, fmt.Errorf("metadata %q is not allowed", v)
+	case v == "keep":
+		opts.PreserveExif, opts.PreserveICC, opts.PreserveIPTC = true, true, true
+		opts.Segments = KeepAll
+		opts.Comments = CommentsPreserve
+	case v == "strip":
+		reencode = true
+	default:
+		return nil, false, 0, fmt.Errorf("unknown metadata policy %q", v)
+	}
+
+	var maxDim int
+	if v := requestParam(r, MaxSizeHeader, "max_size"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d <= 0 || d > h.opts.MaxSize {
+			return nil, false, 0, fmt.Errorf("max_size %q is not allowed", v)
+		}
+		reencode, maxDim = true, d
+	}
+
+	return &opts, reencode, maxDim, nil
+}
+
+// types returns the media types h can output, in order of preference.
+func (h *normalizeHandler) types() []string {
+	types := []string{"image/jpeg", "image/png"}
+	var extra []string
+	for typ := range h.opts.Encoders {
+		if typ != "image/jpeg" && typ != "image/png" {
+			extra = append(extra, typ)
+		}
+	}
+	sort.Strings(extra)
+
+	return append(types, extra...)
+}
+
+// encoder returns the Encoder of h for the media type typ, other than JPEG.
+func (h *normalizeHandler) encoder(typ string) Encoder {
+	if enc, ok := h.opts.Encoders[typ]; ok {
+		return enc
+	}
+
+	return PNGEncoder{}
+}
+
+// negotiate picks the media type of types that the Accept header accept
+// prefers, and reports whether it accepts any of them.  Types accept rates
+// equally are picked by how specifically it names them, and then in the
+// order of types.  An empty header accepts anything.
+func negotiate(accept string, types []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return types[0], true
+	}
+
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, typ := range types {
+		q, specificity := acceptQuality(accept, typ)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = typ, q, specificity
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// acceptQuality returns the quality value the Accept header accept gives the
+// media type typ, from its most specific range matching typ, and how
+// specific that range is: 2 for typ itself, 1 for its type with a wildcard
+// subtype and 0 for */*.  It returns -1 for the specificity if no range
+// matches.
+func acceptQuality(accept, typ string) (float64, int) {
+	major, _, _ := strings.Cut(typ, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		rng := strings.ToLower(strings.TrimSpace(params[0]))
+
+		s := -1
+		switch rng {
+		case typ:
+			s = 2
+		case major + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+
+		q, specificity = 1, s
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.ToLower(strings.TrimSpace(k)) != "q" {
+				continue
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || f < 0 || f > 1 {
+				f = 0
+			}
+			q = f
+		}
+	}
+
+	return q, specificity
+}
+
+// requestParam returns the value of the header of r, or of its query
+// parameter if the header is not set.
+func requestParam(r *http.Request, header, param string) string {
+	if v := r.Header.Get(header); v != "" {
+		return v
+	}
+
+	return r.URL.Query().Get(param)
+}
@@ -0,0 +1,119 @@
+package exiflign
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// FileServerOptions controls the behaviour of FileServer.  A nil
+// *FileServerOptions is equivalent to the zero value.
+type FileServerOptions struct {
+	// Options controls how each image is normalized.
+	Options
+
+	// ResponseCache, if non-nil, holds normalized images between requests,
+	// keyed by the name, modification time and size of each file so that a
+	// hit reads nothing from fsys.  Unlike Options.Cache, which is keyed by
+	// the content of the original and consulted as each image is normalized,
+	// it is consulted before the file is read.
+	ResponseCache ResultCache
+}
+
+// fileServer is the handler returned by FileServer.
+type fileServer struct {
+	fsys  fs.FS
+	files http.Handler
+	opts  *FileServerOptions
+}
+
+// FileServer returns a handler that serves HTTP requests with the contents of
+// fsys, exactly as http.FileServer(http.FS(fsys)) would, except that files
+// with a .jpg or .jpeg extension are normalized before being served.  This
+// makes it a drop-in replacement for galleries whose originals are stored with
+// their EXIF orientation intact.  Range and conditional requests are
+// supported for normalized images.  Images quarantined under
+// Options.QuarantinePolicy are answered with 422 Unprocessable Entity.
+func FileServer(fsys fs.FS, opts *FileServerOptions) http.Handler {
+	if opts == nil {
+		opts = &FileServerOptions{}
+	}
+
+	return &fileServer{fsys: fsys, files: http.FileServer(http.FS(fsys)), opts: opts}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if !isJPEGName(name) || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+
+	key := fmt.Sprintf("%s:%d:%d", name, info.ModTime().UnixNano(), info.Size())
+	data, ok := s.cached(key)
+	if !ok {
+		data, err = normalizeFile(f, &s.opts.Options)
+		if err == QuarantinedError {
+			http.Error(w, "422 Unprocessable Entity", http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if s.opts.ResponseCache != nil {
+			s.opts.ResponseCache.Put(key, data)
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(data))
+}
+
+// cached looks key up in the cache, if there is one.
+func (s *fileServer) cached(key string) ([]byte, bool) {
+	if s.opts.ResponseCache == nil {
+		return nil, false
+	}
+
+	return s.opts.ResponseCache.Get(key)
+}
+
+// normalizeFile normalizes the contents of f into memory.  If f cannot seek,
+// it is read into memory first.
+func normalizeFile(f fs.File, opts *Options) ([]byte, error) {
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		rs = bytes.NewReader(data)
+	}
+
+	var buffer bytes.Buffer
+	_, err := NormalizeWithOptions(rs, &buffer, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,225 @@
+package exiflign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+var iccHeader = []byte("ICC_PROFILE\x00")
+
+var NoICCProfileError error = errors.New("The given file does not contain an ICC profile.")
+var IncompleteICCProfileError error = errors.New("The ICC profile of the given file is missing some of its chunks.")
+
+// maxICCChunk is the largest part of an ICC profile that fits in a single APP2
+// segment, after the identifier and the sequence number and count bytes.
+const maxICCChunk = maxSegmentPayload - 14
+
+// ColorManager converts images between color spaces described by ICC
+// profiles.  No implementation is provided by this package, callers are
+// expected to wrap a color management system such as LittleCMS.
+type ColorManager interface {
+	// ToSRGB converts img, whose colors are described by profile, to sRGB.
+	ToSRGB(img image.Image, profile []byte) (image.Image, error)
+}
+
+// KeyedColorManager is a ColorManager that identifies its settings, such as
+// its rendering intent, so that the images it converts can be kept in
+// Options.Cache.
+type KeyedColorManager interface {
+	ColorManager
+
+	// CacheKey returns a string that differs between ColorManagers that
+	// would convert images differently.
+	CacheKey() string
+}
+
+// colorManagerKey identifies cm in cache keys by its type and, for a
+// KeyedColorManager, its settings.
+func colorManagerKey(cm ColorManager) string {
+	if k, ok := cm.(KeyedColorManager); ok {
+		return fmt.Sprintf("%T(%q)", k, k.CacheKey())
+	}
+
+	return fmt.Sprintf("%T", cm)
+}
+
+// ReadICCProfile returns the ICC profile embedded in the JPEG image in r,
+// reassembled from however many APP2 segments it was split across.
+// NoICCProfileError is returned if there is none, and
+// IncompleteICCProfileError if some of its chunks are missing, rather than a
+// truncated profile.  When finished, the internal
+// position in r will be at io.SeekStart.
+func ReadICCProfile(r io.ReadSeeker) ([]byte, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := readICCProfileAt(ra, size)
+	_, serr := r.Seek(0, io.SeekStart)
+	if err == nil {
+		err = serr
+	}
+
+	return profile, err
+}
+
+// iccChunk is a single APP2 segment of an ICC profile.
+type iccChunk struct {
+	seq   int
+	count int
+	data  []byte
+}
+
+// readICCProfileAt collects and reassembles the ICC profile chunks of the JPEG
+// image of the given size in r.
+func readICCProfileAt(r io.ReaderAt, size int64) ([]byte, error) {
+	var chunks []iccChunk
+	var serr error
+
+	err := walkSegments(r, size, func(s segment) bool {
+		if s.marker != markerAPP2 || s.length < len(iccHeader)+2 {
+			return true
+		}
+
+		var payload []byte
+		payload, serr = readSegment(r, s)
+		if serr != nil {
+			return false
+		}
+		if !bytes.HasPrefix(payload, iccHeader) {
+			return true
+		}
+
+		chunks = append(chunks, iccChunk{
+			seq:   int(payload[len(iccHeader)]),
+			count: int(payload[len(iccHeader)+1]),
+			data:  payload[len(iccHeader)+2:],
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	if len(chunks) == 0 {
+		return nil, NoICCProfileError
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].seq < chunks[j].seq
+	})
+
+	// Some writers repeat chunks, which is harmless as long as every chunk
+	// from 1 to the count is present.
+	var profile []byte
+	next := 1
+	for _, c := range chunks {
+		if c.seq == next-1 {
+			continue
+		}
+		if c.seq != next || c.count != chunks[0].count {
+			return nil, IncompleteICCProfileError
+		}
+		profile = append(profile, c.data...)
+		next++
+	}
+	if next-1 != chunks[0].count {
+		return nil, IncompleteICCProfileError
+	}
+
+	return profile, nil
+}
+
+// iccSegments splits profile into as many APP2 segments as it needs.
+// Profiles too large for the 255 segments that sequence numbers allow are
+// not split, and nil is returned.
+func iccSegments(profile []byte) []jpegenc.Segment {
+	count := (len(profile) + maxICCChunk - 1) / maxICCChunk
+	if count == 0 || count > 255 {
+		return nil
+	}
+
+	segments := make([]jpegenc.Segment, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := profile[i*maxICCChunk : min((i+1)*maxICCChunk, len(profile))]
+
+		payload := make([]byte, 0, len(iccHeader)+2+len(chunk))
+		payload = append(payload, iccHeader...)
+		payload = append(payload, byte(i+1), byte(count))
+		payload = append(payload, chunk...)
+		segments = append(segments, jpegenc.Segment{Marker: markerAPP2, Payload: payload})
+	}
+
+	return segments
+}
+
+// isSRGBProfile reports whether profile looks like an sRGB profile, in which
+// case no conversion is needed.  Profiles are recognized by their description,
+// which is how most software identifies the many sRGB variants in the wild.
+func isSRGBProfile(profile []byte) bool {
+	return strings.Contains(strings.ToLower(iccDescription(profile)), "srgb")
+}
+
+// iccDescription extracts the profile description ('desc' tag) of an ICC
+// profile, or "" if it cannot be found.
+func iccDescription(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+
+	be := func(b []byte) int {
+		return int(b[0])<<24 | int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+	}
+
+	n := be(profile[128:])
+	for i := 0; i < n && 132+12*i+12 <= len(profile); i++ {
+		entry := profile[132+12*i:]
+		if string(entry[:4]) != "desc" {
+			continue
+		}
+
+		offset, size := be(entry[4:]), be(entry[8:])
+		if offset < 0 || size < 12 || offset+size > len(profile) {
+			return ""
+		}
+		tag := profile[offset : offset+size]
+
+		switch string(tag[:4]) {
+		case "desc":
+			// ICC v2 textDescriptionType: an ASCII count and string.
+			count := be(tag[8:])
+			if count <= 0 || 12+count > len(tag) {
+				return ""
+			}
+			return strings.TrimRight(string(tag[12:12+count]), "\x00")
+
+		case "mluc":
+			// ICC v4 multiLocalizedUnicodeType: use the first record, which
+			// is UTF-16BE.
+			if len(tag) < 28 {
+				return ""
+			}
+			length, start := be(tag[20:]), be(tag[24:])
+			if start+length > len(tag) {
+				return ""
+			}
+			var sb strings.Builder
+			for j := start; j+1 < start+length; j += 2 {
+				sb.WriteRune(rune(tag[j])<<8 | rune(tag[j+1]))
+			}
+			return sb.String()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,110 @@
+package exiflign
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Info describes a JPEG image as far as can be determined from its headers,
+// without decoding any pixel data.
+type Info struct {
+	// Width and Height are the dimensions of the image as stored, before any
+	// orientation transformation.
+	Width  int
+	Height int
+
+	// Orientation is the orientation tag of the image, between 1 and 8
+	// inclusive.  It is 1 when the image has no orientation information.
+	Orientation uint16
+
+	// HasExif is set when the image has EXIF orientation information.
+	HasExif bool
+
+	// LittleEndian is set when the EXIF data is little-endian encoded.  It is
+	// only meaningful when HasExif is set.
+	LittleEndian bool
+}
+
+// DisplaySize returns the dimensions of the image once its orientation tag has
+// been applied, which swaps the width and height for tags 5 to 8.
+func (i *Info) DisplaySize() (int, int) {
+	if i.Orientation >= 5 && i.Orientation <= 8 {
+		return i.Height, i.Width
+	}
+
+	return i.Width, i.Height
+}
+
+// GetInfoAt reads the headers of the JPEG image of the given size in r, up to
+// its first frame header, and returns what they describe.  Only the marker
+// headers, the EXIF segment and the frame header are read.  NotJPEGError is
+// returned if r does not contain a JPEG image.
+func GetInfoAt(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Orientation: 1}
+
+	var serr error
+	var frame bool
+	err := walkSegments(r, size, func(s segment) bool {
+		switch {
+		case s.marker == markerAPP1 && !info.HasExif && s.length >= len(exifHeader):
+			var payload []byte
+			payload, serr = readSegment(r, s)
+			if serr != nil {
+				return false
+			}
+			payload = standardExifPayload(s.marker, payload)
+			if !isExifSegment(s.marker, payload) {
+				return true
+			}
+			payload, serr = appendExifContinuation(r, s, payload)
+			if serr != nil {
+				return false
+			}
+
+			t, err := newTIFFReader(payload[len(exifHeader):])
+			if err != nil {
+				return true
+			}
+			tag, ok := exifOrientation(payload[len(exifHeader):])
+			if !ok {
+				return true
+			}
+
+			info.HasExif = true
+			info.LittleEndian = t.littleEndian()
+			if tag >= 1 && tag <= 8 {
+				info.Orientation = tag
+			}
+
+			return !frame
+
+		case isSOF(s.marker) && s.length >= 5:
+			var header [5]byte
+			_, serr = r.ReadAt(header[:], s.offset)
+			if serr != nil {
+				return false
+			}
+
+			info.Height = int(binary.BigEndian.Uint16(header[1:]))
+			info.Width = int(binary.BigEndian.Uint16(header[3:]))
+			frame = true
+
+			// Application segments usually precede the frame header, but may
+			// legally follow it, so only stop if the EXIF data has been found.
+			return !info.HasExif
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	if !frame {
+		return nil, NotJPEGError
+	}
+
+	return info, nil
+}
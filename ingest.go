@@ -0,0 +1,181 @@
+package exiflign
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+)
+
+var TooLargeError error = errors.New("The given file exceeds the maximum size.")
+
+// Ingester accepts uploaded images on behalf of a web backend, taking each
+// through validation, normalization, thumbnailing and storage in a single
+// call to Ingest.  An Ingester is safe for concurrent use as long as its
+// fields are not modified.
+type Ingester struct {
+	// Options controls how images are validated, through QuarantinePolicy,
+	// how they are normalized, and which of their metadata is kept, through
+	// PreserveExif, Segments, Comments, Geofences and the like.  Thumbnails
+	// never carry any metadata.
+	Options
+
+	// Storage receives the normalized image and its thumbnails.
+	Storage Storage
+
+	// MaxBytes, if positive, is the largest an uploaded file may be.  Larger
+	// files are rejected with TooLargeError after reading no more than
+	// MaxBytes+1 bytes of them.
+	MaxBytes int64
+
+	// Thumbnails lists the thumbnails stored alongside every image.
+	Thumbnails []IngestThumbnail
+}
+
+// IngestThumbnail requests a thumbnail from an Ingester.
+type IngestThumbnail struct {
+	// Suffix is inserted before the extension of the image's name to name
+	// the thumbnail, so that "photo.jpg" with Suffix "-256" is stored as
+	// "photo-256.jpg".  Suffixes must be distinct and not empty.
+	Suffix string
+
+	// MaxDim is the largest the width and height of the thumbnail may be.
+	MaxDim int
+}
+
+// IngestResult reports what Ingest did with an image.
+type IngestResult struct {
+	// Name is the name the normalized image was stored under.
+	Name string
+
+	// Size is the size of the stored image in bytes.
+	Size int64
+
+	// Width and Height are the dimensions of the stored image.
+	Width  int
+	Height int
+
+	// Hash is the hash of the stored image, suitable as an ETag.
+	Hash Hash
+
+	// Original holds the EXIF data of the upload before its metadata was
+	// rewritten, or nil if it had none that could be parsed.
+	Original *Exif
+
+	// Result reports how the image was normalized.
+	Result *Result
+
+	// Thumbnails describes the stored thumbnails, in the order of
+	// Ingester.Thumbnails.
+	Thumbnails []StoredThumbnail
+}
+
+// StoredThumbnail describes a thumbnail stored by Ingest.
+type StoredThumbnail struct {
+	Name   string
+	Size   int64
+	Width  int
+	Height int
+}
+
+// Ingest reads the JPEG image in r, normalizes it, and stores it and its
+// thumbnails in i.Storage, the image under name and the thumbnails under the
+// names described by IngestThumbnail.  Files that are too large, are not
+// JPEG images or are quarantined are rejected with TooLargeError,
+// NotJPEGError or QuarantinedError before anything is stored.  The
+// thumbnails are stored before the image, each through a temporary object
+// like NormalizeStorage does, so that once the image exists every thumbnail
+// does too.  If storing fails part way, the objects already stored are left
+// in place.
+func (i *Ingester) Ingest(ctx context.Context, r io.Reader, name string) (*IngestResult, error) {
+	if i.MaxBytes > 0 {
+		r = io.LimitReader(r, i.MaxBytes+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if i.MaxBytes > 0 && int64(len(data)) > i.MaxBytes {
+		return nil, TooLargeError
+	}
+
+	_, err = GetInfoAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	// The data is in memory, so the only errors are missing or unparseable
+	// EXIF data, which normalization copes with.
+	original, _ := ReadExifAt(bytes.NewReader(data), int64(len(data)))
+
+	var normalized bytes.Buffer
+	res, err := NormalizeWithOptions(bytes.NewReader(data), &normalized, &i.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbs := make([]bytes.Buffer, len(i.Thumbnails))
+	if len(i.Thumbnails) > 0 {
+		specs := make([]ThumbnailSpec, len(i.Thumbnails))
+		for j, t := range i.Thumbnails {
+			specs[j] = ThumbnailSpec{W: &thumbs[j], MaxDim: t.MaxDim}
+		}
+		err = Thumbnails(bytes.NewReader(data), specs, &ThumbnailOptions{Options: i.Options})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := &IngestResult{Name: name, Original: original, Result: res}
+	for j, t := range i.Thumbnails {
+		thumb := StoredThumbnail{Name: thumbnailName(name, t.Suffix)}
+		thumb.Size, thumb.Width, thumb.Height, _, err = i.store(ctx, thumb.Name, thumbs[j].Bytes())
+		if err != nil {
+			return nil, err
+		}
+		out.Thumbnails = append(out.Thumbnails, thumb)
+	}
+	out.Size, out.Width, out.Height, out.Hash, err = i.store(ctx, name, normalized.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// store writes data to the object name of i.Storage through a temporary
+// object, and returns its size, dimensions and hash.
+func (i *Ingester) store(ctx context.Context, name string, data []byte) (int64, int, int, Hash, error) {
+	info, err := GetInfoAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0, 0, 0, Hash{}, err
+	}
+	h, err := HashOf(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, Hash{}, err
+	}
+
+	w, err := i.Storage.Create(ctx, name+storageTempSuffix)
+	if err != nil {
+		return 0, 0, 0, Hash{}, err
+	}
+	_, err = w.Write(data)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = i.Storage.Rename(ctx, name+storageTempSuffix, name)
+	}
+	if err != nil {
+		return 0, 0, 0, Hash{}, err
+	}
+
+	return int64(len(data)), info.Width, info.Height, h, nil
+}
+
+// thumbnailName returns the name of the thumbnail of the image name with the
+// given suffix.
+func thumbnailName(name, suffix string) string {
+	ext := path.Ext(name)
+	return name[:len(name)-len(ext)] + suffix + ext
+}
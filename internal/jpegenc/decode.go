@@ -0,0 +1,210 @@
+package jpegenc
+
+// huffmanDecoder decodes symbols of a canonical Huffman code.
+type huffmanDecoder struct {
+	// minCode and maxCode are the smallest and largest codes of each length,
+	// with maxCode -1 for lengths without codes, and valPtr the index into
+	// values of the symbol for minCode.
+	minCode [17]int32
+	maxCode [17]int32
+	valPtr  [17]int32
+	values  []byte
+}
+
+func newHuffmanDecoder(spec huffmanSpec) *huffmanDecoder {
+	d := &huffmanDecoder{values: append([]byte(nil), spec.values...)}
+
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(spec.counts[l-1])
+		d.valPtr[l] = k
+		d.minCode[l] = code
+		d.maxCode[l] = -1
+		if n > 0 {
+			d.maxCode[l] = code + n - 1
+		}
+		code = (code + n) << 1
+		k += n
+	}
+
+	return d
+}
+
+// bitReader reads the entropy-coded data of a scan, removing stuffed zero
+// bytes.
+type bitReader struct {
+	data []byte
+	pos  int
+	bits uint32
+	n    uint
+
+	// marker is set once a marker has been reached, after which zero bits
+	// are supplied, as decoders conventionally do for truncated data.
+	marker bool
+}
+
+func (b *bitReader) bit() (int32, bool) {
+	if b.n == 0 {
+		if b.pos >= len(b.data) {
+			return 0, false
+		}
+
+		c := byte(0)
+		if !b.marker {
+			c = b.data[b.pos]
+			if c == 0xff {
+				if b.pos+1 < len(b.data) && b.data[b.pos+1] == 0 {
+					b.pos += 2
+				} else {
+					b.marker = true
+					c = 0
+				}
+			} else {
+				b.pos++
+			}
+		}
+		b.bits, b.n = uint32(c), 8
+	}
+
+	b.n--
+	return int32(b.bits>>b.n) & 1, true
+}
+
+// receive reads an s bit value and extends its sign.
+func (b *bitReader) receive(s int) (int32, bool) {
+	v := int32(0)
+	for i := 0; i < s; i++ {
+		bit, ok := b.bit()
+		if !ok {
+			return 0, false
+		}
+		v = v<<1 | bit
+	}
+
+	if s > 0 && v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+
+	return v, true
+}
+
+func (b *bitReader) decode(d *huffmanDecoder) (byte, bool) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		bit, ok := b.bit()
+		if !ok {
+			return 0, false
+		}
+		code = code<<1 | bit
+
+		if d.maxCode[l] >= 0 && code <= d.maxCode[l] && code >= d.minCode[l] {
+			i := d.valPtr[l] + code - d.minCode[l]
+			if int(i) >= len(d.values) {
+				return 0, false
+			}
+			return d.values[i], true
+		}
+	}
+
+	return 0, false
+}
+
+// restart skips the restart marker expected at the current position,
+// discarding any bits left in the current byte.
+func (b *bitReader) restart() bool {
+	b.n = 0
+	b.marker = false
+	if b.pos+1 >= len(b.data) || b.data[b.pos] != 0xff {
+		return false
+	}
+	for b.pos < len(b.data) && b.data[b.pos] == 0xff {
+		b.pos++
+	}
+	if b.pos >= len(b.data) || b.data[b.pos] < 0xd0 || b.data[b.pos] > 0xd7 {
+		return false
+	}
+	b.pos++
+
+	return true
+}
+
+// decodeScan decodes the entropy-coded data of the frame's single scan into
+// the coefficients of its components.
+func (f *frame) decodeScan(data []byte) bool {
+	b := &bitReader{data: data}
+	preds := make([]int32, len(f.comps))
+
+	mx, my := f.mcus()
+	for m := 0; m < mx*my; m++ {
+		if f.restart > 0 && m > 0 && m%f.restart == 0 {
+			if !b.restart() {
+				return false
+			}
+			clear(preds)
+		}
+
+		x, y := m%mx, m/mx
+		for i := range f.comps {
+			c := &f.comps[i]
+			if len(f.comps) == 1 {
+				if !f.decodeBlock(b, c, &c.blocks[y*c.bw+x], &preds[i]) {
+					return false
+				}
+				continue
+			}
+
+			for v := 0; v < c.v; v++ {
+				for h := 0; h < c.h; h++ {
+					block := &c.blocks[(y*c.v+v)*c.bw+x*c.h+h]
+					if !f.decodeBlock(b, c, block, &preds[i]) {
+						return false
+					}
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// decodeBlock decodes a single block of quantized coefficients into block in
+// natural order, updating the DC predictor pred.
+func (f *frame) decodeBlock(b *bitReader, c *component, block *[64]int32, pred *int32) bool {
+	s, ok := b.decode(f.huffman[0][c.td])
+	if !ok || s > 11 {
+		return false
+	}
+	diff, ok := b.receive(int(s))
+	if !ok {
+		return false
+	}
+	*pred += diff
+	block[0] = *pred
+
+	for k := 1; k < 64; k++ {
+		rs, ok := b.decode(f.huffman[1][c.ta])
+		if !ok {
+			return false
+		}
+
+		r, s := int(rs>>4), int(rs&15)
+		if s == 0 {
+			if r != 15 {
+				break
+			}
+			k += 15
+			continue
+		}
+
+		k += r
+		if k > 63 {
+			return false
+		}
+		block[zigzag[k]], ok = b.receive(s)
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
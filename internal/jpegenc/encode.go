@@ -0,0 +1,496 @@
+// Package jpegenc implements a baseline JPEG encoder that, unlike
+// image/jpeg, allows the chroma subsampling and quantization tables of its
+// output to be chosen, along with lossless transformation of baseline JPEG
+// images in the DCT domain.
+package jpegenc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Sampling gives the horizontal and vertical sampling factors of the luma
+// component relative to the chroma components, for example {2, 2} for 4:2:0.
+type Sampling struct {
+	H int
+	V int
+}
+
+// Standard chroma subsamplings.
+var (
+	Sampling444 = Sampling{1, 1}
+	Sampling422 = Sampling{2, 1}
+	Sampling420 = Sampling{2, 2}
+	Sampling440 = Sampling{1, 2}
+)
+
+// Options controls the output of Encode.
+type Options struct {
+	// Quality is used to scale the standard quantization tables, between 1
+	// and 100 inclusive.  It is ignored if Quant is set.
+	Quality int
+
+	// Sampling is the chroma subsampling of color images.  It is ignored for
+	// grayscale images.  If zero, Sampling420 is used.
+	Sampling Sampling
+
+	// Quant, if non-nil, gives the luminance and chrominance quantization
+	// tables to use in natural order, instead of scaling the standard tables.
+	Quant *[2][64]uint16
+}
+
+var errInvalidSampling = errors.New("jpegenc: unsupported sampling factors")
+var errInvalidSize = errors.New("jpegenc: image dimensions must be between 1 and 65535")
+
+// Encode writes img to w as a baseline JPEG image.  *image.Gray images are
+// written with a single component, all others as YCbCr.
+func Encode(w io.Writer, img image.Image, o *Options) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > 0xffff || b.Dy() > 0xffff {
+		return errInvalidSize
+	}
+
+	sampling := o.Sampling
+	if sampling == (Sampling{}) {
+		sampling = Sampling420
+	}
+	if sampling.H < 1 || sampling.H > 2 || sampling.V < 1 || sampling.V > 2 {
+		return errInvalidSampling
+	}
+
+	quant := [2][64]uint16{scaleQuant(baseQuant[0], o.Quality), scaleQuant(baseQuant[1], o.Quality)}
+	if o.Quant != nil {
+		quant = *o.Quant
+	}
+
+	_, gray := img.(*image.Gray)
+	if gray {
+		sampling = Sampling444
+	}
+
+	e := &encoder{
+		img:      img,
+		gray:     gray,
+		sampling: sampling,
+		quant:    quant,
+	}
+	for i := range e.huffman {
+		e.huffman[i] = buildHuffman(standardHuffman[i])
+	}
+
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	e.w = bw
+
+	e.write([]byte{0xff, 0xd8})
+	e.writeDQT()
+	e.writeSOF()
+	e.writeDHT()
+	e.writeSOS()
+	e.writeScan()
+	e.write([]byte{0xff, 0xd9})
+
+	if e.err != nil {
+		return e.err
+	}
+
+	return bw.Flush()
+}
+
+// encoder holds the state of a single call to Encode.
+type encoder struct {
+	w        *bufio.Writer
+	err      error
+	img      image.Image
+	gray     bool
+	sampling Sampling
+	quant    [2][64]uint16
+	huffman  [4][256]huffmanCode
+
+	// bits and nBits hold entropy-coded bits not yet written.
+	bits  uint32
+	nBits uint
+}
+
+// write writes p unless an earlier write has failed.
+func (e *encoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+// writeMarker writes a marker segment with the given payload.
+func (e *encoder) writeMarker(marker byte, payload []byte) {
+	e.write(segmentHeader(marker, len(payload)))
+	e.write(payload)
+}
+
+func (e *encoder) writeDQT() {
+	tables := 2
+	if e.gray {
+		tables = 1
+	}
+
+	var payload []byte
+	for t := 0; t < tables; t++ {
+		payload = append(payload, byte(t))
+		for i := 0; i < 64; i++ {
+			payload = append(payload, byte(min(max(e.quant[t][zigzag[i]], 1), 255)))
+		}
+	}
+
+	e.writeMarker(0xdb, payload)
+}
+
+func (e *encoder) writeSOF() {
+	b := e.img.Bounds()
+	payload := []byte{8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx())}
+
+	if e.gray {
+		payload = append(payload, 1, 1, 0x11, 0)
+	} else {
+		hv := byte(e.sampling.H<<4 | e.sampling.V)
+		payload = append(payload, 3, 1, hv, 0, 2, 0x11, 1, 3, 0x11, 1)
+	}
+
+	e.writeMarker(0xc0, payload)
+}
+
+func (e *encoder) writeDHT() {
+	tables := 4
+	if e.gray {
+		tables = 2
+	}
+
+	var payload []byte
+	for i := 0; i < tables; i++ {
+		class, dest := byte(i%2), byte(i/2)
+		payload = append(payload, class<<4|dest)
+		payload = append(payload, standardHuffman[i].counts[:]...)
+		payload = append(payload, standardHuffman[i].values...)
+	}
+
+	e.writeMarker(0xc4, payload)
+}
+
+func (e *encoder) writeSOS() {
+	if e.gray {
+		e.writeMarker(0xda, []byte{1, 1, 0x00, 0, 63, 0})
+		return
+	}
+
+	e.writeMarker(0xda, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+}
+
+// writeScan converts the image to YCbCr planes and writes the entropy-coded
+// data of its single interleaved scan.
+func (e *encoder) writeScan() {
+	b := e.img.Bounds()
+	mcuW, mcuH := 8*e.sampling.H, 8*e.sampling.V
+	cols, rows := (b.Dx()+mcuW-1)/mcuW, (b.Dy()+mcuH-1)/mcuH
+
+	planes := toPlanes(e.img, cols*mcuW, rows*mcuH, e.gray)
+	if !e.gray {
+		planes[1] = downsample(planes[1], e.sampling)
+		planes[2] = downsample(planes[2], e.sampling)
+	}
+
+	var pred [3]int32
+	var block [64]int32
+	for my := 0; my < rows; my++ {
+		for mx := 0; mx < cols; mx++ {
+			for v := 0; v < e.sampling.V; v++ {
+				for h := 0; h < e.sampling.H; h++ {
+					planes[0].block(&block, (mx*e.sampling.H+h)*8, (my*e.sampling.V+v)*8)
+					e.writeBlock(&block, &e.quant[0], &pred[0], huffLumaDC, huffLumaAC)
+				}
+			}
+			if e.gray {
+				continue
+			}
+
+			for c := 1; c < 3; c++ {
+				planes[c].block(&block, mx*8, my*8)
+				e.writeBlock(&block, &e.quant[1], &pred[c], huffChromaDC, huffChromaAC)
+			}
+		}
+	}
+
+	e.flushBits()
+}
+
+// writeBlock transforms, quantizes and entropy codes a block of samples.
+func (e *encoder) writeBlock(block *[64]int32, quant *[64]uint16, pred *int32, dc, ac int) {
+	var coeffs [64]int32
+	fdct(block, &coeffs)
+	for i := range coeffs {
+		q := float64(quant[i])
+		coeffs[i] = int32(math.Round(float64(coeffs[i]) / q))
+	}
+
+	e.writeCoefficients(&coeffs, pred, dc, ac)
+}
+
+// writeCoefficients entropy codes a block of quantized coefficients, in
+// natural order, updating the DC predictor pred.
+func (e *encoder) writeCoefficients(coeffs *[64]int32, pred *int32, dc, ac int) {
+	diff := coeffs[0] - *pred
+	*pred = coeffs[0]
+	e.emitValue(diff, &e.huffman[dc], 0)
+
+	run := 0
+	for i := 1; i < 64; i++ {
+		v := coeffs[zigzag[i]]
+		if v == 0 {
+			run++
+			continue
+		}
+
+		for run > 15 {
+			e.emitHuffman(&e.huffman[ac], 0xf0)
+			run -= 16
+		}
+		e.emitValue(v, &e.huffman[ac], run)
+		run = 0
+	}
+	if run > 0 {
+		e.emitHuffman(&e.huffman[ac], 0x00)
+	}
+}
+
+// emitValue writes the Huffman code for the given zero run and the size of v,
+// followed by the bits of v.
+func (e *encoder) emitValue(v int32, table *[256]huffmanCode, run int) {
+	a, bits := v, v
+	if a < 0 {
+		a = -a
+		bits--
+	}
+
+	size := uint(0)
+	for a > 0 {
+		size++
+		a >>= 1
+	}
+
+	e.emitHuffman(table, byte(run<<4)|byte(size))
+	if size > 0 {
+		e.emit(uint32(bits)&(1<<size-1), size)
+	}
+}
+
+func (e *encoder) emitHuffman(table *[256]huffmanCode, symbol byte) {
+	c := table[symbol]
+	e.emit(c.bits, uint(c.length))
+}
+
+// emit writes the low n bits of bits to the entropy-coded segment, stuffing a
+// zero byte after every 0xff byte.
+func (e *encoder) emit(bits uint32, n uint) {
+	e.bits = e.bits<<n | bits
+	e.nBits += n
+
+	for e.nBits >= 8 {
+		b := byte(e.bits >> (e.nBits - 8))
+		e.write([]byte{b})
+		if b == 0xff {
+			e.write([]byte{0})
+		}
+		e.nBits -= 8
+	}
+	e.bits &= 1<<e.nBits - 1
+}
+
+// flushBits pads any entropy-coded bits not yet written with 1 bits to
+// complete the final byte.
+func (e *encoder) flushBits() {
+	e.emit(0x7f, 7)
+	e.bits, e.nBits = 0, 0
+}
+
+// restart ends the current restart interval, writing the n'th restart marker
+// of the scan.
+func (e *encoder) restart(n int) {
+	e.flushBits()
+	e.write([]byte{0xff, 0xd0 + byte(n%8)})
+}
+
+// plane is a single component of an image, one byte per sample.
+type plane struct {
+	w, h int
+	pix  []uint8
+}
+
+// block extracts the 8x8 block of level-shifted samples at (x, y).
+func (p *plane) block(dst *[64]int32, x, y int) {
+	for j := 0; j < 8; j++ {
+		row := p.pix[(y+j)*p.w+x:]
+		for i := 0; i < 8; i++ {
+			dst[8*j+i] = int32(row[i]) - 128
+		}
+	}
+}
+
+// toPlanes converts img to full resolution Y, Cb and Cr planes of the given
+// padded size, replicating edge pixels into the padding.  Only the Y plane is
+// produced for grayscale images.
+func toPlanes(img image.Image, w, h int, gray bool) [3]*plane {
+	var planes [3]*plane
+	n := 3
+	if gray {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		planes[i] = &plane{w: w, h: h, pix: make([]uint8, w*h)}
+	}
+
+	b := img.Bounds()
+	for y := 0; y < h; y++ {
+		sy := b.Min.Y + min(y, b.Dy()-1)
+		for x := 0; x < w; x++ {
+			sx := b.Min.X + min(x, b.Dx()-1)
+			i := y*w + x
+
+			switch src := img.(type) {
+			case *image.Gray:
+				planes[0].pix[i] = src.Pix[src.PixOffset(sx, sy)]
+			case *image.YCbCr:
+				planes[0].pix[i] = src.Y[src.YOffset(sx, sy)]
+				planes[1].pix[i] = src.Cb[src.COffset(sx, sy)]
+				planes[2].pix[i] = src.Cr[src.COffset(sx, sy)]
+			default:
+				r, g, bl, _ := img.At(sx, sy).RGBA()
+				if gray {
+					planes[0].pix[i] = color.GrayModel.Convert(color.RGBA64{uint16(r), uint16(g), uint16(bl), 0xffff}).(color.Gray).Y
+					continue
+				}
+				planes[0].pix[i], planes[1].pix[i], planes[2].pix[i] = color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+			}
+		}
+	}
+
+	return planes
+}
+
+// downsample averages p over boxes of the given sampling factors.
+func downsample(p *plane, s Sampling) *plane {
+	if s == Sampling444 {
+		return p
+	}
+
+	d := &plane{w: p.w / s.H, h: p.h / s.V}
+	d.pix = make([]uint8, d.w*d.h)
+	n := s.H * s.V
+	for y := 0; y < d.h; y++ {
+		for x := 0; x < d.w; x++ {
+			sum := 0
+			for j := 0; j < s.V; j++ {
+				for i := 0; i < s.H; i++ {
+					sum += int(p.pix[(y*s.V+j)*p.w+x*s.H+i])
+				}
+			}
+			d.pix[y*d.w+x] = uint8((sum + n/2) / n)
+		}
+	}
+
+	return d
+}
+
+// dctCos holds cos((2x+1)uπ/16), scaled by the DCT normalization factor for
+// u, indexed by [u][x].
+var dctCos [8][8]float64
+
+func init() {
+	for u := 0; u < 8; u++ {
+		scale := 0.5
+		if u == 0 {
+			scale = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			dctCos[u][x] = scale * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+}
+
+// fdct computes the two-dimensional forward DCT of a block of samples in
+// natural order, rounding the coefficients to integers.
+func fdct(src *[64]int32, dst *[64]int32) {
+	var tmp [64]float64
+
+	// Rows.
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += float64(src[8*y+x]) * dctCos[u][x]
+			}
+			tmp[8*y+u] = sum
+		}
+	}
+
+	// Columns.
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += tmp[8*y+u] * dctCos[v][y]
+			}
+			dst[8*v+u] = int32(math.Round(sum))
+		}
+	}
+}
+
+// ParseQuant returns the luminance and chrominance quantization tables, in
+// natural order as Options.Quant expects, of the frame whose SOF segment has
+// the payload sof, given the payloads of the DQT segments preceding it.  The
+// tables are transposed if transpose is set, for images turned by a quarter
+// turn before being encoded again.  Grayscale frames get their single table
+// for both.  It reports false if the tables are missing or malformed.
+func ParseQuant(dqt [][]byte, sof []byte, transpose bool) (*[2][64]uint16, bool) {
+	var tables [4]*[64]uint16
+	for _, p := range dqt {
+		for len(p) > 0 {
+			precision, tq := p[0]>>4, p[0]&15
+			size := 1 + 64*(1+int(precision))
+			if precision > 1 || tq > 3 || len(p) < size {
+				return nil, false
+			}
+
+			var q [64]uint16
+			for i := 0; i < 64; i++ {
+				if precision == 0 {
+					q[zigzag[i]] = uint16(p[1+i])
+				} else {
+					q[zigzag[i]] = binary.BigEndian.Uint16(p[1+2*i:])
+				}
+			}
+			tables[tq] = &q
+			p = p[size:]
+		}
+	}
+
+	if len(sof) < 9 || len(sof) != 6+3*int(sof[5]) {
+		return nil, false
+	}
+	luma, chroma := sof[8], sof[8]
+	if sof[5] > 1 {
+		chroma = sof[11]
+	}
+	if luma > 3 || chroma > 3 || tables[luma] == nil || tables[chroma] == nil {
+		return nil, false
+	}
+
+	quant := &[2][64]uint16{*tables[luma], *tables[chroma]}
+	if transpose {
+		quant[0], quant[1] = *transposeBlock(&quant[0]), *transposeBlock(&quant[1])
+	}
+
+	return quant, true
+}
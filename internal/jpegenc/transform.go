@@ -0,0 +1,634 @@
+package jpegenc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrNotTransformable is returned by Transform for images that it cannot
+// transform without loss, such as progressive images or images whose
+// dimensions are not a multiple of the MCU size along a flipped axis.
+var ErrNotTransformable = errors.New("jpegenc: image cannot be transformed losslessly")
+
+// Transformation is a lossless rearrangement of the blocks of a JPEG image.
+// Transpose, mirroring the image about its main diagonal, is applied first,
+// followed by the flips.  Every orientation can be corrected by some
+// combination of the three.
+type Transformation struct {
+	Transpose bool
+	FlipH     bool
+	FlipV     bool
+
+	// Trim drops the partial MCUs at the edges of the image along the
+	// flipped axes, which cannot be moved without loss, rather than failing
+	// with ErrNotTransformable, as jpegtran -trim does.
+	Trim bool
+}
+
+// identity reports whether t leaves the blocks of an image where they are.
+func (t Transformation) identity() bool {
+	return !t.Transpose && !t.FlipH && !t.FlipV
+}
+
+// Segment is a marker segment added to the output of Transform.
+type Segment struct {
+	Marker  byte
+	Payload []byte
+}
+
+// KeepFunc decides whether an APPn or COM segment of the source, given by its
+// marker code and payload, is copied to the output of Transform.  It may
+// return a modified payload in place of the original.
+type KeepFunc func(marker byte, payload []byte) ([]byte, bool)
+
+// Transform applies t to the baseline JPEG image in data in the DCT domain,
+// writing the result to w, so that no generation loss occurs.  APPn and COM
+// segments are passed through keep, in their original order, and the restart
+// interval of the source is kept.  The extra segments are written after the
+// kept APPn and COM segments.  A Transformation that neither transposes nor
+// flips copies all other segments and the entropy-coded data byte for byte,
+// and so also accepts progressive images.  Nothing is written to w if an
+// error other than a write error is returned.
+func Transform(w io.Writer, data []byte, t Transformation, keep KeepFunc, extra []Segment) error {
+	if t.identity() {
+		return splice(w, data, keep, extra)
+	}
+
+	f, err := parseFrame(data)
+	if err != nil {
+		return err
+	}
+
+	out, err := f.transform(t)
+	if err != nil {
+		return err
+	}
+
+	e := &encoder{gray: len(out.comps) == 1}
+	for i := range e.huffman {
+		e.huffman[i] = buildHuffman(standardHuffman[i])
+	}
+
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	e.w = bw
+
+	e.write([]byte{0xff, 0xd8})
+	for _, s := range f.segments {
+		payload, ok := s.payload, true
+		if keep != nil {
+			payload, ok = keep(s.marker, s.payload)
+		}
+		if ok {
+			e.writeMarker(s.marker, payload)
+		}
+	}
+	for _, s := range extra {
+		e.writeMarker(s.Marker, s.Payload)
+	}
+	out.write(e)
+	e.write([]byte{0xff, 0xd9})
+
+	if e.err != nil {
+		return e.err
+	}
+
+	return bw.Flush()
+}
+
+// Transformable reports whether Transform can apply t to the JPEG image in
+// data without loss, judging from its headers alone, without decoding its
+// entropy-coded data.  Transform may still fail on images whose
+// entropy-coded data is corrupt.
+func Transformable(data []byte, t Transformation) bool {
+	if t.identity() {
+		return len(data) >= 4 && data[0] == 0xff && data[1] == 0xd8
+	}
+
+	_, _, err := OutputSize(data, t)
+	return err == nil
+}
+
+// OutputSize returns the dimensions of the image Transform produces by
+// applying t to the baseline JPEG image in data, judging from its headers
+// alone.  ErrNotTransformable is returned if t cannot be applied without
+// loss.
+func OutputSize(data []byte, t Transformation) (int, int, error) {
+	f, _, err := parseHeaders(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	w, h, ok := f.outputSize(t)
+	if !ok {
+		return 0, 0, ErrNotTransformable
+	}
+
+	return w, h, nil
+}
+
+// splice copies the JPEG image in data to w, passing its APPn and COM
+// segments through keep and copying everything else unchanged.  The extra
+// segments are inserted before the first segment that is neither APPn nor
+// COM.
+func splice(w io.Writer, data []byte, keep KeepFunc, extra []Segment) error {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return ErrNotTransformable
+	}
+
+	// The output is assembled before anything is written, so that malformed
+	// images leave w untouched.
+	out := [][]byte{data[:2]}
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return ErrNotTransformable
+		}
+		start := pos
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos+3 > len(data) {
+			return ErrNotTransformable
+		}
+
+		marker := data[pos]
+		length := int(binary.BigEndian.Uint16(data[pos+1:]))
+		if length < 2 || pos+1+length > len(data) {
+			return ErrNotTransformable
+		}
+		payload := data[pos+3 : pos+1+length]
+		end := pos + 1 + length
+
+		app := (marker >= 0xe0 && marker <= 0xef) || marker == 0xfe
+		if !app && extra != nil {
+			for _, s := range extra {
+				out = append(out, segmentHeader(s.Marker, len(s.Payload)), s.Payload)
+			}
+			extra = nil
+		}
+
+		if keep != nil && app {
+			p, ok := keep(marker, payload)
+			if ok {
+				out = append(out, segmentHeader(marker, len(p)), p)
+			}
+		} else {
+			out = append(out, data[start:end])
+		}
+		pos = end
+
+		if marker == 0xda {
+			out = append(out, data[pos:])
+			break
+		}
+	}
+
+	for _, p := range out {
+		_, err := w.Write(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// segmentHeader returns the marker and length bytes of a segment with a
+// payload of n bytes.
+func segmentHeader(marker byte, n int) []byte {
+	return []byte{0xff, marker, byte((n + 2) >> 8), byte(n + 2)}
+}
+
+// rawSegment is an APPn or COM segment carried through Transform.
+type rawSegment struct {
+	marker  byte
+	payload []byte
+}
+
+// component is a single color component of a frame, along with its
+// quantized coefficients.
+type component struct {
+	id   byte
+	h, v int
+	tq   byte
+	td   byte
+	ta   byte
+
+	// bw and bh are the width and height of the component in blocks, and
+	// blocks holds its coefficients in natural order, row by row.
+	bw, bh int
+	blocks [][64]int32
+}
+
+// frame is a decoded baseline JPEG image.
+type frame struct {
+	width, height int
+	comps         []component
+	quant         [4]*[64]uint16
+	restart       int
+	segments      []rawSegment
+	hmax, vmax    int
+
+	// huffman holds the DC and AC Huffman tables of the source, indexed by
+	// class and destination.
+	huffman [2][4]*huffmanDecoder
+}
+
+// mcus returns the number of MCUs across and down the frame.
+func (f *frame) mcus() (int, int) {
+	if len(f.comps) == 1 {
+		return (f.width + 7) / 8, (f.height + 7) / 8
+	}
+
+	return (f.width + 8*f.hmax - 1) / (8 * f.hmax), (f.height + 8*f.vmax - 1) / (8 * f.vmax)
+}
+
+// outputSize returns the dimensions of the image t turns f into, and whether
+// t can be applied to f.  Unless t.Trim is set, the dimensions of f,
+// transposed if t says so, must be a multiple of the MCU size along every
+// axis t flips.
+func (f *frame) outputSize(t Transformation) (int, int, bool) {
+	w, h := f.width, f.height
+	mw, mh := 8, 8
+	if len(f.comps) > 1 {
+		for _, c := range f.comps {
+			mw, mh = max(mw, 8*c.h), max(mh, 8*c.v)
+		}
+	}
+	if t.Transpose {
+		w, h, mw, mh = h, w, mh, mw
+	}
+
+	if t.FlipH && w%mw != 0 {
+		if !t.Trim {
+			return 0, 0, false
+		}
+		w -= w % mw
+	}
+	if t.FlipV && h%mh != 0 {
+		if !t.Trim {
+			return 0, 0, false
+		}
+		h -= h % mh
+	}
+
+	return w, h, w > 0 && h > 0
+}
+
+// allocate sizes the block grids of the components of f.
+func (f *frame) allocate() {
+	f.hmax, f.vmax = 1, 1
+	for _, c := range f.comps {
+		f.hmax, f.vmax = max(f.hmax, c.h), max(f.vmax, c.v)
+	}
+
+	mx, my := f.mcus()
+	for i := range f.comps {
+		c := &f.comps[i]
+		c.bw, c.bh = mx, my
+		if len(f.comps) > 1 {
+			c.bw, c.bh = mx*c.h, my*c.v
+		}
+		c.blocks = make([][64]int32, c.bw*c.bh)
+	}
+}
+
+// parseFrame decodes the quantized coefficients of the baseline JPEG image in
+// data.
+func parseFrame(data []byte) (*frame, error) {
+	f, pos, err := parseHeaders(data)
+	if err != nil {
+		return nil, err
+	}
+
+	f.allocate()
+	if !f.decodeScan(data[pos:]) {
+		return nil, ErrNotTransformable
+	}
+
+	return f, nil
+}
+
+// parseHeaders parses the marker segments of the baseline JPEG image in data
+// up to its scan, returning the offset of the entropy-coded data.
+func parseHeaders(data []byte) (*frame, int, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0, ErrNotTransformable
+	}
+
+	f := &frame{}
+	sof := false
+
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, 0, ErrNotTransformable
+		}
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos+3 > len(data) {
+			return nil, 0, ErrNotTransformable
+		}
+
+		marker := data[pos]
+		length := int(binary.BigEndian.Uint16(data[pos+1:]))
+		if length < 2 || pos+1+length > len(data) {
+			return nil, 0, ErrNotTransformable
+		}
+		payload := data[pos+3 : pos+1+length]
+		pos += 1 + length
+
+		switch {
+		case marker >= 0xe0 && marker <= 0xef, marker == 0xfe:
+			f.segments = append(f.segments, rawSegment{marker, payload})
+
+		case marker == 0xdb:
+			if !f.parseDQT(payload) {
+				return nil, 0, ErrNotTransformable
+			}
+
+		case marker == 0xc4:
+			if !f.parseDHT(payload) {
+				return nil, 0, ErrNotTransformable
+			}
+
+		case marker == 0xdd:
+			if len(payload) != 2 {
+				return nil, 0, ErrNotTransformable
+			}
+			f.restart = int(binary.BigEndian.Uint16(payload))
+
+		case marker == 0xc0 || marker == 0xc1:
+			if sof || !f.parseSOF(payload) {
+				return nil, 0, ErrNotTransformable
+			}
+			sof = true
+
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			// Progressive, lossless, hierarchical and arithmetic-coded
+			// images are not supported.
+			return nil, 0, ErrNotTransformable
+
+		case marker == 0xda:
+			if !sof || !f.parseSOS(payload) {
+				return nil, 0, ErrNotTransformable
+			}
+			return f, pos, nil
+		}
+	}
+}
+
+func (f *frame) parseDQT(p []byte) bool {
+	for len(p) > 0 {
+		precision, tq := p[0]>>4, p[0]&15
+		if precision != 0 || tq > 3 || len(p) < 65 {
+			return false
+		}
+
+		var q [64]uint16
+		for i := 0; i < 64; i++ {
+			q[zigzag[i]] = uint16(p[1+i])
+		}
+		f.quant[tq] = &q
+		p = p[65:]
+	}
+
+	return true
+}
+
+func (f *frame) parseDHT(p []byte) bool {
+	for len(p) > 0 {
+		if len(p) < 17 {
+			return false
+		}
+		class, th := p[0]>>4, p[0]&15
+		if class > 1 || th > 3 {
+			return false
+		}
+
+		var spec huffmanSpec
+		copy(spec.counts[:], p[1:17])
+		n := 0
+		for _, c := range spec.counts {
+			n += int(c)
+		}
+		if n > 256 || len(p) < 17+n {
+			return false
+		}
+		spec.values = p[17 : 17+n]
+
+		f.huffman[class][th] = newHuffmanDecoder(spec)
+		p = p[17+n:]
+	}
+
+	return true
+}
+
+func (f *frame) parseSOF(p []byte) bool {
+	if len(p) < 6 || p[0] != 8 {
+		return false
+	}
+	f.height = int(binary.BigEndian.Uint16(p[1:]))
+	f.width = int(binary.BigEndian.Uint16(p[3:]))
+	n := int(p[5])
+	if f.width == 0 || f.height == 0 || n < 1 || n > 4 || len(p) != 6+3*n {
+		return false
+	}
+
+	for i := 0; i < n; i++ {
+		c := component{id: p[6+3*i], h: int(p[7+3*i] >> 4), v: int(p[7+3*i] & 15), tq: p[8+3*i]}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return false
+		}
+		if n == 1 {
+			c.h, c.v = 1, 1
+		}
+		f.comps = append(f.comps, c)
+	}
+
+	return true
+}
+
+// parseSOS checks that the scan is a single interleaved scan of every
+// component, and assigns the components their Huffman tables.
+func (f *frame) parseSOS(p []byte) bool {
+	if len(p) < 1 || int(p[0]) != len(f.comps) || len(p) != 4+2*len(f.comps) {
+		return false
+	}
+
+	for i := range f.comps {
+		id, tables := p[1+2*i], p[2+2*i]
+		if f.comps[i].id != id {
+			return false
+		}
+		f.comps[i].td, f.comps[i].ta = tables>>4, tables&15
+		if f.comps[i].td > 3 || f.comps[i].ta > 3 {
+			return false
+		}
+		if f.huffman[0][f.comps[i].td] == nil || f.huffman[1][f.comps[i].ta] == nil || f.quant[f.comps[i].tq] == nil {
+			return false
+		}
+	}
+
+	s := p[1+2*len(f.comps):]
+	if s[0] != 0 || s[1] != 63 || s[2] != 0 {
+		return false
+	}
+
+	return true
+}
+
+// transform returns a new frame holding the coefficients of f rearranged by
+// t.
+func (f *frame) transform(t Transformation) (*frame, error) {
+	width, height, ok := f.outputSize(t)
+	if !ok {
+		return nil, ErrNotTransformable
+	}
+	out := &frame{width: width, height: height, restart: f.restart}
+
+	for i, q := range f.quant {
+		if q != nil && t.Transpose {
+			q = transposeBlock(q)
+		}
+		out.quant[i] = q
+	}
+
+	for _, c := range f.comps {
+		oc := component{id: c.id, h: c.h, v: c.v, tq: c.tq}
+		if t.Transpose {
+			oc.h, oc.v = c.v, c.h
+		}
+		out.comps = append(out.comps, oc)
+	}
+	out.allocate()
+
+	for i := range out.comps {
+		src, dst := &f.comps[i], &out.comps[i]
+		for y := 0; y < dst.bh; y++ {
+			for x := 0; x < dst.bw; x++ {
+				sx, sy := x, y
+				if t.FlipH {
+					sx = dst.bw - 1 - x
+				}
+				if t.FlipV {
+					sy = dst.bh - 1 - y
+				}
+				if t.Transpose {
+					sx, sy = sy, sx
+				}
+
+				block := &dst.blocks[y*dst.bw+x]
+				*block = src.blocks[sy*src.bw+sx]
+				transformBlock(block, t)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// transformBlock applies t to the coefficients of a single block.  Flipping
+// the samples of a block negates the coefficients of odd frequency along the
+// flipped axis.
+func transformBlock(block *[64]int32, t Transformation) {
+	if t.Transpose {
+		for v := 0; v < 8; v++ {
+			for u := v + 1; u < 8; u++ {
+				block[8*v+u], block[8*u+v] = block[8*u+v], block[8*v+u]
+			}
+		}
+	}
+
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			if (t.FlipH && u%2 == 1) != (t.FlipV && v%2 == 1) {
+				block[8*v+u] = -block[8*v+u]
+			}
+		}
+	}
+}
+
+func transposeBlock(q *[64]uint16) *[64]uint16 {
+	var t [64]uint16
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			t[8*u+v] = q[8*v+u]
+		}
+	}
+
+	return &t
+}
+
+// write writes the tables and the single interleaved scan of f.
+func (f *frame) write(e *encoder) {
+	var dqt []byte
+	for i, q := range f.quant {
+		if q == nil {
+			continue
+		}
+		dqt = append(dqt, byte(i))
+		for k := 0; k < 64; k++ {
+			dqt = append(dqt, byte(q[zigzag[k]]))
+		}
+	}
+	e.writeMarker(0xdb, dqt)
+
+	sof := []byte{8, byte(f.height >> 8), byte(f.height), byte(f.width >> 8), byte(f.width), byte(len(f.comps))}
+	for _, c := range f.comps {
+		sof = append(sof, c.id, byte(c.h<<4|c.v), c.tq)
+	}
+	e.writeMarker(0xc0, sof)
+
+	e.writeDHT()
+	if f.restart > 0 {
+		e.writeMarker(0xdd, []byte{byte(f.restart >> 8), byte(f.restart)})
+	}
+
+	sos := []byte{byte(len(f.comps))}
+	for i, c := range f.comps {
+		tables := byte(0x00)
+		if i > 0 {
+			tables = 0x11
+		}
+		sos = append(sos, c.id, tables)
+	}
+	e.writeMarker(0xda, append(sos, 0, 63, 0))
+
+	preds := make([]int32, len(f.comps))
+	mx, my := f.mcus()
+	for m := 0; m < mx*my; m++ {
+		if f.restart > 0 && m > 0 && m%f.restart == 0 {
+			e.restart(m/f.restart - 1)
+			clear(preds)
+		}
+
+		x, y := m%mx, m/mx
+		for i := range f.comps {
+			c := &f.comps[i]
+			dc, ac := huffLumaDC, huffLumaAC
+			if i > 0 {
+				dc, ac = huffChromaDC, huffChromaAC
+			}
+
+			if len(f.comps) == 1 {
+				e.writeCoefficients(&c.blocks[y*c.bw+x], &preds[i], dc, ac)
+				continue
+			}
+
+			for v := 0; v < c.v; v++ {
+				for h := 0; h < c.h; h++ {
+					e.writeCoefficients(&c.blocks[(y*c.v+v)*c.bw+x*c.h+h], &preds[i], dc, ac)
+				}
+			}
+		}
+	}
+
+	e.flushBits()
+}
@@ -0,0 +1,75 @@
+package exiflign
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// RawImage is an image as a tightly packed buffer of 8-bit samples, the
+// bands of each pixel interleaved, as libvips loads from memory and so as
+// govips accepts it.
+type RawImage struct {
+	Pix    []byte
+	Width  int
+	Height int
+
+	// Bands is 1 for grayscale images and 3 for RGB images.
+	Bands int
+}
+
+// Image returns the orientation-corrected image kept under
+// Options.KeepImage, or nil if it was not kept.  It is the image that was
+// encoded, typically an *image.YCbCr or *image.Gray, and must not be
+// modified.
+func (r *Result) Image() image.Image {
+	return r.image
+}
+
+// NRGBA returns the image kept under Options.KeepImage as an *image.NRGBA
+// whose bounds start at (0, 0), as package imaging works with, or nil if it
+// was not kept.  Images of other types are converted into a new image, which
+// is not retained.
+func (r *Result) NRGBA() *image.NRGBA {
+	if r.image == nil {
+		return nil
+	}
+	if n, ok := r.image.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+
+	return imaging.Clone(r.image)
+}
+
+// Raw returns the image kept under Options.KeepImage as a RawImage, or nil if
+// it was not kept.  Grayscale images keep a single band, others are
+// converted to RGB, dropping any alpha channel as JPEG images have none.
+func (r *Result) Raw() *RawImage {
+	if r.image == nil {
+		return nil
+	}
+
+	b := r.image.Bounds()
+	raw := &RawImage{Width: b.Dx(), Height: b.Dy()}
+	if g, ok := r.image.(*image.Gray); ok {
+		raw.Bands = 1
+		raw.Pix = make([]byte, 0, raw.Width*raw.Height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := g.PixOffset(b.Min.X, y)
+			raw.Pix = append(raw.Pix, g.Pix[i:i+raw.Width]...)
+		}
+		return raw
+	}
+
+	n := r.NRGBA()
+	raw.Bands = 3
+	raw.Pix = make([]byte, 0, 3*raw.Width*raw.Height)
+	for y := 0; y < raw.Height; y++ {
+		row := n.Pix[y*n.Stride : y*n.Stride+4*raw.Width]
+		for x := 0; x < len(row); x += 4 {
+			raw.Pix = append(raw.Pix, row[x], row[x+1], row[x+2])
+		}
+	}
+
+	return raw
+}
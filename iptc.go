@@ -0,0 +1,223 @@
+package exiflign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"unicode/utf8"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+var photoshopHeader = []byte("Photoshop 3.0\x00")
+
+// irbIPTC is the identifier of the Photoshop image resource holding IPTC-IIM
+// data.
+const irbIPTC = 0x0404
+
+// IPTC-IIM application record dataset numbers used by this package.
+const (
+	iptcObjectName  = 5
+	iptcKeywords    = 25
+	iptcDateCreated = 55
+	iptcByline      = 80
+	iptcCity        = 90
+	iptcCountry     = 101
+	iptcHeadline    = 105
+	iptcCredit      = 110
+	iptcSource      = 115
+	iptcCopyright   = 116
+	iptcCaption     = 120
+)
+
+var NoIPTCError error = errors.New("The given file does not contain any IPTC data.")
+
+// IPTC holds the commonly used fields of an image's IPTC-IIM data, as used by
+// newsrooms and digital asset management systems.  Fields that are not
+// present in the image are left at their zero value.
+type IPTC struct {
+	Title     string
+	Headline  string
+	Caption   string
+	Keywords  []string
+	Byline    string
+	Credit    string
+	Source    string
+	Copyright string
+	City      string
+	Country   string
+
+	// DateCreated is in the IIM "CCYYMMDD" format.
+	DateCreated string
+}
+
+// ReadIPTC parses the IPTC-IIM data held in the Photoshop APP13 segments of
+// the JPEG image in r.  NoIPTCError is returned if there is none.  When
+// finished, the internal position in r will be at io.SeekStart.
+func ReadIPTC(r io.ReadSeeker) (*IPTC, error) {
+	segments, err := readPhotoshopSegments(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resource blocks too large for one segment continue in the next.
+	var irb []byte
+	for _, s := range segments {
+		irb = append(irb, s.Payload[len(photoshopHeader):]...)
+	}
+
+	data := findResource(irb, irbIPTC)
+	if data == nil {
+		return nil, NoIPTCError
+	}
+
+	return parseIPTC(data), nil
+}
+
+// readPhotoshopSegments returns the Photoshop APP13 segments of the JPEG image
+// in r, in order.  When finished, the internal position in r will be at
+// io.SeekStart.
+func readPhotoshopSegments(r io.ReadSeeker) ([]jpegenc.Segment, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []jpegenc.Segment
+	var serr error
+	err = walkSegments(ra, size, func(s segment) bool {
+		if s.marker != markerAPP13 || s.length < len(photoshopHeader) {
+			return true
+		}
+
+		var payload []byte
+		payload, serr = readSegment(ra, s)
+		if serr != nil {
+			return false
+		}
+		if bytes.HasPrefix(payload, photoshopHeader) {
+			segments = append(segments, jpegenc.Segment{Marker: markerAPP13, Payload: payload})
+		}
+		return true
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	return segments, err
+}
+
+// findResource returns the data of the image resource with the given
+// identifier in the Photoshop image resource blocks irb, or nil if there is
+// none.
+func findResource(irb []byte, id uint16) []byte {
+	for len(irb) >= 12 && bytes.HasPrefix(irb, []byte("8BIM")) {
+		rid := binary.BigEndian.Uint16(irb[4:])
+
+		// The name is a Pascal string padded to an even length.
+		nameLen := int(irb[6])
+		p := 6 + (nameLen+2)&^1
+		if p+4 > len(irb) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(irb[p:]))
+		p += 4
+		if size < 0 || p+size > len(irb) {
+			return nil
+		}
+
+		if rid == id {
+			return irb[p : p+size]
+		}
+		irb = irb[min(p+(size+1)&^1, len(irb)):]
+	}
+
+	return nil
+}
+
+// parseIPTC extracts the fields of IPTC from IPTC-IIM datasets.
+func parseIPTC(data []byte) *IPTC {
+	x := &IPTC{}
+	utf8Charset := false
+
+	for len(data) >= 5 && data[0] == 0x1c {
+		record, dataset := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:]))
+		p := 5
+
+		// Extended datasets give the number of length bytes that follow.
+		if size&0x8000 != 0 {
+			n := size & 0x7fff
+			if n > 4 || p+n > len(data) {
+				break
+			}
+			size = 0
+			for _, b := range data[p : p+n] {
+				size = size<<8 | int(b)
+			}
+			p += n
+		}
+		if p+size > len(data) {
+			break
+		}
+		value := data[p : p+size]
+		data = data[p+size:]
+
+		// Dataset 1:90 declares the character set, ESC % G being UTF-8.
+		if record == 1 && dataset == 90 {
+			utf8Charset = bytes.Equal(value, []byte("\x1b%G"))
+			continue
+		}
+		if record != 2 {
+			continue
+		}
+
+		s := iptcString(value, utf8Charset)
+		switch dataset {
+		case iptcObjectName:
+			x.Title = s
+		case iptcKeywords:
+			x.Keywords = append(x.Keywords, s)
+		case iptcDateCreated:
+			x.DateCreated = s
+		case iptcByline:
+			x.Byline = s
+		case iptcCity:
+			x.City = s
+		case iptcCountry:
+			x.Country = s
+		case iptcHeadline:
+			x.Headline = s
+		case iptcCredit:
+			x.Credit = s
+		case iptcSource:
+			x.Source = s
+		case iptcCopyright:
+			x.Copyright = s
+		case iptcCaption:
+			x.Caption = s
+		}
+	}
+
+	return x
+}
+
+// iptcString decodes an IIM text value.  Without a declared character set,
+// values that are not valid UTF-8 are taken to be Latin-1, which most older
+// software wrote.
+func iptcString(value []byte, utf8Charset bool) string {
+	if utf8Charset || utf8.Valid(value) {
+		return string(value)
+	}
+
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,150 @@
+package exiflign
+
+import (
+	"errors"
+	"io"
+	"syscall/js"
+)
+
+// jsBlobChunkSize is the size of the slices a JSBlob reads its Blob in.
+const jsBlobChunkSize = 1 << 20
+
+var jsBlobSeekError error = errors.New("The given offset is before the start of the Blob.")
+
+// JSBlob is an io.ReadSeeker and io.ReaderAt over a browser Blob, such as a
+// File picked by the user, for NormalizeWithOptions and friends in
+// WebAssembly builds.  Rather than copying the whole Blob into Go memory
+// first, which makes large photos infeasible, it reads slices of
+// jsBlobChunkSize bytes as they are needed, keeping the last one to serve the
+// small reads of marker segment walks.  Each read waits for a JavaScript
+// promise, so a JSBlob must not be read from the goroutine of a js.Func
+// callback, which would deadlock, but from one started by it.
+type JSBlob struct {
+	blob js.Value
+	size int64
+	pos  int64
+
+	// chunk holds the bytes of the Blob starting at chunkOffset.
+	chunk       []byte
+	chunkOffset int64
+}
+
+// NewJSBlob returns a JSBlob reading blob, a JavaScript Blob or File.
+func NewJSBlob(blob js.Value) *JSBlob {
+	return &JSBlob{blob: blob, size: int64(blob.Get("size").Float())}
+}
+
+// Size returns the size of the Blob in bytes.
+func (b *JSBlob) Size() int64 {
+	return b.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *JSBlob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, jsBlobSeekError
+	}
+
+	n := 0
+	for n < len(p) {
+		if off >= b.size {
+			return n, io.EOF
+		}
+
+		// Reads as large as a chunk bypass it.
+		if len(p)-n >= jsBlobChunkSize {
+			m, err := b.slice(p[n:], off)
+			n, off = n+m, off+int64(m)
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if off < b.chunkOffset || off >= b.chunkOffset+int64(len(b.chunk)) {
+			chunk := make([]byte, min(jsBlobChunkSize, b.size-off))
+			m, err := b.slice(chunk, off)
+			if err != nil {
+				return n, err
+			}
+			b.chunk, b.chunkOffset = chunk[:m], off
+		}
+		m := copy(p[n:], b.chunk[off-b.chunkOffset:])
+		n, off = n+m, off+int64(m)
+	}
+
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (b *JSBlob) Read(p []byte) (int, error) {
+	if b.pos >= b.size {
+		return 0, io.EOF
+	}
+
+	n, err := b.ReadAt(p, b.pos)
+	b.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (b *JSBlob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += b.size
+	}
+	if offset < 0 {
+		return 0, jsBlobSeekError
+	}
+	b.pos = offset
+
+	return offset, nil
+}
+
+// slice reads the bytes of the Blob starting at off into p, up to its end.
+func (b *JSBlob) slice(p []byte, off int64) (int, error) {
+	end := min(off+int64(len(p)), b.size)
+	buffer, err := awaitPromise(b.blob.Call("slice", float64(off), float64(end)).Call("arrayBuffer"))
+	if err != nil {
+		return 0, err
+	}
+
+	n := js.CopyBytesToGo(p, js.Global().Get("Uint8Array").New(buffer))
+	if int64(n) < end-off {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	return n, nil
+}
+
+// awaitPromise waits for the JavaScript promise p to settle, and returns the
+// value it was fulfilled with or an error describing why it was rejected.
+func awaitPromise(p js.Value) (js.Value, error) {
+	done := make(chan struct{})
+	var value js.Value
+	var err error
+
+	onFulfilled := js.FuncOf(func(this js.Value, args []js.Value) any {
+		value = args[0]
+		close(done)
+		return nil
+	})
+	defer onFulfilled.Release()
+	onRejected := js.FuncOf(func(this js.Value, args []js.Value) any {
+		err = errors.New(js.Global().Get("String").Invoke(args[0]).String())
+		close(done)
+		return nil
+	})
+	defer onRejected.Release()
+
+	p.Call("then", onFulfilled, onRejected)
+	<-done
+
+	return value, err
+}
@@ -0,0 +1,104 @@
+package exiflign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var JXLError error = errors.New("The given file is a JPEG XL image, which cannot be normalized.")
+var JXLExifError error = errors.New("The given file is a JPEG XL image with EXIF orientation information, which cannot be normalized.")
+var NotJXLError error = errors.New("The given file is not a JPEG XL image.")
+
+// jxlContainer and jxlCodestream are the signatures of a JPEG XL image in the
+// ISO BMFF based container format and of a bare JPEG XL codestream.
+var jxlContainer = []byte{0, 0, 0, 0x0c, 'J', 'X', 'L', ' ', 0x0d, 0x0a, 0x87, 0x0a}
+var jxlCodestream = []byte{0xff, 0x0a}
+
+// maxJXLExif bounds the size of an Exif box that is read.
+const maxJXLExif = 1 << 20
+
+// GetJXLOrientationTag returns the orientation tag of the EXIF data in the
+// Exif box of the JPEG XL image in r.  Bare codestreams have no boxes, and
+// EXIF data compressed in a brob box cannot be read, so NoExifError is
+// returned for those as for images without an Exif box.  NotJXLError is
+// returned if r does not contain a JPEG XL image.  Note that a JPEG XL
+// decoder orients the image as its codestream says regardless of its EXIF
+// data, which should merely agree with it.  When finished, the internal
+// position in r will be at io.SeekStart.
+func GetJXLOrientationTag(r io.ReadSeeker) (uint16, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return 0, err
+	}
+
+	tag, found, err := jxlOrientation(ra, size)
+	r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, NoExifError
+	}
+	if tag < 1 || tag > 8 {
+		tag = 1
+	}
+
+	return tag, nil
+}
+
+// jxlOrientation looks for an orientation tag in the Exif box of the JPEG XL
+// image of the given size in r.  A damaged container is searched as far as
+// it can be read.
+func jxlOrientation(r io.ReaderAt, size int64) (uint16, bool, error) {
+	var header [16]byte
+	n, _ := r.ReadAt(header[:len(jxlContainer)], 0)
+	if bytes.HasPrefix(header[:n], jxlCodestream) {
+		return 0, false, nil
+	}
+	if !bytes.Equal(header[:n], jxlContainer) {
+		return 0, false, NotJXLError
+	}
+
+	offset := int64(0)
+	for offset+8 <= size {
+		_, err := r.ReadAt(header[:8], offset)
+		if err != nil {
+			return 0, false, nil
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+		headerLength := int64(8)
+		switch length {
+		case 0:
+			length = size - offset
+		case 1:
+			_, err = r.ReadAt(header[8:16], offset+8)
+			if err != nil {
+				return 0, false, nil
+			}
+			length, headerLength = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if length < headerLength || length > size-offset {
+			return 0, false, nil
+		}
+
+		if typ == "Exif" && length-headerLength <= maxJXLExif {
+			payload := make([]byte, length-headerLength)
+			_, err = r.ReadAt(payload, offset+headerLength)
+			if err != nil {
+				return 0, false, nil
+			}
+			// The TIFF header follows a four byte offset to it.
+			if len(payload) < 4 || int64(binary.BigEndian.Uint32(payload)) > int64(len(payload)-4) {
+				return 0, false, nil
+			}
+			tag, found := exifOrientation(payload[4+binary.BigEndian.Uint32(payload):])
+			return tag, found, nil
+		}
+		offset += length
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package exiflign
+
+import "io/fs"
+
+func identify(info fs.FileInfo) (fileKey, uint64, bool) {
+	return fileKey{}, 0, false
+}
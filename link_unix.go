@@ -0,0 +1,19 @@
+//go:build unix
+
+package exiflign
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// identify returns the identity of the file described by info, and the
+// number of hard links to it.
+func identify(info fs.FileInfo) (fileKey, uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, 0, false
+	}
+
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, uint64(st.Nlink), true
+}
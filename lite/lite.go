@@ -0,0 +1,160 @@
+// Package lite reads and rewrites the EXIF orientation tag of JPEG images held
+// in memory, which is the part of exiflign that needs no image decoding.  It
+// is kept apart from package exiflign so that it can be built where exiflign
+// cannot, such as under TinyGo for WebAssembly and embedded targets: it
+// depends only on errors and encoding/binary, never starts a goroutine, does
+// not use unsafe, and does not allocate, working on the bytes of the image in
+// place.  Its results depend on nothing but those bytes.
+package lite
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var NotJPEGError error = errors.New("The given file is not a JPEG image.")
+var NoExifError error = errors.New("The given file does not contain any EXIF orientation information.")
+var InvalidTagError error = errors.New("The given orientation tag is not between 1 and 8.")
+
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+	markerTEM  = 0x01
+	markerRST0 = 0xd0
+	markerRST7 = 0xd7
+
+	tagOrientation = 0x0112
+	tiffShort      = 3
+)
+
+var exifHeader = [6]byte{'E', 'x', 'i', 'f', 0, 0}
+
+// Orientation returns the orientation tag of the JPEG image in data.
+// NoExifError is returned if the image has no orientation tag, or one outside
+// the range 1 to 8.
+func Orientation(data []byte) (uint16, error) {
+	value, order, err := orientationValue(data)
+	if err != nil {
+		return 0, err
+	}
+
+	tag := order.Uint16(value)
+	if tag < 1 || tag > 8 {
+		return 0, NoExifError
+	}
+
+	return tag, nil
+}
+
+// SetOrientation overwrites the orientation tag of the JPEG image in data
+// with tag, in place, and returns the tag it replaced as stored, which may be
+// outside the range 1 to 8.  Setting a tag of 1 marks the image as upright,
+// for instance once its pixels have been corrected by other means.
+// NoExifError is returned, and data left as it is, if the image has no
+// orientation tag to overwrite.
+func SetOrientation(data []byte, tag uint16) (uint16, error) {
+	if tag < 1 || tag > 8 {
+		return 0, InvalidTagError
+	}
+
+	value, order, err := orientationValue(data)
+	if err != nil {
+		return 0, err
+	}
+
+	old := order.Uint16(value)
+	order.PutUint16(value, tag)
+	return old, nil
+}
+
+// orientationValue returns the bytes of data holding the value of the
+// orientation tag of the JPEG image in data, and their byte order.
+func orientationValue(data []byte) ([]byte, binary.ByteOrder, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, nil, NotJPEGError
+	}
+
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xff {
+			return nil, nil, NotJPEGError
+		}
+
+		// Any number of 0xff fill bytes may precede a marker code.
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			break
+		}
+		marker := data[pos]
+		pos++
+
+		if marker == markerTEM || (marker >= markerRST0 && marker <= markerRST7) {
+			continue
+		}
+		if marker == markerEOI || pos+2 > len(data) {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, nil, NotJPEGError
+		}
+		payload := data[pos+2 : pos+length]
+		pos += length
+
+		if marker == markerAPP1 && len(payload) >= len(exifHeader) && [6]byte(payload[:6]) == exifHeader {
+			if value, order, ok := tiffOrientation(payload[len(exifHeader):]); ok {
+				return value, order, nil
+			}
+		}
+		if marker == markerSOS {
+			break
+		}
+	}
+
+	return nil, nil, NoExifError
+}
+
+// tiffOrientation returns the bytes of the TIFF structure data holding the
+// value of its orientation tag, and their byte order.
+func tiffOrientation(data []byte) ([]byte, binary.ByteOrder, bool) {
+	if len(data) < 8 {
+		return nil, nil, false
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case data[0] == 'I' && data[1] == 'I':
+		order = binary.LittleEndian
+	case data[0] == 'M' && data[1] == 'M':
+		order = binary.BigEndian
+	default:
+		return nil, nil, false
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return nil, nil, false
+	}
+
+	offset := int64(order.Uint32(data[4:]))
+	if offset+2 > int64(len(data)) {
+		return nil, nil, false
+	}
+	n := int64(order.Uint16(data[offset:]))
+	start := offset + 2
+	if start+12*n > int64(len(data)) {
+		return nil, nil, false
+	}
+
+	for i := int64(0); i < n; i++ {
+		e := data[start+12*i:]
+		if order.Uint16(e) == tagOrientation && order.Uint16(e[2:]) == tiffShort && order.Uint32(e[4:]) >= 1 {
+			return e[8:10], order, true
+		}
+	}
+
+	return nil, nil, false
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package exiflign
+
+func longPath(path string) string {
+	return path
+}
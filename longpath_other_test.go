@@ -0,0 +1,13 @@
+//go:build !windows
+
+package exiflign
+
+import "testing"
+
+func TestLongPath(t *testing.T) {
+	for _, path := range []string{"photo.jpg", "/photos/photo.jpg", `\\?\C:\photo.jpg`} {
+		if got := longPath(path); got != path {
+			t.Errorf("longPath(%q) = %q, want it unchanged", path, got)
+		}
+	}
+}
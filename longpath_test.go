@@ -0,0 +1,55 @@
+package exiflign
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFileLongPath(t *testing.T) {
+	// Each element stays well within the limits of every file system, but
+	// together they exceed the 260 characters of MAX_PATH.
+	dir := t.TempDir()
+	for i := 0; i < 6; i++ {
+		dir = filepath.Join(dir, strings.Repeat(string(rune('a'+i)), 60))
+	}
+	err := os.MkdirAll(longPath(dir), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Running twice under CollisionVersion checks that existing outputs are
+	// found at long paths too.
+	tests := []struct {
+		name     string
+		src, dst string
+		second   string
+	}{
+		{"in place", "in-place.jpg", "in-place.jpg", "in-place.jpg"},
+		{"into another file", "original.jpg", "normalized.jpg", "normalized-1.jpg"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src, dst := filepath.Join(dir, test.src), filepath.Join(dir, test.dst)
+			if len(dst) <= 260 {
+				t.Fatalf("%s is only %d characters long", dst, len(dst))
+			}
+			writeTestJPEG(t, longPath(src), testIFD{
+				{tagMake, "Canon"},
+				{tagOrientation, uint16(6)},
+			})
+
+			opts := &FileOptions{Collisions: CollisionVersion}
+			for i := 0; i < 2; i++ {
+				err := NormalizeFile(src, dst, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkRotated(t, longPath(dst))
+			checkRotated(t, longPath(filepath.Join(dir, test.second)))
+		})
+	}
+}
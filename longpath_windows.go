@@ -0,0 +1,25 @@
+package exiflign
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath returns path in the extended-length form, with the \\?\ prefix,
+// which Windows requires of paths longer than MAX_PATH and which works for
+// paths of any length.  Paths already in that form, and paths that cannot
+// be made absolute, are returned as they are.
+func longPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+
+	return `\\?\` + abs
+}
@@ -0,0 +1,32 @@
+package exiflign
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\Photos\photo.jpg`, `\\?\C:\Photos\photo.jpg`},
+		{`C:\Photos\..\photo.jpg`, `\\?\C:\photo.jpg`},
+		{`C:/Photos/photo.jpg`, `\\?\C:\Photos\photo.jpg`},
+		{`\\server\share\photo.jpg`, `\\?\UNC\server\share\photo.jpg`},
+		{`\\?\C:\Photos\photo.jpg`, `\\?\C:\Photos\photo.jpg`},
+		{`\\?\UNC\server\share\photo.jpg`, `\\?\UNC\server\share\photo.jpg`},
+		{`photo.jpg`, `\\?\` + filepath.Join(wd, "photo.jpg")},
+	}
+
+	for _, test := range tests {
+		if got := longPath(test.path); got != test.want {
+			t.Errorf("longPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package exiflign
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+var NotLosslesslyTransformableError error = errors.New("The given image cannot be normalized without being re-encoded.")
+
+// Mode selects how NormalizeWithOptions corrects the orientation of an image.
+type Mode int
+
+const (
+	// ModeReencode decodes the image, transforms its pixels and encodes the
+	// result, losing some quality to the second round of compression.
+	ModeReencode Mode = iota
+
+	// ModeLossless rearranges the compressed blocks of baseline JPEG images
+	// tagged with an orientation, without decoding them, so that no quality
+	// is lost.  Images that cannot be transformed this way, such as
+	// progressive images or those whose dimensions are not a multiple of the
+	// block size along a flipped axis without Options.Trim, are re-encoded
+	// instead, as are all images when a Hook or ColorManager is set.
+	ModeLossless
+
+	// ModeAuto chooses the cheapest way to correct each image that loses
+	// nothing more than it must, recording its choice in Result.Method.
+	// Images already upright only have their orientation tag rewritten, and
+	// images whose headers show them to be baseline images with dimensions
+	// a multiple of the MCU size along the flipped axes are transformed
+	// losslessly, without their compressed data being decoded first to find
+	// out.  Every other image is re-encoded, as under ModeLossless.
+	ModeAuto
+
+	// ModePerfect transforms images like ModeLossless, but fails with
+	// NotLosslesslyTransformableError rather than re-encode any image, so
+	// that archives can be normalized with the guarantee that no generation
+	// loss ever occurs.  Images that need no transformation are still copied,
+	// and images Options.Suggester suggests an orientation for, or that a
+	// Hook or ColorManager would alter, fail too.
+	ModePerfect
+)
+
+// Method is the way NormalizeWithOptions corrected an image.
+type Method int
+
+const (
+	// MethodNone is the Method of results that do not record one, such as
+	// those taken from Options.Cache.
+	MethodNone Method = iota
+
+	// MethodCopy copies the image unchanged, apart from any metadata
+	// rewritten under Options.
+	MethodCopy
+
+	// MethodTagRewrite leaves the compressed data of an image tagged as
+	// upright as it is, and only rewrites its metadata.
+	MethodTagRewrite
+
+	// MethodLossless rearranges the compressed blocks of the image.
+	MethodLossless
+
+	// MethodReencode decodes, transforms and re-encodes the pixels of the
+	// image.
+	MethodReencode
+)
+
+// String returns the name of m, as reported by the command line tool.
+func (m Method) String() string {
+	switch m {
+	case MethodCopy:
+		return "copy"
+	case MethodTagRewrite:
+		return "tag-rewrite"
+	case MethodLossless:
+		return "lossless"
+	case MethodReencode:
+		return "reencode"
+	default:
+		return ""
+	}
+}
+
+// SegmentPolicy controls which APPn and COM segments of the original are kept
+// when an image is transformed losslessly.  The orientation tag of a kept
+// EXIF segment is always rewritten to 1.  Re-encoded images carry no such
+// segments, other than comments kept under Options.Comments.
+type SegmentPolicy int
+
+const (
+	// KeepAll keeps every APPn and COM segment byte for byte.
+	KeepAll SegmentPolicy = iota
+
+	// KeepKnown keeps only JFIF, EXIF, XMP, ICC profile, Photoshop and Adobe
+	// segments, along with comments, dropping vendor-specific segments such
+	// as maker previews and multi-picture indexes whose offsets no longer
+	// describe the transformed image.
+	KeepKnown
+
+	// StripAll drops every APPn and COM segment except an Adobe segment,
+	// which determines how the color components are interpreted.
+	StripAll
+)
+
+var xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+var adobeHeader = []byte("Adobe")
+
+// knownSegments lists the identifiers of the segments kept by KeepKnown, by
+// marker.
+var knownSegments = map[byte][][]byte{
+	markerAPP0:  {[]byte("JFIF\x00"), []byte("JFXX\x00")},
+	markerAPP1:  {exifHeader, xmpHeader},
+	markerAPP2:  {iccHeader},
+	markerAPP13: {photoshopHeader},
+	markerAPP14: {adobeHeader},
+}
+
+// keep implements jpegenc.KeepFunc for p.
+func (p SegmentPolicy) keep(marker byte, payload []byte) ([]byte, bool) {
+	switch p {
+	case KeepKnown:
+		if marker != markerCOM && !hasKnownHeader(marker, payload) {
+			return nil, false
+		}
+	case StripAll:
+		if marker != markerAPP14 || !bytes.HasPrefix(payload, adobeHeader) {
+			return nil, false
+		}
+	}
+
+	if isExifSegment(marker, payload) {
+		payload = append([]byte(nil), payload...)
+		setExifOrientation(payload[len(exifHeader):], 1)
+	}
+
+	return payload, true
+}
+
+// rewritesCopies reports whether o requires images to be altered even when
+// they would otherwise be copied through unchanged.
+func (o *Options) rewritesCopies() bool {
+	return o.Comments == CommentsStrip || o.Comments == CommentsReplace || len(o.Geofences) > 0 || o.ExifVersion != "" || o.TimeShift != 0
+}
+
+// losslessKeeper returns the jpegenc.KeepFunc for a lossless transformation
+// under o, recording what was done in res.  exif is the EXIF data of the
+// image as returned by findExifSegment, or nil if it has none, and size the
+// dimensions of the output if it was trimmed.
+func (o *Options) losslessKeeper(res *Result, exif []byte, size image.Point) jpegenc.KeepFunc {
+	editor := &exifEditor{block: exif, edit: func(payload []byte) []byte {
+		setExifOrientation(payload[len(exifHeader):], 1)
+		if res.Trimmed {
+			resizeExifDimensions(payload[len(exifHeader):], size)
+		} else if res.Orientation >= 5 && res.Orientation <= 8 {
+			swapExifDimensions(payload[len(exifHeader):])
+		}
+		return o.editExif(payload, res)
+	}}
+
+	return func(marker byte, payload []byte) ([]byte, bool) {
+		if marker == markerCOM && o.Comments != CommentsDefault {
+			return payload, o.Comments == CommentsPreserve
+		}
+		if o.BackupExif && isBackupSegment(marker, payload) {
+			return nil, false
+		}
+
+		payload, ok := o.Segments.keep(marker, standardExifPayload(marker, payload))
+		if ok && isExifSegment(marker, payload) {
+			payload = editor.next(payload)
+		}
+		return payload, ok
+	}
+}
+
+// copyKeeper returns the jpegenc.KeepFunc for an image copied through under
+// o, recording what was done in res.  exif is as for losslessKeeper.
+func (o *Options) copyKeeper(res *Result, exif []byte) jpegenc.KeepFunc {
+	editor := &exifEditor{block: exif, edit: func(payload []byte) []byte {
+		return o.editExif(payload, res)
+	}}
+
+	return func(marker byte, payload []byte) ([]byte, bool) {
+		if marker == markerCOM && (o.Comments == CommentsStrip || o.Comments == CommentsReplace) {
+			return nil, false
+		}
+		if o.BackupExif && isBackupSegment(marker, payload) {
+			return nil, false
+		}
+
+		payload = standardExifPayload(marker, payload)
+		if isExifSegment(marker, payload) {
+			payload = editor.next(payload)
+		}
+		return payload, true
+	}
+}
+
+// swapExifDimensions exchanges the recorded pixel dimensions of the TIFF
+// structure data in place, for images turned by a quarter turn.
+func swapExifDimensions(data []byte) {
+	x, err := parseExif(data)
+	if err != nil || x.PixelXDimension == 0 || x.PixelYDimension == 0 {
+		return
+	}
+
+	setExifDimensions(data, x.PixelYDimension, x.PixelXDimension)
+}
+
+// resizeExifDimensions sets the recorded pixel dimensions of the TIFF
+// structure data in place to size, for trimmed images.
+func resizeExifDimensions(data []byte, size image.Point) {
+	x, err := parseExif(data)
+	if err != nil || x.PixelXDimension == 0 || x.PixelYDimension == 0 {
+		return
+	}
+
+	setExifDimensions(data, size.X, size.Y)
+}
+
+// hasKnownHeader reports whether payload starts with one of the identifiers
+// in knownSegments for marker.
+func hasKnownHeader(marker byte, payload []byte) bool {
+	for _, header := range knownSegments[marker] {
+		if bytes.HasPrefix(payload, header) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// losslessOps gives the lossless transformation that corrects each
+// orientation tag.  Index 0 is unused.
+var losslessOps = [9]jpegenc.Transformation{
+	{},
+	{},
+	{FlipH: true},
+	{FlipH: true, FlipV: true},
+	{FlipV: true},
+	{Transpose: true},
+	{Transpose: true, FlipH: true},
+	{Transpose: true, FlipH: true, FlipV: true},
+	{Transpose: true, FlipV: true},
+}
+
+// normalizeLossless corrects the orientation of r, tagged with tag, in the DCT
+// domain.  It returns jpegenc.ErrNotTransformable, having written nothing to
+// w, if r needs to be re-encoded instead.
+func normalizeLossless(r io.ReadSeeker, w io.Writer, tag uint16, opts *Options) (_ *Result, err error) {
+	defer recoverPanic("lossless", &err)
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	backup, err := opts.backupSegments(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	exif, err := findExifSegment(bytes.NewReader(data), int64(len(data)))
+	if err != nil && err != NoExifError {
+		return nil, err
+	}
+
+	op := losslessOps[tag]
+	op.Trim = opts.Trim
+	if opts.Mode == ModeAuto && !jpegenc.Transformable(data, op) {
+		return nil, jpegenc.ErrNotTransformable
+	}
+
+	res := &Result{Orientation: tag, Lossless: true, Method: MethodLossless}
+	if tag == 1 {
+		res.Method = MethodTagRewrite
+	}
+	var size image.Point
+	if op.Trim && !jpegenc.Transformable(data, losslessOps[tag]) {
+		size.X, size.Y, err = jpegenc.OutputSize(data, op)
+		if err != nil {
+			return nil, err
+		}
+		res.Trimmed = true
+	}
+	err = jpegenc.Transform(w, data, op, opts.losslessKeeper(res, exif, size), append(opts.extraComments(), backup...))
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,68 @@
+package exiflign
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+var OutputTooLargeError error = errors.New("The given image cannot be encoded within the maximum output size.")
+
+// encodeWithin encodes img like encodeJPEG at the highest quality up to
+// opts.Quality whose output, segments included, fits in opts.MaxBytes, and
+// returns that output.  The quantization tables quant, if not nil, are tried
+// first.  The size of the output is taken to grow with the quality, so that
+// the quality can be found by binary search.
+func encodeWithin(img image.Image, segments []jpegenc.Segment, quant *[2][64]uint16, opts *Options, res *Result) ([]byte, error) {
+	var best []byte
+	fits := func(quality int, quant *[2][64]uint16) (bool, error) {
+		var buffer bytes.Buffer
+		err := encodeJPEG(&buffer, img, segments, quality, quant, opts, res)
+		if err != nil || int64(buffer.Len()) > opts.MaxBytes {
+			return false, err
+		}
+
+		best, res.Quality = buffer.Bytes(), quality
+		return true, nil
+	}
+
+	if quant != nil {
+		ok, err := fits(0, quant)
+		if ok || err != nil {
+			return best, err
+		}
+	}
+
+	high := opts.Quality
+	if high == 0 {
+		high = jpeg.DefaultQuality
+	}
+	// Most images fit at the requested quality, which is tried first so
+	// that they are encoded only once.
+	ok, err := fits(high, nil)
+	if ok || err != nil {
+		return best, err
+	}
+
+	low := 1
+	for high--; low <= high; {
+		mid := (low + high) / 2
+		ok, err := fits(mid, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+	if best == nil {
+		return nil, OutputTooLargeError
+	}
+
+	return best, nil
+}
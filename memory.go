@@ -0,0 +1,149 @@
+package exiflign
+
+import (
+	"errors"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+var MemoryBudgetExceededError error = errors.New("Normalizing the image would exceed the memory budget.")
+
+// defaultWriteBufferSize is the size of the buffer bufio uses when
+// Options.WriteBufferSize is zero.
+const defaultWriteBufferSize = 4096
+
+// memoryBudget accounts for the memory allocated by a single normalization.
+// The cost of each step is estimated from the size and the dimensions of the
+// original before the step is taken, so the budget is enforced before the
+// allocations it covers are made rather than after the process has run out
+// of memory.  Images that image/jpeg cannot read the header of are only
+// accounted for by their size.
+type memoryBudget struct {
+	limit int64
+	used  int64
+
+	size     int64
+	pixels   int64
+	channels int64
+}
+
+// newMemoryBudget returns the memoryBudget for normalizing r under opts.  It
+// is unlimited, and nothing is read from r, unless opts.MemoryBudget is set.
+func newMemoryBudget(r io.ReadSeeker, opts *Options) (*memoryBudget, error) {
+	m := &memoryBudget{limit: opts.MemoryBudget}
+	if m.limit <= 0 {
+		return m, nil
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	m.size = size
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg, err := jpeg.DecodeConfig(r); err == nil {
+		m.pixels = int64(cfg.Width) * int64(cfg.Height)
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			m.channels = 1
+		case color.CMYKModel:
+			m.channels = 4
+		default:
+			m.channels = 3
+		}
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	return m, err
+}
+
+// reserve accounts for n more bytes, reporting MemoryBudgetExceededError once
+// the total exceeds the limit.
+func (m *memoryBudget) reserve(n int64) error {
+	m.used += n
+	if m.limit > 0 && m.used > m.limit {
+		return MemoryBudgetExceededError
+	}
+
+	return nil
+}
+
+// reserveCopy accounts for copying the original to the output, which only
+// needs it in memory when opts rewrites its segments.
+func (m *memoryBudget) reserveCopy(opts *Options) error {
+	if !opts.rewritesCopies() {
+		return nil
+	}
+
+	return m.reserve(m.size)
+}
+
+// reserveLossless accounts for transforming the original losslessly, which
+// holds it in memory along with the coefficients of the original and of the
+// transformed image.  Each sample has a coefficient of four bytes.
+func (m *memoryBudget) reserveLossless() error {
+	return m.reserve(m.size + 2*4*m.channels*m.pixels)
+}
+
+// reserveDecode accounts for decoding the original and transforming it for
+// tag.  Grayscale and YCbCr images are transformed in their own format, flips
+// and the half turn in place, whereas other images are converted to NRGBA.
+// The orientation of an untagged image is not known before a Suggester has
+// seen it, so a quarter turn is assumed for them.
+func (m *memoryBudget) reserveDecode(tag uint16, tagged bool, opts *Options) error {
+	if !tagged {
+		tag = 1
+		if opts.Suggester != nil {
+			tag = 6
+		}
+	}
+
+	n := m.channels * m.pixels
+	native := m.channels < 4
+	switch {
+	case tag >= 5 && tag <= 8 && native:
+		n += m.channels * m.pixels
+	case tag >= 2 && tag <= 8 && !native:
+		n += 4 * m.pixels
+	}
+	if opts.ColorManager != nil {
+		n += 4 * m.pixels
+	}
+
+	return m.reserve(n)
+}
+
+// reserveEncode accounts for encoding the image, which is streamed through
+// the write buffer unless the subsampling is preserved, in which case it is
+// first converted to full resolution planes.  Under opts.MaxBytes, the best
+// output so far and the one being tried are kept in memory too.
+func (m *memoryBudget) reserveEncode(opts *Options) error {
+	n := int64(opts.WriteBufferSize)
+	if n <= 0 {
+		n = defaultWriteBufferSize
+	}
+	if opts.PreserveSubsampling {
+		n += m.channels * m.pixels
+	}
+	if opts.MaxBytes > 0 {
+		n += 2 * opts.MaxBytes
+	}
+
+	return m.reserve(n)
+}
+
+// reserveImage accounts for decoding the original to compute its perceptual
+// hashes or keep its pixels, when they are requested but it was otherwise not
+// decoded.
+func (m *memoryBudget) reserveImage(tag uint16, opts *Options) error {
+	if !opts.PerceptualHashes && !opts.KeepImage {
+		return nil
+	}
+
+	return m.reserveDecode(tag, true, &Options{})
+}
@@ -0,0 +1,160 @@
+package exiflign
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// Middleware wraps h so that any image/jpeg response it produces is
+// normalized on the way out.  This allows corrected images to be served from
+// an origin handler that cannot itself be modified.  Only complete, successful
+// responses are rewritten, encoded (e.g. gzip) bodies are passed through
+// untouched, as are images that fail to normalize.  Range requests are passed
+// to h as requests for the whole image, since ranges of the original do not
+// describe the normalized image, and HEAD responses for images lose the
+// validators, ranges and length of the original in the same way as GET
+// responses.  A nil opts behaves like Normalize.
+func Middleware(h http.Handler, opts *Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" || r.Header.Get("If-Range") != "" {
+			r = r.Clone(r.Context())
+			r.Header.Del("Range")
+			r.Header.Del("If-Range")
+		}
+
+		jw := &jpegResponseWriter{ResponseWriter: w, head: r.Method == http.MethodHead}
+		h.ServeHTTP(jw, r)
+		jw.finish(opts)
+	})
+}
+
+// ModifyResponse returns a function suitable for the ModifyResponse field of
+// httputil.ReverseProxy, which normalizes image/jpeg responses from the
+// upstream server in the same way as Middleware.
+func ModifyResponse(opts *Options) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if !shouldNormalizeResponse(resp.StatusCode, resp.Header) {
+			return nil
+		}
+		if resp.Request != nil && resp.Request.Method == http.MethodHead {
+			dropOriginHeaders(resp.Header)
+			resp.ContentLength = -1
+			return nil
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		data = normalizeResponse(data, resp.Header, opts)
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		resp.ContentLength = int64(len(data))
+
+		return nil
+	}
+}
+
+// shouldNormalizeResponse reports whether a response with the given status and
+// header carries a complete JPEG image that can be normalized.
+func shouldNormalizeResponse(status int, header http.Header) bool {
+	if status != http.StatusOK || header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "image/jpeg"
+}
+
+// normalizeResponse normalizes the response body data and updates header to
+// match.  If normalization fails, data is returned unchanged.
+func normalizeResponse(data []byte, header http.Header, opts *Options) []byte {
+	var buffer bytes.Buffer
+	res, err := NormalizeWithOptions(bytes.NewReader(data), &buffer, opts)
+	if err != nil || res.Copied {
+		return data
+	}
+
+	dropOriginHeaders(header)
+	header.Set("Content-Length", strconv.Itoa(buffer.Len()))
+
+	return buffer.Bytes()
+}
+
+// dropOriginHeaders removes the validators, ranges and length computed by
+// the origin from header, as they no longer describe the body being sent.
+func dropOriginHeaders(header http.Header) {
+	header.Del("ETag")
+	header.Del("Accept-Ranges")
+	header.Del("Content-Length")
+}
+
+// jpegResponseWriter buffers a JPEG response so that it can be normalized
+// once the wrapped handler has finished.
+type jpegResponseWriter struct {
+	http.ResponseWriter
+
+	status    int
+	decided   bool
+	buffering bool
+	buffer    bytes.Buffer
+
+	// head is set for responses to HEAD requests, which carry no body to
+	// normalize, so only their header is updated.
+	head bool
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *jpegResponseWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+
+	w.decided = true
+	w.status = status
+	w.buffering = shouldNormalizeResponse(status, w.Header())
+	if w.buffering && w.head {
+		dropOriginHeaders(w.Header())
+		w.buffering = false
+	}
+	if !w.buffering {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (w *jpegResponseWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.buffering {
+		return w.buffer.Write(p)
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for use by
+// http.ResponseController.
+func (w *jpegResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish normalizes and sends any buffered response.
+func (w *jpegResponseWriter) finish(opts *Options) {
+	if !w.buffering {
+		return
+	}
+
+	data := normalizeResponse(w.buffer.Bytes(), w.Header(), opts)
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(data)
+}
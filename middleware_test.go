@@ -0,0 +1,66 @@
+package exiflign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareOriginHeaders(t *testing.T) {
+	exif := append(append([]byte(nil), exifHeader...), orientationTIFF(binary.LittleEndian, 6)...)
+	img := buildJPEG(t, 32, 16, map[byte][]byte{markerAPP1: exif})
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"original"`)
+		http.ServeContent(w, r, "photo.jpg", time.Time{}, bytes.NewReader(img))
+	})
+	h := Middleware(origin, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+	}{
+		{"get", http.MethodGet, nil},
+		{"head", http.MethodHead, nil},
+		{"range", http.MethodGet, map[string]string{"Range": "bytes=0-99"}},
+		{"if-range", http.MethodGet, map[string]string{"Range": "bytes=100-", "If-Range": `"original"`}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/photo.jpg", nil)
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want %d", rec.Code, http.StatusOK)
+			}
+			for _, k := range []string{"ETag", "Accept-Ranges"} {
+				if v := rec.Header().Get(k); v != "" {
+					t.Errorf("%s: %q, want none", k, v)
+				}
+			}
+
+			if test.method == http.MethodHead {
+				if v := rec.Header().Get("Content-Length"); v != "" {
+					t.Errorf("Content-Length: %q, want none", v)
+				}
+				return
+			}
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != 16 || cfg.Height != 32 {
+				t.Errorf("size %dx%d, want 16x32", cfg.Width, cfg.Height)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !amd64 && !arm64
+
+package exiflign
+
+// mirrorRow reverses the order of the samples of size n in r.
+func mirrorRow(r []byte, n int) {
+	for i, j := 0, len(r)-n; i < j; i, j = i+n, j-n {
+		for k := 0; k < n; k++ {
+			r[i+k], r[j+k] = r[j+k], r[i+k]
+		}
+	}
+}
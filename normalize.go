@@ -0,0 +1,789 @@
+package exiflign
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// Options controls the behaviour of NormalizeWithOptions.  A nil *Options is
+// equivalent to the zero value, which behaves exactly like Normalize.
+type Options struct {
+	// Quality is the JPEG quality, between 1 and 100 inclusive, that
+	// transformed images are encoded with.  If zero, jpeg.DefaultQuality is
+	// used.
+	Quality int
+
+	// MaxBytes, if positive, bounds the size of re-encoded images, which are
+	// encoded at the highest quality up to Quality whose output fits, found
+	// by binary search from the decoded and transformed image, so that the
+	// transformation is performed only once.  Images that do not fit even at
+	// quality 1 fail with OutputTooLargeError.  Images copied or transformed
+	// losslessly are not bounded.
+	MaxBytes int64
+
+	// Suggester, if non-nil, is consulted for images that carry no EXIF
+	// orientation information.  If it suggests an orientation other than 1
+	// with a confidence of at least MinConfidence, the image is transformed as
+	// though it had been tagged with that orientation.  Without a Suggester,
+	// such images are copied through untouched.
+	Suggester Suggester
+
+	// MinConfidence is the minimum confidence, between 0 and 1, that a
+	// suggestion must have to be applied.  If zero, DefaultMinConfidence is
+	// used.
+	MinConfidence float64
+
+	// OrientationCache, if non-nil, is used to look up the orientation of
+	// images by their content hash instead of scanning them.
+	OrientationCache OrientationCache
+
+	// ContentHash, if non-zero, is used as the content hash of the image in
+	// place of hashing it, for OrientationCache and Cache, which would
+	// otherwise read the whole image on every lookup.  It must identify the
+	// content of the image being normalized, such as a digest stored with it,
+	// and so must be set anew for every image.
+	ContentHash Hash
+
+	// Cache, if non-nil, holds normalized images keyed by the content hash of
+	// the original and these options, so that normalizing identical input
+	// again returns the stored result without decoding it.  Functions and
+	// interfaces cannot be told apart by their settings, so Cache is bypassed
+	// when Hook is set without HookKey, Suggester is set but is not a
+	// KeyedSuggester, or ColorManager is set but is not a KeyedColorManager.
+	Cache ResultCache
+
+	// Hook, if non-nil, is called with every decoded image once its
+	// orientation has been corrected, along with its parsed EXIF data, which
+	// is nil if the image has none.  The image returned by Hook is encoded in
+	// place of the original, allowing enhancements such as denoising or gamma
+	// correction to be driven by exposure settings without a second decode or
+	// parsing the metadata separately.  Setting Hook causes every image to be
+	// re-encoded, including those without orientation information.
+	Hook func(img image.Image, x *Exif) (image.Image, error)
+
+	// HookKey identifies Hook and its settings in Cache keys, and must change
+	// whenever Hook would produce different images, such as when a closure
+	// captures a different gamma.  Without it, images are not cached while
+	// Hook is set.
+	HookKey string
+
+	// ColorManager, if non-nil, is used to convert images that carry an ICC
+	// profile other than sRGB to sRGB, rather than passing wide-gamut pixel
+	// data on to consumers that assume sRGB.  Since the output does not carry
+	// the profile, images without it would otherwise display with the wrong
+	// colors.  Setting ColorManager causes every image with such a profile to
+	// be re-encoded.
+	ColorManager ColorManager
+
+	// PreserveSubsampling causes transformed images to be encoded with the
+	// chroma subsampling of the original, rather than the 4:2:0 that
+	// image/jpeg always produces, keeping them visually and size-wise close
+	// to the originals.  Images rotated by a quarter turn have 4:2:2 and
+	// 4:4:0 swapped, so that chroma resolution is kept along the same axis.
+	PreserveSubsampling bool
+
+	// PreserveQuantization causes re-encoded images to be encoded with the
+	// quantization tables of the originals, transposed for images rotated by
+	// a quarter turn, rather than the standard tables scaled to Quality.
+	// This adds as little loss as re-encoding allows and keeps the outputs
+	// close to the originals in size.  It is best combined with
+	// PreserveSubsampling.  Images converted by ColorManager, and those whose
+	// tables cannot be read, are encoded at Quality.
+	PreserveQuantization bool
+
+	// Mode selects how tagged images are transformed.  The zero value,
+	// ModeReencode, decodes and re-encodes them.
+	Mode Mode
+
+	// Trim, under ModeLossless, ModeAuto and ModePerfect, transforms images
+	// whose dimensions are not a multiple of the MCU size along a flipped
+	// axis losslessly all the same, dropping the partial MCUs at their edges,
+	// up to 15 pixels along each flipped axis, rather than re-encoding them.
+	Trim bool
+
+	// Segments controls which APPn and COM segments survive a lossless
+	// transformation.
+	Segments SegmentPolicy
+
+	// Comments controls the COM segments of the output, which are otherwise
+	// dropped whenever an image is re-encoded.
+	Comments CommentPolicy
+
+	// Comment is the text written in place of the original comments under
+	// CommentsReplace.
+	Comment string
+
+	// Geofences, if non-empty, causes the GPS data of images taken within
+	// any of the areas to be removed, for example to avoid revealing where
+	// users live while keeping the location of other photos.  Re-encoded
+	// images only carry EXIF data under PreserveExif.
+	Geofences []Geofence
+
+	// PerceptualHashes causes the DHash and PHash of every normalized image
+	// to be recorded in its Result, computed on the orientation-corrected
+	// pixels so that they do not depend on how the original was tagged.
+	// Images that would otherwise not be decoded, because they are copied
+	// through or transformed losslessly, are decoded for the purpose.
+	PerceptualHashes bool
+
+	// KeepImage causes the orientation-corrected pixels of every normalized
+	// image to be kept in its Result, for programs that go on to process
+	// them with other libraries, through Result.Image, Result.NRGBA and
+	// Result.Raw, rather than decoding the output again.  Like under
+	// PerceptualHashes, images that would otherwise not be decoded are
+	// decoded for the purpose.
+	KeepImage bool
+
+	// PreserveICC causes the ICC profile of the original to be carried into
+	// re-encoded images, split across as many APP2 segments as it needs,
+	// unless the image was converted to sRGB by ColorManager.  Without it,
+	// re-encoded images carry no profile, and wide-gamut images display with
+	// the wrong colors.
+	PreserveICC bool
+
+	// PreserveExif causes the EXIF data of the original to be carried into
+	// re-encoded images, with the orientation reset to 1 and the pixel
+	// dimensions updated.  The data is edited in place rather than rebuilt,
+	// so the Interoperability IFD, FlashPix version and maker notes survive
+	// with valid offsets.
+	PreserveExif bool
+
+	// ExifVersion, if set, is written to the ExifVersion tag of any EXIF data
+	// in the output, such as ExifVersion232, and tags not valid in that
+	// version are dropped, for pipelines that must produce strictly
+	// compliant files.  Tags added in later versions are not synthesized when
+	// upgrading.
+	ExifVersion string
+
+	// TimeShift, if non-zero, is added to the DateTime, DateTimeOriginal and
+	// DateTimeDigitized tags of any EXIF data in the output, for example to
+	// correct the time zone of a camera whose clock was set for another.
+	// Images are rewritten even when they would otherwise be copied through
+	// unchanged.  Re-encoded images only carry EXIF data under PreserveExif.
+	TimeShift time.Duration
+
+	// PreserveIPTC causes the Photoshop APP13 segments of the original, which
+	// hold the IPTC captions, credits and keywords that newsroom and asset
+	// management workflows rely on, to be carried into re-encoded images
+	// byte for byte.  Losslessly transformed images keep them under
+	// KeepAll and KeepKnown regardless.
+	PreserveIPTC bool
+
+	// Audit, if non-nil, receives a record of every normalized image, with
+	// the content hashes of the original and the output, the orientation
+	// applied and the metadata that was stripped, for archives that must
+	// keep evidence of what was altered.
+	Audit AuditSink
+
+	// BackupExif causes the EXIF data of the original to be stashed, byte for
+	// byte, in private APP15 segments of the output, from which RestoreExif
+	// can restore it later.  Images that already carry a backup keep it.
+	// Images copied through unchanged keep their EXIF data and need no
+	// backup.
+	BackupExif bool
+
+	// QuarantinePolicy, if non-nil, classifies every original before it is
+	// normalized.  Suspicious originals are not normalized: they are passed
+	// unchanged to Quarantine, and QuarantinedError is returned without
+	// anything having been written to w.
+	QuarantinePolicy QuarantinePolicy
+
+	// Quarantine, if non-nil, receives every original found suspicious by
+	// QuarantinePolicy, along with the reason, for example to store it for
+	// inspection.
+	Quarantine func(r io.Reader, reason string) error
+
+	// Decoders are tried in order on images that image/jpeg fails to decode,
+	// before giving up.
+	Decoders []Decoder
+
+	// MemoryBudget, if positive, caps the memory in bytes that normalizing a
+	// single image may allocate, covering the original when it is read into
+	// memory, the decoded and transformed pixels, the encoder's buffers and
+	// the copy kept for Cache.  The cost of each step is estimated from the
+	// dimensions in the image's header before the step is taken, and
+	// MemoryBudgetExceededError is returned instead of taking a step that
+	// would exceed the budget, so that one large upload cannot exhaust the
+	// memory of a service shared by many tenants.  Memory allocated by Hook
+	// or by Decoders beyond the size of their image is not accounted
+	// for.
+	MemoryBudget int64
+
+	// WriteBufferSize is the size in bytes of the buffer that re-encoded
+	// images are written to w through.  Slow sinks with a large cost per
+	// write, such as the writer of a multipart upload, benefit from a buffer
+	// as large as a part.  If zero, the 4096 bytes of bufio are used.  If w is
+	// already a *bufio.Writer, it is written to directly and WriteBufferSize
+	// is ignored.
+	WriteBufferSize int
+
+	// LenientEndianness causes the orientation of images whose EXIF byte
+	// order marker contradicts the byte order the data was actually written
+	// in, as some buggy editors produce, to be read in whichever byte order
+	// yields a valid orientation, rather than the image being treated as
+	// having none.  The OrientationCache is not consulted under it.
+	LenientEndianness bool
+
+	// RejectUnsupported causes GIF and JPEG XL images, which cannot be
+	// normalized, to be rejected with UnsupportedFormatError, rather than
+	// copied through unchanged like JPEG images without EXIF data.
+	RejectUnsupported bool
+
+	// Timeout, if positive, bounds the time NormalizeWithOptions may take,
+	// since malformed images can make decoders pathologically slow.  Images
+	// that take longer fail with TimeoutError, and nothing is written to w.
+	// The output is then held in memory until it is complete.
+	Timeout time.Duration
+
+	// orientation, if non-zero, is the orientation tag to apply in place of
+	// the one embedded in the image, as read from its XMP sidecar.
+	orientation uint16
+
+	// buffers, if non-nil, is the pool of write buffers of the Normalizer
+	// these options belong to.
+	buffers *sync.Pool
+}
+
+// cacheable reports whether the images normalized with o can be kept in
+// o.Cache, which requires Hook, Suggester and ColorManager to identify
+// themselves.
+func (o *Options) cacheable() bool {
+	if o.Hook != nil && o.HookKey == "" {
+		return false
+	}
+	if _, ok := o.Suggester.(KeyedSuggester); o.Suggester != nil && !ok {
+		return false
+	}
+	if _, ok := o.ColorManager.(KeyedColorManager); o.ColorManager != nil && !ok {
+		return false
+	}
+
+	return true
+}
+
+// contentHash returns o.ContentHash if it is set, or the Hash of r
+// otherwise.
+func (o *Options) contentHash(r io.ReadSeeker) (Hash, error) {
+	if o.ContentHash != (Hash{}) {
+		return o.ContentHash, nil
+	}
+
+	return HashOf(r)
+}
+
+// cacheKey returns a string identifying the content with hash h normalized
+// with o, for use as a ResultCache key.  Every option that affects the output
+// must be represented in the key.
+func (o *Options) cacheKey(h Hash) string {
+	return fmt.Sprintf("%s:%d:%s:%g:%q:%s:%t:%d:%d:%d:%q:%v:%t:%t:%t:%s:%v:%t:%t:%t:%d:%t:%t:%d", h, o.Quality, suggesterKey(o.Suggester), o.MinConfidence, o.HookKey, colorManagerKey(o.ColorManager), o.PreserveSubsampling, o.Mode, o.Segments, o.Comments, o.Comment, o.Geofences, o.PreserveICC, o.PreserveIPTC, o.PreserveExif, o.ExifVersion, o.TimeShift, o.BackupExif, o.LenientEndianness, o.RejectUnsupported, o.orientation, o.Trim, o.PreserveQuantization, o.MaxBytes)
+}
+
+// Result describes what NormalizeWithOptions did to an image.
+type Result struct {
+	// Orientation is the orientation tag that was applied to the image.  It is
+	// 1 when the image was not transformed.
+	Orientation uint16
+
+	// Suggested is set when Orientation came from Options.Suggester rather
+	// than from the image's EXIF data.
+	Suggested bool
+
+	// NoExif is set when the image carried no EXIF orientation information,
+	// whether or not Options.Suggester suggested an orientation for it.
+	NoExif bool
+
+	// Confidence is the confidence reported by Options.Suggester.  It is only
+	// meaningful when Suggested is set.
+	Confidence float64
+
+	// Copied is set when r was copied to w unchanged rather than re-encoded,
+	// apart from any comments or GPS data rewritten under Options.
+	Copied bool
+
+	// ColorConverted is set when the image was converted to sRGB by
+	// Options.ColorManager.
+	ColorConverted bool
+
+	// Subsampling is the chroma subsampling of the original image, or
+	// SubsamplingDefault if it is grayscale or was not decoded.
+	Subsampling Subsampling
+
+	// GPSRedacted is set when GPS data was removed under Options.Geofences.
+	GPSRedacted bool
+
+	// Lossless is set when the image was transformed without being decoded,
+	// as requested by ModeLossless or ModeAuto.
+	Lossless bool
+
+	// Method is the way the image was corrected.
+	Method Method
+
+	// Trimmed is set when the partial MCUs at the edges of the image were
+	// dropped under Options.Trim.
+	Trimmed bool
+
+	// Quality is the quality the image was re-encoded at to fit in
+	// Options.MaxBytes, or 0 if it fit with the quantization tables of the
+	// original.  It is only set under Options.MaxBytes.
+	Quality int
+
+	// Decoder is the element of Options.Decoders that decoded the image, or
+	// nil if it was decoded by image/jpeg or not at all.
+	Decoder Decoder
+
+	// DHash and PHash are the perceptual hashes of the normalized image,
+	// only computed under Options.PerceptualHashes.
+	DHash PerceptualHash
+	PHash PerceptualHash
+
+	// Cached is set when the output was taken from Options.Cache.  Suggested,
+	// Confidence, Method, the perceptual hashes and the kept image are not
+	// recorded by the cache, so they are never set for a cached result.
+	Cached bool
+
+	// image is the orientation-corrected image, kept under
+	// Options.KeepImage.
+	image image.Image
+}
+
+// NormalizeWithOptions is like Normalize, but its behaviour can be adjusted
+// through opts and it reports what was done to the image.  When finished, the
+// internal position in r will be at io.SeekStart, unless r was copied to w.
+func NormalizeWithOptions(r io.ReadSeeker, w io.Writer, opts *Options) (res *Result, err error) {
+	defer func() { identifyPanic(r, err) }()
+	defer recoverPanic("normalize", &err)
+
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.ExifVersion != "" && !validExifVersion(opts.ExifVersion) {
+		return nil, InvalidExifVersionError
+	}
+	if opts.Timeout > 0 {
+		return normalizeTimed(r, w, opts)
+	}
+
+	if opts.QuarantinePolicy != nil {
+		err := opts.quarantine(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := newMemoryBudget(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Audit != nil {
+		return normalizeAudited(r, w, opts, m)
+	}
+
+	return normalizeOrCached(r, w, opts, m)
+}
+
+// normalizeOrCached normalizes r, through opts.Cache if there is one.
+func normalizeOrCached(r io.ReadSeeker, w io.Writer, opts *Options, m *memoryBudget) (*Result, error) {
+	if opts.Cache != nil && opts.cacheable() {
+		return normalizeCached(r, w, opts, m)
+	}
+
+	return normalize(r, w, opts, m)
+}
+
+// normalizeCached normalizes r through opts.Cache.
+func normalizeCached(r io.ReadSeeker, w io.Writer, opts *Options, m *memoryBudget) (*Result, error) {
+	h, err := opts.contentHash(r)
+	if err != nil {
+		return nil, err
+	}
+	key := opts.cacheKey(h)
+
+	if data, ok := opts.Cache.Get(key); ok {
+		res := &Result{Orientation: 1, Cached: true}
+		if tag, err := getOrientationTag(r, opts); err == nil {
+			res.Orientation = tag
+		} else if err == NoExifError {
+			res.NoExif = true
+		}
+
+		_, err = w.Write(data)
+		return res, err
+	}
+
+	// The output is streamed to w as it is produced rather than once it is
+	// complete, keeping only the copy the cache needs, which is taken to be
+	// about the size of the original.
+	err = m.reserve(m.size)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	res, err := normalize(r, io.MultiWriter(w, &buffer), opts, m)
+	if err != nil {
+		return nil, err
+	}
+	opts.Cache.Put(key, buffer.Bytes())
+
+	return res, nil
+}
+
+// normalize performs the work of NormalizeWithOptions, without consulting
+// opts.Cache.  Every step is accounted for in m before it is taken.
+func normalize(r io.ReadSeeker, w io.Writer, opts *Options, m *memoryBudget) (*Result, error) {
+	tag, err := getOrientationTag(r, opts)
+	if err == NoExifError && opts.Suggester == nil && opts.Hook == nil && opts.ColorManager == nil {
+		res := &Result{Orientation: 1, NoExif: true}
+		err = m.reserveCopy(opts)
+		if err != nil {
+			return nil, err
+		}
+		err = copyThrough(r, w, res, opts)
+		if err != nil {
+			return nil, err
+		}
+		err = m.reserveImage(res.Orientation, opts)
+		if err != nil {
+			return nil, err
+		}
+		return res, opts.addImageFromSource(r, res)
+	} else if err != nil && err != NoExifError {
+		return nil, err
+	}
+
+	if opts.Mode != ModeReencode && err == nil && opts.Hook == nil && opts.ColorManager == nil {
+		if err := m.reserveLossless(); err != nil {
+			return nil, err
+		}
+
+		res, err := normalizeLossless(r, w, tag, opts)
+		if err == nil {
+			if err := m.reserveImage(res.Orientation, opts); err != nil {
+				return nil, err
+			}
+			return res, opts.addImageFromSource(r, res)
+		}
+		if err != jpegenc.ErrNotTransformable {
+			return nil, err
+		}
+		if opts.Mode == ModePerfect {
+			return nil, NotLosslesslyTransformableError
+		}
+	}
+
+	if err := m.reserveDecode(tag, err == nil, opts); err != nil {
+		return nil, err
+	}
+
+	img, res, tagged, err := decodeTagged(r, tag, err == nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !tagged && opts.Hook == nil {
+		if err := m.reserveCopy(opts); err != nil {
+			return nil, err
+		}
+		return res, copyThrough(r, w, res, opts)
+	}
+	if opts.Mode == ModePerfect {
+		return nil, NotLosslesslyTransformableError
+	}
+
+	segments, err := reencodedSegments(r, opts, res, img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	quant, err := opts.outputQuant(r, res)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.reserveEncode(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, encode(w, img, segments, quant, opts, res)
+}
+
+// reencodedSegments returns the marker segments to carry from r into its
+// re-encoded version with the given bounds under opts, normalized as
+// described by res.
+func reencodedSegments(r io.ReadSeeker, opts *Options, res *Result, b image.Rectangle) ([]jpegenc.Segment, error) {
+	var segments []jpegenc.Segment
+	if opts.PreserveExif {
+		exif, err := opts.reencodedExif(r, res, b)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, exif...)
+	}
+	if opts.PreserveICC && !res.ColorConverted {
+		profile, err := ReadICCProfile(r)
+		if err != nil && err != NoICCProfileError {
+			return nil, err
+		}
+		segments = append(segments, iccSegments(profile)...)
+	}
+	if opts.PreserveIPTC {
+		photoshop, err := readPhotoshopSegments(r)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, photoshop...)
+	}
+	if opts.BackupExif {
+		ra, size, err := readerAt(r)
+		if err != nil {
+			return nil, err
+		}
+		backup, err := opts.backupSegments(ra, size)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, backup...)
+	}
+
+	comments, err := opts.reencodedComments(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(segments, comments...), nil
+}
+
+// getOrientationTag detects the orientation of r, using opts.OrientationCache
+// if one was given, and corrects it for known device quirks.  An orientation
+// read from an XMP sidecar overrides it.
+func getOrientationTag(r io.ReadSeeker, opts *Options) (uint16, error) {
+	if opts.orientation != 0 {
+		return opts.orientation, nil
+	}
+
+	var tag uint16
+	var err error
+	switch {
+	case opts.LenientEndianness:
+		tag, err = GetOrientationTagLenient(r)
+	case opts.OrientationCache == nil:
+		tag, err = GetOrientationTag(r)
+	default:
+		var h Hash
+		h, err = opts.contentHash(r)
+		if err == nil {
+			tag, err = GetOrientationTagByHash(r, h, opts.OrientationCache)
+		}
+	}
+
+	// Images in other formats are treated like images without EXIF data, so
+	// that they are copied through as they always have been, unless the
+	// caller would rather they were rejected.
+	if err == GIFError || err == GIFExifError || err == JXLError || err == JXLExifError {
+		if opts.RejectUnsupported {
+			return 0, UnsupportedFormatError
+		}
+		err = NoExifError
+	}
+
+	return applyQuirks(r, tag, err)
+}
+
+// decodeWithOptions decodes the JPEG image in r and applies its orientation
+// tag, or the orientation suggested by opts.Suggester if it has none.  It
+// reports whether an orientation was found or suggested at all, or the colors
+// converted by opts.ColorManager, that is whether the image differs from the
+// original.  Finally, opts.Hook is run on the image.
+func decodeWithOptions(r io.ReadSeeker, opts *Options) (image.Image, *Result, bool, error) {
+	tag, err := getOrientationTag(r, opts)
+	if err != nil && err != NoExifError {
+		return nil, nil, false, err
+	}
+
+	return decodeTagged(r, tag, err == nil, opts)
+}
+
+// decodeTagged is like decodeWithOptions, for an image whose orientation tag
+// has already been detected.  tagged reports whether the image has one.
+func decodeTagged(r io.ReadSeeker, tag uint16, tagged bool, opts *Options) (image.Image, *Result, bool, error) {
+	res := &Result{Orientation: 1, NoExif: !tagged}
+
+	img, err := decode(r, opts, res)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	res.Subsampling = subsamplingOf(img)
+
+	converted := false
+	if opts.ColorManager != nil {
+		img, converted, err = convertToSRGB(r, img, opts.ColorManager)
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	res.ColorConverted = converted
+
+	applied := tagged
+	if !tagged && opts.Suggester != nil {
+		var suggested uint16
+		var confidence float64
+		err := guard("suggest", func() (err error) {
+			suggested, confidence, err = opts.Suggester.Suggest(img)
+			return err
+		})
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		minConfidence := opts.MinConfidence
+		if minConfidence == 0 {
+			minConfidence = DefaultMinConfidence
+		}
+		if suggested >= 2 && suggested <= 8 && confidence >= minConfidence {
+			tag = suggested
+			applied = true
+			res.Suggested = true
+			res.Confidence = confidence
+		}
+	}
+	if !applied {
+		tag = 1
+	}
+
+	res.Orientation = tag
+	err = guard("transform", func() error {
+		img = transformInPlace(img, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if opts.Hook != nil {
+		// Unreadable EXIF data should not prevent the hook from running, so
+		// it is simply reported as absent.
+		x, err := ReadExif(r)
+		if err != nil {
+			x = nil
+		}
+
+		err = guard("hook", func() (err error) {
+			img, err = opts.Hook(img, x)
+			return err
+		})
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+	opts.addImage(img, res)
+
+	return img, res, applied || converted, nil
+}
+
+// convertToSRGB converts img, decoded from r, to sRGB using cm if r carries an
+// ICC profile for some other color space.  It reports whether a conversion
+// was made.
+func convertToSRGB(r io.ReadSeeker, img image.Image, cm ColorManager) (_ image.Image, _ bool, err error) {
+	defer recoverPanic("color", &err)
+
+	profile, err := ReadICCProfile(r)
+	if err == NoICCProfileError || (err == nil && isSRGBProfile(profile)) {
+		return img, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	img, err = cm.ToSRGB(img, profile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return img, true, nil
+}
+
+// copyThrough rewinds r and copies it to w unchanged, apart from rewriting
+// its comments and GPS data if opts requires it.
+func copyThrough(r io.ReadSeeker, w io.Writer, res *Result, opts *Options) error {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	res.Copied, res.Method = true, MethodCopy
+	if !opts.rewritesCopies() {
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	backup, err := opts.backupSegments(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	exif, err := findExifSegment(bytes.NewReader(data), int64(len(data)))
+	if err != nil && err != NoExifError {
+		return err
+	}
+
+	err = jpegenc.Transform(w, data, jpegenc.Transformation{}, opts.copyKeeper(res, exif), append(opts.extraComments(), backup...))
+	if err == jpegenc.ErrNotTransformable {
+		_, err = w.Write(data)
+	}
+	return err
+}
+
+// encode writes img, normalized as described by res, to w as a JPEG image at
+// the quality and subsampling given by opts, or with the quantization tables
+// quant if not nil, followed by segments after its SOI marker.  The output
+// reaches w through a buffer of opts.WriteBufferSize bytes, unless w is
+// buffered already.
+func encode(w io.Writer, img image.Image, segments []jpegenc.Segment, quant *[2][64]uint16, opts *Options, res *Result) (err error) {
+	defer recoverPanic("encode", &err)
+
+	res.Method = MethodReencode
+
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = opts.writeBuffer(w)
+		defer opts.releaseWriteBuffer(bw)
+	}
+
+	if opts.MaxBytes > 0 {
+		var data []byte
+		data, err = encodeWithin(img, segments, quant, opts, res)
+		if err == nil {
+			_, err = bw.Write(data)
+		}
+	} else {
+		err = encodeJPEG(bw, img, segments, opts.Quality, quant, opts, res)
+	}
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// encodeJPEG writes img, normalized as described by res, to w as a JPEG image
+// at the given quality, or with the quantization tables quant if not nil,
+// followed by segments after its SOI marker.
+func encodeJPEG(w io.Writer, img image.Image, segments []jpegenc.Segment, quality int, quant *[2][64]uint16, opts *Options, res *Result) error {
+	if segments != nil {
+		w = &segmentWriter{w: w, segments: segments}
+	}
+
+	return JPEGEncoder{Quality: quality, Subsampling: opts.outputSubsampling(res), Quant: quant}.Encode(w, img)
+}
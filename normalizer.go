@@ -0,0 +1,149 @@
+package exiflign
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// warmUpExif is the payload of an APP1 segment holding little-endian EXIF
+// data with an orientation of 6, which makes the warm-up image go through
+// decoding, transformation and encoding.
+var warmUpExif = append(append([]byte{}, exifHeader...),
+	'I', 'I', 0x2a, 0, 8, 0, 0, 0,
+	1, 0,
+	0x12, 0x01, 3, 0, 1, 0, 0, 0, 6, 0, 0, 0,
+	0, 0, 0, 0,
+)
+
+// Normalizer normalizes images with a fixed configuration.  It is meant to be
+// created once, for example when a server starts, and shared by every
+// request: it is safe for concurrent use by multiple goroutines, and it
+// reuses the buffers of earlier calls instead of allocating them afresh for
+// each image.  The caches, decoders and color manager it is created with are
+// shared by all calls, so they must be safe for concurrent use themselves, as
+// those provided by this package are.
+type Normalizer struct {
+	opts    Options
+	buffers sync.Pool
+}
+
+// NewNormalizer returns a Normalizer that normalizes images like
+// NormalizeWithOptions with opts.  opts is copied, so changing it afterwards
+// has no effect on the Normalizer.  Invalid options are reported here rather
+// than on every call.
+func NewNormalizer(opts *Options) (*Normalizer, error) {
+	n := &Normalizer{}
+	if opts != nil {
+		n.opts = *opts
+		n.opts.Geofences = slices.Clone(opts.Geofences)
+		n.opts.Decoders = slices.Clone(opts.Decoders)
+	}
+	if n.opts.ExifVersion != "" && !validExifVersion(n.opts.ExifVersion) {
+		return nil, InvalidExifVersionError
+	}
+
+	size := n.opts.WriteBufferSize
+	n.buffers.New = func() any {
+		return bufio.NewWriterSize(nil, size)
+	}
+	n.opts.buffers = &n.buffers
+
+	return n, nil
+}
+
+// Options returns a copy of the options n was created with.
+func (n *Normalizer) Options() Options {
+	opts := n.opts
+	opts.Geofences = slices.Clone(n.opts.Geofences)
+	opts.Decoders = slices.Clone(n.opts.Decoders)
+	opts.buffers = nil
+
+	return opts
+}
+
+// Normalize is like NormalizeWithOptions with the options of n.
+func (n *Normalizer) Normalize(r io.ReadSeeker, w io.Writer) (*Result, error) {
+	return NormalizeWithOptions(r, w, &n.opts)
+}
+
+// NewReader is like NewReaderWithOptions with the options of n.
+func (n *Normalizer) NewReader(r io.ReadSeeker) io.ReadCloser {
+	return NewReaderWithOptions(r, &n.opts)
+}
+
+// Middleware is like the package-level Middleware with the options of n.
+func (n *Normalizer) Middleware(h http.Handler) http.Handler {
+	return Middleware(h, &n.opts)
+}
+
+// ModifyResponse is like the package-level ModifyResponse with the options of
+// n.
+func (n *Normalizer) ModifyResponse() func(*http.Response) error {
+	return ModifyResponse(&n.opts)
+}
+
+// FileServer is like the package-level FileServer with the options of n.
+// Normalized files are kept in cache between requests, if it is non-nil.
+func (n *Normalizer) FileServer(fsys fs.FS, cache ResultCache) http.Handler {
+	return FileServer(fsys, &FileServerOptions{Options: n.opts, ResponseCache: cache})
+}
+
+// WarmUp prepares n for its first images by decoding a tiny test image with
+// each of its Decoders and normalizing it with its options, so that codec
+// backends which initialize lazily, such as cgo bindings to native
+// libraries, do so before real traffic arrives.  An error means that n cannot
+// normalize images, and a server using it should not report itself ready.
+// Nothing is stored in the caches of n or recorded in its audit sink.
+func (n *Normalizer) WarmUp() error {
+	var src bytes.Buffer
+	w := &segmentWriter{w: &src, segments: []jpegenc.Segment{{Marker: markerAPP1, Payload: warmUpExif}}}
+	err := jpeg.Encode(w, image.NewRGBA(image.Rect(0, 0, 16, 8)), nil)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range n.opts.Decoders {
+		_, err = d.Decode(bytes.NewReader(src.Bytes()))
+		if err != nil {
+			return err
+		}
+	}
+
+	opts := n.opts
+	opts.Cache, opts.OrientationCache, opts.Audit = nil, nil, nil
+	_, err = NormalizeWithOptions(bytes.NewReader(src.Bytes()), io.Discard, &opts)
+	return err
+}
+
+// writeBuffer returns a buffer of o.WriteBufferSize bytes that writes to w,
+// taken from the pool of the Normalizer o belongs to if there is one.  It
+// must be handed back with releaseWriteBuffer once it has been flushed.
+func (o *Options) writeBuffer(w io.Writer) *bufio.Writer {
+	if o.buffers == nil {
+		return bufio.NewWriterSize(w, o.WriteBufferSize)
+	}
+
+	bw := o.buffers.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+// releaseWriteBuffer hands bw, returned by writeBuffer, back to the pool it
+// was taken from.
+func (o *Options) releaseWriteBuffer(bw *bufio.Writer) {
+	if o.buffers == nil {
+		return
+	}
+
+	bw.Reset(nil)
+	o.buffers.Put(bw)
+}
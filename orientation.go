@@ -0,0 +1,199 @@
+package exiflign
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tagOps describes each orientation tag as a clockwise rotation, in quarter
+// turns, followed by an optional horizontal flip.  This is the decomposition
+// used by TransformForTag.  Index 0 is unused.
+var tagOps = [9]struct {
+	rotate int
+	flip   bool
+}{
+	{0, false},
+	{0, false},
+	{0, true},
+	{2, false},
+	{2, true},
+	{1, true},
+	{1, false},
+	{3, true},
+	{3, false},
+}
+
+// tagFor returns the orientation tag whose transformation is a clockwise
+// rotation by rotate quarter turns followed by an optional horizontal flip.
+func tagFor(rotate int, flip bool) uint16 {
+	rotate = ((rotate % 4) + 4) % 4
+	for tag := uint16(1); tag <= 8; tag++ {
+		if tagOps[tag].rotate == rotate && tagOps[tag].flip == flip {
+			return tag
+		}
+	}
+
+	return 1
+}
+
+// inverseTag returns the orientation tag whose transformation undoes that of
+// tag.  A flip following a rotation undoes itself, since flipping reverses
+// the direction of the rotation.
+func inverseTag(tag uint16) uint16 {
+	op := tagOps[tag]
+	if op.flip {
+		return tag
+	}
+
+	return tagFor(-op.rotate, false)
+}
+
+// rotateTag returns the orientation tag whose transformation is that of tag
+// followed by a further clockwise rotation of rotate quarter turns.
+func rotateTag(tag uint16, rotate int) uint16 {
+	op := tagOps[tag]
+
+	// A rotation applied after a flip is equivalent to the opposite rotation
+	// applied before it.
+	if op.flip {
+		return tagFor(op.rotate-rotate, true)
+	}
+
+	return tagFor(op.rotate+rotate, false)
+}
+
+// Transform describes the operations that correct an orientation tag, for
+// systems such as CSS or ImageMagick command builders that apply them
+// themselves: a clockwise rotation by Rotate degrees, followed by a
+// horizontal flip if FlipH is set.
+type Transform struct {
+	// Rotate is 0, 90, 180 or 270.
+	Rotate int  `json:"rotate"`
+	FlipH  bool `json:"flip_h"`
+}
+
+// TransformFor returns the Transform that corrects tag, which is the same
+// transformation TransformForTag performs.  Tags outside of the range 1 to 8
+// yield the identity.
+func TransformFor(tag uint16) Transform {
+	if tag < 1 || tag > 8 {
+		tag = 1
+	}
+
+	return Transform{Rotate: 90 * tagOps[tag].rotate, FlipH: tagOps[tag].flip}
+}
+
+// Tag returns the orientation tag that t corrects, or 0 if Rotate is not a
+// multiple of 90.
+func (t Transform) Tag() uint16 {
+	if t.Rotate%90 != 0 {
+		return 0
+	}
+
+	return tagFor(t.Rotate/90, t.FlipH)
+}
+
+// SwapsDimensions reports whether t exchanges the width and height of an
+// image.
+func (t Transform) SwapsDimensions() bool {
+	return t.Rotate%180 != 0
+}
+
+// String describes t, for example "rotate 90, flip horizontal".
+func (t Transform) String() string {
+	s := fmt.Sprintf("rotate %d", t.Rotate)
+	if t.FlipH {
+		s += ", flip horizontal"
+	}
+
+	return s
+}
+
+// The orientation tags, named after the position of the first row and column
+// of the stored image as seen upright, as the TIFF specification names them.
+// These are the numeric values exiftool reports with -n, and that EXIF
+// libraries such as goexif return for the Orientation tag.
+const (
+	OrientationTopLeft     uint16 = 1
+	OrientationTopRight    uint16 = 2
+	OrientationBottomRight uint16 = 3
+	OrientationBottomLeft  uint16 = 4
+	OrientationLeftTop     uint16 = 5
+	OrientationRightTop    uint16 = 6
+	OrientationRightBottom uint16 = 7
+	OrientationLeftBottom  uint16 = 8
+)
+
+// exiftoolNames gives the name exiftool prints for each orientation tag.
+// Index 0 is unused.
+var exiftoolNames = [9]string{
+	"",
+	"Horizontal (normal)",
+	"Mirror horizontal",
+	"Rotate 180",
+	"Mirror vertical",
+	"Mirror horizontal and rotate 270 CW",
+	"Rotate 90 CW",
+	"Mirror horizontal and rotate 90 CW",
+	"Rotate 270 CW",
+}
+
+// ExiftoolName returns the name exiftool prints for tag, such as "Rotate 90
+// CW" for 6, or "" for tags outside of the range 1 to 8.
+func ExiftoolName(tag uint16) string {
+	if tag < 1 || tag > 8 {
+		return ""
+	}
+
+	return exiftoolNames[tag]
+}
+
+// ParseOrientation parses an orientation tag as written by exiftool, either
+// numerically, as with -n, or by the name it prints otherwise, and reports
+// whether s is either.
+func ParseOrientation(s string) (uint16, bool) {
+	s = strings.TrimSpace(s)
+	for tag := uint16(1); tag <= 8; tag++ {
+		if s == strconv.Itoa(int(tag)) || strings.EqualFold(s, exiftoolNames[tag]) {
+			return tag, true
+		}
+	}
+
+	return 0, false
+}
+
+// CSS returns the value of the CSS transform property that applies t to an
+// element, such as "scaleX(-1) rotate(90deg)", or "none" for the identity.
+// Elements showing an image as stored, with image-orientation: none, are
+// displayed upright with the transform of the image's tag.  Quarter turns
+// exchange the width and height of the element as displayed, which the
+// layout around it does not account for.
+func (t Transform) CSS() string {
+	var parts []string
+	if t.FlipH {
+		parts = append(parts, "scaleX(-1)")
+	}
+	if t.Rotate != 0 {
+		parts = append(parts, fmt.Sprintf("rotate(%ddeg)", t.Rotate))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+
+	// CSS applies the rightmost function first.
+	return strings.Join(parts, " ")
+}
+
+// CSSImageOrientation returns the value of the CSS image-orientation property
+// in the form of CSS Images Level 3, such as "90deg flip", that displays
+// upright an image whose transform is t.  Current browsers only implement the
+// from-image and none values, so CSS is the portable choice.
+func (t Transform) CSSImageOrientation() string {
+	s := fmt.Sprintf("%ddeg", t.Rotate)
+	if t.FlipH {
+		s += " flip"
+	}
+
+	return s
+}
@@ -0,0 +1,59 @@
+package exiflign
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// PanicError is returned in place of a panic raised while normalizing an
+// image, by this package or by the decoders, color managers, suggesters and
+// hooks it calls, so that a single bad image cannot take down a whole batch.
+type PanicError struct {
+	// Stage is the stage of normalization that panicked: "decode", "color",
+	// "suggest", "transform", "hook", "lossless", "encode", or "normalize"
+	// for any other.
+	Stage string
+
+	// Path is the path of the image, when normalized by NormalizeFile or
+	// NormalizeDir, and Hash the Hash of its content, when it could be read.
+	Path string
+	Hash Hash
+
+	// Value is the value the panic was raised with, and Stack the stack
+	// trace of the goroutine that raised it.
+	Value any
+	Stack []byte
+}
+
+// Error implements error.
+func (e *PanicError) Error() string {
+	input := e.Path
+	if input == "" {
+		input = "image " + e.Hash.String()
+	}
+
+	return fmt.Sprintf("The %s stage panicked on %s: %v", e.Stage, input, e.Value)
+}
+
+// recoverPanic, deferred by a function whose error result is *err, converts a
+// panic in that function into a *PanicError for stage.
+func recoverPanic(stage string, err *error) {
+	if v := recover(); v != nil {
+		*err = &PanicError{Stage: stage, Value: v, Stack: debug.Stack()}
+	}
+}
+
+// guard calls fn, converting a panic in it into a *PanicError for stage.
+func guard(stage string, fn func() error) (err error) {
+	defer recoverPanic(stage, &err)
+	return fn()
+}
+
+// identifyPanic records the Hash of the content of r in err if it is a
+// *PanicError.
+func identifyPanic(r io.ReadSeeker, err error) {
+	if pe, ok := err.(*PanicError); ok {
+		pe.Hash, _ = HashOf(r)
+	}
+}
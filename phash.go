@@ -0,0 +1,126 @@
+package exiflign
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// PerceptualHash is a 64-bit fingerprint of the appearance of an image.
+// Similar images have hashes that differ in few bits.
+type PerceptualHash uint64
+
+// Distance returns the number of bits that differ between h and other.
+// Distances up to around 10 usually indicate the same picture.
+func (h PerceptualHash) Distance(other PerceptualHash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// String returns h as 16 hexadecimal digits.
+func (h PerceptualHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// DHash computes the difference hash of img, comparing the brightness of
+// horizontally adjacent cells of a 9x8 grid.  It is cheap to compute and
+// robust to scaling and re-encoding.
+func DHash(img image.Image) PerceptualHash {
+	grid := grayGrid(img, 9, 8)
+
+	var h PerceptualHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if grid[y*9+x] < grid[y*9+x+1] {
+				h |= 1
+			}
+		}
+	}
+
+	return h
+}
+
+// PHash computes the DCT-based perceptual hash of img, comparing the lowest
+// 8x8 frequencies of a 32x32 grid against their median.  It is more robust
+// than DHash to gamma and contrast changes.
+func PHash(img image.Image) PerceptualHash {
+	const size = 32
+	grid := grayGrid(img, size, size)
+
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				cy := math.Cos(float64(2*y+1) * float64(v) * math.Pi / (2 * size))
+				for x := 0; x < size; x++ {
+					sum += grid[y*size+x] * cy * math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size))
+				}
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// The DC coefficient only reflects overall brightness, so it is left out
+	// of the median.
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h PerceptualHash
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+
+	return h
+}
+
+// grayGrid scales img to w by h pixels, ignoring its aspect ratio, and
+// returns their luminance in row-major order.
+func grayGrid(img image.Image, w, h int) []float64 {
+	small := imaging.Resize(img, w, h, imaging.Box)
+
+	grid := make([]float64, w*h)
+	for i := range grid {
+		p := small.Pix[4*i:]
+		grid[i] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+	}
+
+	return grid
+}
+
+// addImage records the perceptual hashes of img in res, and img itself, if o
+// asks for them.
+func (o *Options) addImage(img image.Image, res *Result) {
+	if o.PerceptualHashes {
+		res.DHash, res.PHash = DHash(img), PHash(img)
+	}
+	if o.KeepImage {
+		res.image = img
+	}
+}
+
+// addImageFromSource decodes r, orients it as described by res and records
+// what addImage does, for images that were not otherwise decoded.
+func (o *Options) addImageFromSource(r io.ReadSeeker, res *Result) error {
+	if !o.PerceptualHashes && !o.KeepImage {
+		return nil
+	}
+
+	img, err := decode(r, o, res)
+	if err != nil {
+		return err
+	}
+	o.addImage(TransformForTag(img, res.Orientation), res)
+
+	_, err = r.Seek(0, io.SeekStart)
+	return err
+}
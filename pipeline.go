@@ -0,0 +1,185 @@
+package exiflign
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+
+	"github.com/disintegration/imaging"
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// Encoder writes an image to w in some image format.  JPEGEncoder and
+// PNGEncoder are provided, other formats such as WebP can be supported by
+// wrapping a third-party encoder.
+type Encoder interface {
+	Encode(w io.Writer, img image.Image) error
+}
+
+// JPEGEncoder is an Encoder producing JPEG images with image/jpeg, or with
+// an encoder of this package when a Subsampling or Quant is requested.
+type JPEGEncoder struct {
+	// Quality is the JPEG quality, between 1 and 100 inclusive.  If zero,
+	// jpeg.DefaultQuality is used.
+	Quality int
+
+	// Subsampling is the chroma subsampling of color images.  If
+	// SubsamplingDefault, image/jpeg is used and produces 4:2:0.
+	Subsampling Subsampling
+
+	// Quant, if not nil, gives the luminance and chrominance quantization
+	// tables to use, in natural order, instead of the standard tables scaled
+	// to Quality.  Color images are then encoded with 4:2:0 subsampling
+	// unless Subsampling says otherwise.
+	Quant *[2][64]uint16
+}
+
+// Encode implements Encoder.
+func (e JPEGEncoder) Encode(w io.Writer, img image.Image) error {
+	quality := e.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	if e.Subsampling == SubsamplingDefault && e.Quant == nil {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+
+	return jpegenc.Encode(w, img, &jpegenc.Options{Quality: quality, Sampling: e.Subsampling.sampling(), Quant: e.Quant})
+}
+
+// PNGEncoder is an Encoder producing PNG images with image/png.
+type PNGEncoder struct {
+	CompressionLevel png.CompressionLevel
+}
+
+// Encode implements Encoder.
+func (e PNGEncoder) Encode(w io.Writer, img image.Image) error {
+	enc := png.Encoder{CompressionLevel: e.CompressionLevel}
+	return enc.Encode(w, img)
+}
+
+// Output is a single result requested from a Pipeline.
+type Output struct {
+	// W receives the encoded image.
+	W io.Writer
+
+	// MaxDim, if positive, is the largest the width and height of the output
+	// may be.  Larger images are downscaled preserving their aspect ratio.
+	// If zero, the image is output at full size.
+	MaxDim int
+
+	// Encoder encodes the output.  If nil, the image is encoded as a JPEG at
+	// the pipeline's Options.Quality.
+	Encoder Encoder
+}
+
+// Pipeline decodes and orientation-corrects an image once, then writes it to
+// any number of outputs in different sizes and formats, for example a
+// full-size JPEG alongside several thumbnails and a WebP rendition.
+type Pipeline struct {
+	// Options controls how the image is oriented and encoded.  Options.Cache
+	// is not consulted.
+	Options
+
+	// Filter is the resampling filter used to downscale outputs.  If unset,
+	// imaging.Lanczos is used.
+	Filter *imaging.ResampleFilter
+
+	// Steps are applied in order to the orientation-corrected image, before
+	// any output is produced.
+	Steps []Step
+
+	// Outputs lists the results to produce.
+	Outputs []Output
+}
+
+// Run decodes the JPEG image in r, corrects its orientation, applies the steps
+// of p, and writes the result to every output of p.  Outputs are produced from
+// largest to smallest, each downscaled from the previous one rather than from
+// the full-size image.
+// Unlike Normalize, images without orientation information are always
+// re-encoded, since every output needs encoding anyway.  When finished, the
+// internal position in r will be at io.SeekStart.
+func (p *Pipeline) Run(r io.ReadSeeker) (*Result, error) {
+	for _, o := range p.Outputs {
+		if o.MaxDim < 0 {
+			return nil, InvalidSizeError
+		}
+	}
+
+	img, res, _, err := decodeWithOptions(r, &p.Options)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range p.Steps {
+		img, err = s.Apply(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filter := imaging.Lanczos
+	if p.Filter != nil {
+		filter = *p.Filter
+	}
+
+	order := make([]int, len(p.Outputs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return outputSize(p.Outputs[order[i]]) > outputSize(p.Outputs[order[j]])
+	})
+
+	for _, i := range order {
+		o := p.Outputs[i]
+		if o.MaxDim > 0 {
+			img = fit(img, o.MaxDim, filter)
+		}
+
+		enc := o.Encoder
+		if enc == nil {
+			enc = JPEGEncoder{Quality: p.Quality, Subsampling: p.outputSubsampling(res)}
+		}
+		err = enc.Encode(o.W, img)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// outputSize orders outputs for Pipeline.Run, with full-size outputs first.
+func outputSize(o Output) int {
+	if o.MaxDim == 0 {
+		return int(^uint(0) >> 1)
+	}
+
+	return o.MaxDim
+}
+
+// fit downscales img so that neither dimension exceeds maxDim, preserving its
+// aspect ratio.  Images that already fit are returned unchanged, and
+// grayscale images stay grayscale.
+func fit(img image.Image, maxDim int, filter imaging.ResampleFilter) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= maxDim && b.Dy() <= maxDim {
+		return img
+	}
+
+	resized := imaging.Fit(img, maxDim, maxDim, filter)
+	if _, ok := img.(*image.Gray); ok {
+		return toGray(resized)
+	}
+
+	return resized
+}
@@ -0,0 +1,63 @@
+package exiflign
+
+import (
+	"io"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// outputQuant returns the quantization tables that the image in r,
+// normalized with o as described by res, should be encoded with, or nil if
+// the standard tables scaled to o.Quality are to be used.
+func (o *Options) outputQuant(r io.ReadSeeker, res *Result) (*[2][64]uint16, error) {
+	if !o.PreserveQuantization || res.ColorConverted {
+		return nil, nil
+	}
+
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return readQuantAt(ra, size, res.Orientation >= 5 && res.Orientation <= 8)
+}
+
+// readQuantAt returns the quantization tables of the first frame of the JPEG
+// image of the given size in r, transposed if transpose is set, or nil if
+// they cannot be read.
+func readQuantAt(r io.ReaderAt, size int64, transpose bool) (*[2][64]uint16, error) {
+	var dqt [][]byte
+	var sof []byte
+	var serr error
+	err := walkSegments(r, size, func(s segment) bool {
+		if s.marker != markerDQT && !isSOF(s.marker) {
+			return true
+		}
+
+		payload := make([]byte, s.length)
+		_, serr = r.ReadAt(payload, s.offset)
+		if serr != nil {
+			return false
+		}
+		if s.marker == markerDQT {
+			dqt = append(dqt, payload)
+			return true
+		}
+
+		sof = payload
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+
+	quant, ok := jpegenc.ParseQuant(dqt, sof, transpose)
+	if !ok {
+		return nil, nil
+	}
+
+	return quant, nil
+}
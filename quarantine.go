@@ -0,0 +1,98 @@
+package exiflign
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var QuarantinedError error = errors.New("The given file was quarantined as suspicious.")
+
+// DefaultMaxPixels is the number of pixels above which BasicQuarantinePolicy
+// considers an image suspicious, unless told otherwise.  No camera produces
+// images of more than a few hundred megapixels, whereas decompression bombs
+// declare billions.
+const DefaultMaxPixels = 500_000_000
+
+// QuarantinePolicy classifies originals before they are normalized, so that
+// suspicious files can be set aside for inspection rather than processed.
+// Implementations must be safe for concurrent use.
+type QuarantinePolicy interface {
+	// Classify returns why the JPEG image in r is suspicious, or an empty
+	// string if it is not.  An error means that r could not be read.  The
+	// position in r need not be restored.
+	Classify(r io.ReadSeeker) (string, error)
+}
+
+// BasicQuarantinePolicy is a QuarantinePolicy flagging files whose JPEG
+// headers cannot be read, whose EXIF data is present but invalid or carries
+// an orientation outside of 1 to 8, and whose dimensions are zero or absurdly
+// large.  Only the headers are read.
+type BasicQuarantinePolicy struct {
+	// MaxPixels is the largest number of pixels an image may have.  If
+	// zero, DefaultMaxPixels is used.
+	MaxPixels int64
+}
+
+// Classify implements QuarantinePolicy.
+func (p BasicQuarantinePolicy) Classify(r io.ReadSeeker) (string, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := GetInfoAt(ra, size)
+	if err != nil {
+		return fmt.Sprintf("unreadable headers: %v", err), nil
+	}
+
+	maxPixels := p.MaxPixels
+	if maxPixels == 0 {
+		maxPixels = DefaultMaxPixels
+	}
+	pixels := int64(info.Width) * int64(info.Height)
+	if pixels == 0 || pixels > maxPixels {
+		return fmt.Sprintf("absurd dimensions %dx%d", info.Width, info.Height), nil
+	}
+
+	payload, err := findExifSegment(ra, size)
+	if err == NoExifError {
+		return "", nil
+	} else if err != nil {
+		return fmt.Sprintf("unreadable EXIF data: %v", err), nil
+	}
+	x, err := parseExif(payload[len(exifHeader):])
+	if err != nil {
+		return fmt.Sprintf("invalid EXIF data: %v", err), nil
+	}
+	if x.hasOrientation && (x.Orientation < 1 || x.Orientation > 8) {
+		return fmt.Sprintf("invalid orientation %d", x.Orientation), nil
+	}
+
+	return "", nil
+}
+
+// quarantine classifies r under o.QuarantinePolicy, passing it to
+// o.Quarantine if it is suspicious.  It returns QuarantinedError for a
+// suspicious original, after which it must not be normalized.  When
+// finished, the internal position in r will be at io.SeekStart.
+func (o *Options) quarantine(r io.ReadSeeker) error {
+	reason, err := o.QuarantinePolicy.Classify(r)
+	if err != nil {
+		return err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil || reason == "" {
+		return err
+	}
+
+	if o.Quarantine != nil {
+		err = o.Quarantine(r, reason)
+		r.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	}
+
+	return QuarantinedError
+}
@@ -0,0 +1,141 @@
+package exiflign
+
+import (
+	"context"
+	"sync"
+)
+
+// Job is a request to normalize a single image, as received from a Queue.
+type Job struct {
+	// ID identifies the job in its JobResult.
+	ID string `json:"id,omitempty"`
+
+	// Input is the name of the original in the source Storage of the
+	// worker, and Output the name the normalized image is written to in its
+	// destination Storage.  If Output is empty, Input is used.
+	Input  string `json:"input"`
+	Output string `json:"output,omitempty"`
+}
+
+// JobResult is the outcome of a Job, as published back to a Queue.
+type JobResult struct {
+	ID     string `json:"id,omitempty"`
+	Input  string `json:"input"`
+	Output string `json:"output"`
+
+	// Orientation, Suggested, NoExif, Lossless and Copied are as in Result.
+	Orientation uint16 `json:"orientation,omitempty"`
+	Suggested   bool   `json:"suggested,omitempty"`
+	NoExif      bool   `json:"no_exif,omitempty"`
+	Lossless    bool   `json:"lossless,omitempty"`
+	Copied      bool   `json:"copied,omitempty"`
+
+	// Error describes why the job failed, or is empty if it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// QueuedJob is a Job received from a Queue, along with the means to
+// acknowledge it.
+type QueuedJob struct {
+	Job
+
+	// Ack acknowledges the job once its result has been published, so that
+	// the queue does not deliver it again.  It may be nil for queues that do
+	// not redeliver jobs.
+	Ack func() error
+}
+
+// Queue is a source of jobs and a sink for their results, such as a subject
+// of a NATS server or a queue of an AMQP broker, allowing normalization to be
+// scaled across any number of workers running RunWorker.  Implementations
+// must be safe for concurrent use.
+type Queue interface {
+	// Receive blocks until a job is available or ctx is done.
+	Receive(ctx context.Context) (*QueuedJob, error)
+
+	// Publish sends the result of a job.
+	Publish(ctx context.Context, res *JobResult) error
+}
+
+// RunWorker normalizes the jobs received from q, reading originals from src
+// and writing outputs to dst, up to concurrency at a time, and publishes the
+// result of each back to q before acknowledging it.  Jobs that fail are
+// acknowledged as well, with the error in their result, so that a broken
+// image is not redelivered forever.  RunWorker returns once ctx is done and
+// the jobs in progress have finished, or as soon as receiving, publishing or
+// acknowledging fails.
+func RunWorker(ctx context.Context, q Queue, src, dst Storage, opts *Options, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failure error
+	fail := func(err error) {
+		once.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+
+	slots := make(chan struct{}, concurrency)
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		job, err := q.Receive(ctx)
+		if err != nil {
+			<-slots
+			if ctx.Err() == nil {
+				fail(err)
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			err := runJob(ctx, q, src, dst, job, opts)
+			if err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return failure
+}
+
+// runJob normalizes job and publishes and acknowledges its result.
+func runJob(ctx context.Context, q Queue, src, dst Storage, job *QueuedJob, opts *Options) error {
+	out := job.Output
+	if out == "" {
+		out = job.Input
+	}
+
+	jr := &JobResult{ID: job.ID, Input: job.Input, Output: out}
+	res, err := normalizeObject(ctx, src, dst, job.Input, out, opts)
+	if err != nil {
+		jr.Error = err.Error()
+	} else {
+		jr.Orientation, jr.Suggested, jr.NoExif = res.Orientation, res.Suggested, res.NoExif
+		jr.Lossless, jr.Copied = res.Lossless, res.Copied
+	}
+
+	err = q.Publish(ctx, jr)
+	if err != nil || job.Ack == nil {
+		return err
+	}
+
+	return job.Ack()
+}
@@ -0,0 +1,147 @@
+package exiflign
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// Quirk describes how the EXIF orientation written by a particular device or
+// program deviates from the standard, so that it can be interpreted
+// correctly.
+type Quirk struct {
+	// Make, Model and Software select the images the quirk applies to.  Each
+	// is matched, ignoring case, as a prefix of the corresponding EXIF field.
+	// Empty fields match any value, but at least one must be set.
+	Make     string
+	Model    string
+	Software string
+
+	// SwappedBytes is set for devices that write the orientation value in the
+	// opposite byte order to the rest of their EXIF data, so that for example
+	// 6 is stored as 0x0600.
+	SwappedBytes bool
+
+	// Remap, if non-nil, maps the orientation values written by the device to
+	// the values they actually describe, for devices that confuse for example
+	// 6 and 8.  Values not in Remap are kept.
+	Remap map[uint16]uint16
+
+	// ExifIFD is set for devices that write the orientation tag into the Exif
+	// sub-IFD instead of IFD0.  It is used when IFD0 has no orientation.
+	ExifIFD bool
+
+	// Ignore is set for devices whose orientation tag is known to be
+	// meaningless, for example because it is always written as 1 or
+	// describes the sensor rather than the scene.  Their images are treated
+	// as having no orientation tag.
+	Ignore bool
+}
+
+// matches reports whether q applies to an image with EXIF data x.
+func (q *Quirk) matches(x *Exif) bool {
+	if q.Make == "" && q.Model == "" && q.Software == "" {
+		return false
+	}
+
+	return hasPrefixFold(x.Make, q.Make) && hasPrefixFold(x.Model, q.Model) && hasPrefixFold(x.Software, q.Software)
+}
+
+// hasPrefixFold is strings.HasPrefix ignoring case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// apply returns the orientation tag that q derives from x.  It returns false
+// if the image should be treated as having no orientation tag.
+func (q *Quirk) apply(x *Exif) (uint16, bool) {
+	if q.Ignore {
+		return 0, false
+	}
+
+	tag, ok := x.Orientation, x.hasOrientation
+	if !ok && q.ExifIFD {
+		tag, ok = x.exifIFDOrientation, x.hasExifIFDOrientation
+	}
+	if !ok {
+		return 0, false
+	}
+
+	if q.SwappedBytes {
+		tag = tag>>8 | tag<<8
+	}
+	if mapped, ok := q.Remap[tag]; ok {
+		tag = mapped
+	}
+	if tag < 1 || tag > 8 {
+		tag = 1
+	}
+
+	return tag, true
+}
+
+var quirksMu sync.RWMutex
+
+// quirks is the quirk table, searched in order.  Quirks registered at runtime
+// are placed in front of the built-in entries, so that they take precedence.
+// No built-in entries are shipped yet; devices should only be added here once
+// their behaviour has been confirmed with sample files.
+var quirks []Quirk
+
+// RegisterQuirk adds q to the quirk table consulted by NormalizeWithOptions,
+// ahead of any quirks already registered.  It is safe for concurrent use.
+func RegisterQuirk(q Quirk) {
+	quirksMu.Lock()
+	defer quirksMu.Unlock()
+
+	quirks = append([]Quirk{q}, quirks...)
+}
+
+// LookupQuirk returns the first quirk in the table that applies to an image
+// with EXIF data x, or nil if there is none.
+func LookupQuirk(x *Exif) *Quirk {
+	quirksMu.RLock()
+	defer quirksMu.RUnlock()
+
+	for i := range quirks {
+		if quirks[i].matches(x) {
+			q := quirks[i]
+			return &q
+		}
+	}
+
+	return nil
+}
+
+// hasQuirks reports whether the quirk table has any entries.
+func hasQuirks() bool {
+	quirksMu.RLock()
+	defer quirksMu.RUnlock()
+
+	return len(quirks) > 0
+}
+
+// applyQuirks adjusts the orientation tag detected in r, and the error from
+// detecting it, according to the quirk table.  When finished, the internal
+// position in r will be at io.SeekStart.
+func applyQuirks(r io.ReadSeeker, tag uint16, err error) (uint16, error) {
+	if !hasQuirks() || (err != nil && err != NoExifError) {
+		return tag, err
+	}
+
+	x, xerr := ReadExif(r)
+	if xerr != nil {
+		return tag, err
+	}
+	q := LookupQuirk(x)
+	if q == nil {
+		return tag, err
+	}
+
+	tag, ok := q.apply(x)
+	if !ok {
+		return 0, NoExifError
+	}
+
+	return tag, nil
+}
@@ -0,0 +1,324 @@
+package exiflign
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"os"
+)
+
+var InvalidRawImageError error = errors.New("The given file is not a supported BMP or PNM image.")
+
+// NormalizeRaw decodes the image in r, in a format that carries no
+// orientation of its own, rotates it as the orientation tag says and writes
+// it to w as a JPEG image encoded with opts, which may be nil.  This lets
+// digitization pipelines feed the output of scanners into the same
+// normalized form as photographs, with the orientation known from elsewhere.
+// Uncompressed BMP images of 1, 2, 4, 8, 24 or 32 bits per pixel, and binary
+// PGM and PPM images (P5 and P6) are supported, others are rejected with
+// InvalidRawImageError.  Images with only gray colors are encoded as
+// grayscale JPEG images.  A tag of 0 or 1 leaves the image as it is.  Only
+// the Quality, Hook, Comments and Comment fields of opts are used, and Hook
+// is called with a nil *Exif.
+func NormalizeRaw(r io.Reader, w io.Writer, tag uint16, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	br := bufio.NewReader(r)
+	signature, _ := br.Peek(2)
+	var img image.Image
+	var err error
+	switch {
+	case bytes.Equal(signature, []byte("BM")):
+		img, err = decodeBMP(br)
+	case bytes.Equal(signature, []byte("P5")) || bytes.Equal(signature, []byte("P6")):
+		img, err = decodePNM(br)
+	default:
+		err = InvalidRawImageError
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{Orientation: 1, NoExif: tag == 0}
+	if tag >= 2 && tag <= 8 {
+		img = transformInPlace(img, tag)
+		res.Orientation = tag
+	}
+	if opts.Hook != nil {
+		img, err = opts.Hook(img, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, encode(w, img, opts.extraComments(), nil, opts, res)
+}
+
+// NormalizeRawFile is like NormalizeRaw for the file at src, writing the
+// result to dst, which is replaced atomically like NormalizeFile does.  If
+// tag is 0, it is taken from the Tag field of the sidecar of src, as named by
+// SidecarPath, for pipelines that record the orientation of each scan next
+// to it.  Without a sidecar the image is left as it is.
+func NormalizeRawFile(src, dst string, tag uint16, opts *Options) (*Result, error) {
+	if tag == 0 {
+		s, err := ReadSidecar(SidecarPath(src))
+		if err == nil {
+			tag = s.Tag
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	res, err := NormalizeRaw(f, &buffer, tag, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, replaceFile(dst, buffer.Bytes(), info.Mode().Perm())
+}
+
+// rawImage returns an image of the given size, grayscale if gray is set,
+// after checking that the size is sensible.
+func rawImage(width, height int, gray bool) (image.Image, error) {
+	if width <= 0 || height <= 0 || int64(width)*int64(height) > DefaultMaxPixels {
+		return nil, InvalidRawImageError
+	}
+
+	r := image.Rect(0, 0, width, height)
+	if gray {
+		return image.NewGray(r), nil
+	}
+
+	return image.NewNRGBA(r), nil
+}
+
+// decodeBMP decodes an uncompressed BMP image from r.
+func decodeBMP(r io.Reader) (image.Image, error) {
+	var header [54]byte
+	_, err := io.ReadFull(r, header[:18])
+	if err != nil {
+		return nil, InvalidRawImageError
+	}
+	pixelOffset := int64(binary.LittleEndian.Uint32(header[10:]))
+	infoSize := int64(binary.LittleEndian.Uint32(header[14:]))
+	if infoSize < 40 || pixelOffset < 14+infoSize {
+		return nil, InvalidRawImageError
+	}
+	_, err = io.ReadFull(r, header[18:54])
+	if err != nil {
+		return nil, InvalidRawImageError
+	}
+	_, err = io.CopyN(io.Discard, r, infoSize-40)
+	if err != nil {
+		return nil, InvalidRawImageError
+	}
+
+	width := int(int32(binary.LittleEndian.Uint32(header[18:])))
+	height := int(int32(binary.LittleEndian.Uint32(header[22:])))
+	bpp := int(binary.LittleEndian.Uint16(header[28:]))
+	compression := binary.LittleEndian.Uint32(header[30:])
+	colors := int(binary.LittleEndian.Uint32(header[46:]))
+
+	topDown := height < 0
+	if topDown {
+		height = -height
+	}
+	// 32 bit images may declare their masks as bitfields, which are assumed
+	// to be the usual ones.
+	if compression != 0 && !(compression == 3 && bpp == 32) {
+		return nil, InvalidRawImageError
+	}
+
+	var palette [][3]byte
+	read := 14 + infoSize
+	if bpp <= 8 {
+		if bpp != 1 && bpp != 2 && bpp != 4 && bpp != 8 {
+			return nil, InvalidRawImageError
+		}
+		if colors == 0 {
+			colors = 1 << bpp
+		}
+		if colors > 1<<bpp {
+			return nil, InvalidRawImageError
+		}
+		entries := make([]byte, 4*colors)
+		_, err = io.ReadFull(r, entries)
+		if err != nil {
+			return nil, InvalidRawImageError
+		}
+		read += int64(len(entries))
+		for i := 0; i < colors; i++ {
+			palette = append(palette, [3]byte{entries[4*i+2], entries[4*i+1], entries[4*i]})
+		}
+	} else if bpp != 24 && bpp != 32 {
+		return nil, InvalidRawImageError
+	}
+	if pixelOffset < read {
+		return nil, InvalidRawImageError
+	}
+	_, err = io.CopyN(io.Discard, r, pixelOffset-read)
+	if err != nil {
+		return nil, InvalidRawImageError
+	}
+
+	gray := len(palette) > 0
+	for _, c := range palette {
+		gray = gray && c[0] == c[1] && c[1] == c[2]
+	}
+	img, err := rawImage(width, height, gray)
+	if err != nil {
+		return nil, err
+	}
+
+	row := make([]byte, (width*bpp+31)/32*4)
+	for i := 0; i < height; i++ {
+		_, err = io.ReadFull(r, row)
+		if err != nil {
+			return nil, InvalidRawImageError
+		}
+		y := height - 1 - i
+		if topDown {
+			y = i
+		}
+
+		for x := 0; x < width; x++ {
+			var c [3]byte
+			switch bpp {
+			case 24, 32:
+				p := row[x*bpp/8:]
+				c = [3]byte{p[2], p[1], p[0]}
+			default:
+				bit := x * bpp
+				index := int(row[bit/8]>>(8-bpp-bit%8)) & (1<<bpp - 1)
+				if index >= len(palette) {
+					return nil, InvalidRawImageError
+				}
+				c = palette[index]
+			}
+			setRawPixel(img, x, y, c)
+		}
+	}
+
+	return img, nil
+}
+
+// decodePNM decodes a binary PGM or PPM image from r.
+func decodePNM(r *bufio.Reader) (image.Image, error) {
+	var magic [2]byte
+	_, err := io.ReadFull(r, magic[:])
+	if err != nil {
+		return nil, InvalidRawImageError
+	}
+
+	var fields [3]int
+	for i := range fields {
+		fields[i], err = pnmNumber(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	width, height, maxval := fields[0], fields[1], fields[2]
+	if maxval <= 0 || maxval > 65535 {
+		return nil, InvalidRawImageError
+	}
+	// A single whitespace character separates the header from the raster.
+	_, err = r.ReadByte()
+	if err != nil {
+		return nil, InvalidRawImageError
+	}
+
+	channels, size := 3, 1
+	if magic[1] == '5' {
+		channels = 1
+	}
+	if maxval > 255 {
+		size = 2
+	}
+	img, err := rawImage(width, height, channels == 1)
+	if err != nil {
+		return nil, err
+	}
+
+	row := make([]byte, width*channels*size)
+	for y := 0; y < height; y++ {
+		_, err = io.ReadFull(r, row)
+		if err != nil {
+			return nil, InvalidRawImageError
+		}
+
+		for x := 0; x < width; x++ {
+			var c [3]byte
+			for i := 0; i < channels; i++ {
+				p := (x*channels + i) * size
+				v := int(row[p])
+				if size == 2 {
+					v = int(binary.BigEndian.Uint16(row[p:]))
+				}
+				c[i] = byte(min(v, maxval) * 255 / maxval)
+			}
+			if channels == 1 {
+				c[1], c[2] = c[0], c[0]
+			}
+			setRawPixel(img, x, y, c)
+		}
+	}
+
+	return img, nil
+}
+
+// pnmNumber reads a decimal number of a PNM header from r, skipping the
+// whitespace and comments before it.
+func pnmNumber(r *bufio.Reader) (int, error) {
+	var n, digits int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, InvalidRawImageError
+		}
+
+		switch {
+		case b >= '0' && b <= '9':
+			if digits++; digits > 9 {
+				return 0, InvalidRawImageError
+			}
+			n = 10*n + int(b-'0')
+			continue
+		case digits > 0:
+			return n, r.UnreadByte()
+		case b == '#':
+			_, err = r.ReadString('\n')
+			if err != nil {
+				return 0, InvalidRawImageError
+			}
+		case b != ' ' && b != '\t' && b != '\n' && b != '\r' && b != '\v' && b != '\f':
+			return 0, InvalidRawImageError
+		}
+	}
+}
+
+// setRawPixel sets the pixel at (x, y) of img, as made by rawImage, to c.
+func setRawPixel(img image.Image, x, y int, c [3]byte) {
+	switch img := img.(type) {
+	case *image.Gray:
+		img.Pix[y*img.Stride+x] = c[0]
+	case *image.NRGBA:
+		p := img.Pix[y*img.Stride+4*x:]
+		p[0], p[1], p[2], p[3] = c[0], c[1], c[2], 0xff
+	}
+}
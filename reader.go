@@ -0,0 +1,52 @@
+package exiflign
+
+import (
+	"io"
+	"sync"
+)
+
+// normalizingReader is the io.ReadCloser returned by NewReader.
+type normalizingReader struct {
+	r    io.ReadSeeker
+	opts *Options
+
+	once sync.Once
+	pr   *io.PipeReader
+	pw   *io.PipeWriter
+}
+
+// NewReader returns a reader that yields the normalized JPEG image in r, as
+// Normalize would write it.  Nothing is read from r until the first call to
+// Read, after which normalization runs concurrently with consumption of the
+// output, so the result can be streamed straight to an http.ResponseWriter or
+// storage client without being buffered in full.  Errors from normalization
+// are returned by Read.  The returned reader must be closed if it is not read
+// to the end.
+func NewReader(r io.ReadSeeker) io.ReadCloser {
+	return NewReaderWithOptions(r, nil)
+}
+
+// NewReaderWithOptions is like NewReader, but normalizes with
+// NormalizeWithOptions using opts.
+func NewReaderWithOptions(r io.ReadSeeker, opts *Options) io.ReadCloser {
+	pr, pw := io.Pipe()
+	return &normalizingReader{r: r, opts: opts, pr: pr, pw: pw}
+}
+
+// Read implements io.Reader.
+func (n *normalizingReader) Read(p []byte) (int, error) {
+	n.once.Do(func() {
+		go func() {
+			_, err := NormalizeWithOptions(n.r, n.pw, n.opts)
+			n.pw.CloseWithError(err)
+		}()
+	})
+
+	return n.pr.Read(p)
+}
+
+// Close implements io.Closer.  It stops any normalization in progress.
+func (n *normalizingReader) Close() error {
+	n.once.Do(func() {})
+	return n.pr.Close()
+}
@@ -0,0 +1,162 @@
+package exiflign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// remoteBlockSize is the granularity of the ranges requested by
+// GetRemoteInfo.  A single block usually covers every header of an image
+// that has no embedded thumbnail.
+const remoteBlockSize = 16 << 10
+
+var RangeNotSupportedError error = errors.New("The server does not support range requests.")
+
+// GetRemoteInfo determines the orientation and dimensions of the remote JPEG
+// image at url by fetching only its leading bytes with HTTP range requests,
+// rather than downloading the whole image.  If client is nil,
+// http.DefaultClient is used.  RangeNotSupportedError is returned if the
+// server ignores the Range header.
+func GetRemoteInfo(ctx context.Context, client *http.Client, url string) (*Info, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	r := &rangeReader{ctx: ctx, client: client, url: url, blocks: make(map[int64][]byte)}
+
+	// Fetching the first block also tells us the size of the image.
+	_, err := r.block(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetInfoAt(r, r.length())
+}
+
+// rangeReader is an io.ReaderAt over a remote resource that fetches it in
+// remoteBlockSize blocks, keeping every block it has fetched.
+type rangeReader struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+
+	mu     sync.Mutex
+	size   int64
+	blocks map[int64][]byte
+}
+
+// length returns the size of the resource, as learnt from the first block
+// fetched.
+func (r *rangeReader) length() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt.  io.ErrUnexpectedEOF is returned if the
+// server sent a block shorter than the resource says it should be.
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= r.length() {
+			return n, io.EOF
+		}
+
+		index := off / remoteBlockSize
+		b, err := r.block(index)
+		if err != nil {
+			return n, err
+		}
+
+		rel := off - index*remoteBlockSize
+		if rel >= int64(len(b)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		c := copy(p[n:], b[rel:])
+		n += c
+		off += int64(c)
+	}
+
+	return n, nil
+}
+
+// block returns the block with the given index, fetching it if necessary.
+func (r *rangeReader) block(index int64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if b, ok := r.blocks[index]; ok {
+		return b, nil
+	}
+
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	start := index * remoteBlockSize
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+remoteBlockSize-1))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil, RangeNotSupportedError
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("Unexpected status %q while fetching %s.", resp.Status, r.url)
+	}
+
+	header := resp.Header.Get("Content-Range")
+	first, size, err := parseContentRange(header)
+	if err != nil {
+		return nil, err
+	}
+	if first != start {
+		return nil, fmt.Errorf("The Content-Range header %q does not start at the requested offset %d.", header, start)
+	}
+	r.size = size
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, remoteBlockSize))
+	if err != nil {
+		return nil, err
+	}
+	r.blocks[index] = b
+
+	return b, nil
+}
+
+// parseContentRange extracts the first byte position and the complete length
+// from a Content-Range header of the form "bytes 0-1023/4096".
+func parseContentRange(header string) (int64, int64, error) {
+	invalid := fmt.Errorf("The Content-Range header %q is invalid.", header)
+	rest, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, invalid
+	}
+	dash := strings.IndexByte(rest, '-')
+	slash := strings.LastIndexByte(rest, '/')
+	if dash < 0 || slash < dash {
+		return 0, 0, invalid
+	}
+
+	start, err := strconv.ParseInt(rest[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, invalid
+	}
+	size, err := strconv.ParseInt(rest[slash+1:], 10, 64)
+	if err != nil {
+		return 0, 0, invalid
+	}
+
+	return start, size, nil
+}
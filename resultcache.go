@@ -0,0 +1,120 @@
+package exiflign
+
+import (
+	"container/list"
+	"sync"
+)
+
+// ResultCache stores normalized images so that they do not have to be
+// normalized again.  Keys are opaque strings chosen by the caller, such as a
+// file path and modification time.  Implementations must be safe for
+// concurrent use.
+type ResultCache interface {
+	// Get returns the data stored under key, and whether it was found.
+	Get(key string) ([]byte, bool)
+
+	// Put stores data under key.  The cache may keep a reference to data, so
+	// the caller must not modify it afterwards.
+	Put(key string, data []byte)
+}
+
+// CacheStats describes the contents and use of a MemoryCache or DiskCache.
+type CacheStats struct {
+	// Hits and Misses count the lookups that found an entry and those that
+	// did not.
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+
+	// Entries and Bytes are the number and total size of the entries held.
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// MemoryCache is an in-memory ResultCache that holds entries up to a total
+// size, evicting the least recently used entries when that size is exceeded.
+// It is safe for concurrent use.
+type MemoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	order    *list.List
+	entries  map[string]*list.Element
+	hits     int64
+	misses   int64
+}
+
+type memoryCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// NewMemoryCache creates a MemoryCache holding at most maxBytes of data.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get implements ResultCache.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+
+	return e.Value.(*memoryCacheEntry).data, true
+}
+
+// Put implements ResultCache.  Entries larger than the cache itself are not
+// stored.
+func (c *MemoryCache) Put(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{key, data})
+	c.bytes += int64(len(data))
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// Stats returns the statistics of c.
+func (c *MemoryCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Bytes: c.bytes}
+}
+
+// Flush removes every entry from c.  Its hit and miss counts are kept.
+func (c *MemoryCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// remove deletes e from the cache.  The caller must hold c.mu.
+func (c *MemoryCache) remove(e *list.Element) {
+	entry := e.Value.(*memoryCacheEntry)
+	c.order.Remove(e)
+	delete(c.entries, entry.key)
+	c.bytes -= int64(len(entry.data))
+}
@@ -0,0 +1,261 @@
+package exiflign
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage is a Storage over a bucket of Amazon S3 or a compatible service,
+// such as MinIO or Cloudflare R2, accessed through its REST API with requests
+// signed by AWS Signature Version 4.  Objects are read into memory when
+// opened and held in memory until closed when created.
+type S3Storage struct {
+	// Endpoint is the base URL of the service, such as
+	// "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000".
+	Endpoint string
+
+	// Region is the region requests are signed for, such as "eu-west-1".
+	// Most other services accept "us-east-1".
+	Region string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// AccessKeyID and SecretAccessKey are the credentials requests are
+	// signed with.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// VirtualHosted causes the bucket to be addressed as a subdomain of the
+	// endpoint, as newer Amazon S3 buckets require, rather than as the first
+	// element of the path, as most compatible services expect.
+	VirtualHosted bool
+
+	// Client is the HTTP client requests are made with.  If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// s3ListResult is the response to a ListObjectsV2 request.
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List implements Storage.
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			names = append(names, c.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Open implements Storage, reading the whole object into memory.
+func (s *S3Storage) Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	return readObject(s.do(ctx, http.MethodGet, name, nil, nil, nil))
+}
+
+// Create implements Storage.  The object is uploaded when the writer is
+// closed.
+func (s *S3Storage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &uploadWriter{upload: func(data []byte) error {
+		resp, err := s.do(ctx, http.MethodPut, name, nil, nil, data)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}}, nil
+}
+
+// Rename implements Storage by copying oldName to newName and deleting
+// oldName, since S3 has no rename operation.
+func (s *S3Storage) Rename(ctx context.Context, oldName, newName string) error {
+	header := http.Header{"X-Amz-Copy-Source": {"/" + s.Bucket + "/" + s3EscapePath(oldName)}}
+	resp, err := s.do(ctx, http.MethodPut, newName, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	resp, err = s.do(ctx, http.MethodDelete, oldName, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// do performs a signed request for the object name, or for the bucket if
+// name is empty, returning an error unless it succeeds.
+func (s *S3Storage) do(ctx context.Context, method, name string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	escaped := "/" + s3EscapePath(cleanName(name))
+	if s.VirtualHosted {
+		u.Host = s.Bucket + "." + u.Host
+	} else if name == "" {
+		escaped = "/" + s3Escape(s.Bucket)
+	} else {
+		escaped = "/" + s3Escape(s.Bucket) + escaped
+	}
+	u.Path, err = url.PathUnescape(escaped)
+	if err != nil {
+		return nil, err
+	}
+	u.RawPath, u.RawQuery = escaped, s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, u, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return checkStatus(client.Do(req))
+}
+
+// sign adds the AWS Signature Version 4 headers for the request to u made at
+// t to req.
+func (s *S3Storage) sign(req *http.Request, u *url.URL, body []byte, t time.Time) {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	date := t.Format("20060102")
+	amzDate := t.Format("20060102T150405Z")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host"}
+	values := map[string]string{"host": u.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") {
+			names = append(names, k)
+			values[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + values[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes every byte of s other than the unreserved
+// characters of RFC 3986, as Signature Version 4 requires.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// s3EscapePath is like s3Escape, but leaves the slashes of an object name.
+func s3EscapePath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = s3Escape(p)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// s3Query encodes query in the canonical form Signature Version 4 requires,
+// sorted by key and with every key and value escaped by s3Escape.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
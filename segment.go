@@ -0,0 +1,267 @@
+package exiflign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// JPEG marker codes, as the second byte of a 0xff-prefixed marker.
+const (
+	markerSOF0  = 0xc0
+	markerSOF15 = 0xcf
+	markerDHT   = 0xc4
+	markerJPG   = 0xc8
+	markerDAC   = 0xcc
+	markerRST0  = 0xd0
+	markerRST7  = 0xd7
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerDQT   = 0xdb
+	markerDRI   = 0xdd
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP13 = 0xed
+	markerAPP14 = 0xee
+	markerAPP15 = 0xef
+	markerCOM   = 0xfe
+	markerTEM   = 0x01
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+var NotJPEGError error = errors.New("The given file is not a JPEG image.")
+
+// segment describes a single JPEG marker segment.
+type segment struct {
+	// marker is the marker code, without the 0xff prefix.
+	marker byte
+
+	// offset is the position of the segment's payload, immediately after the
+	// two length bytes.
+	offset int64
+
+	// length is the size of the payload, not including the length bytes.
+	length int
+}
+
+// walkSegments calls fn for every marker segment of the JPEG image in r, which
+// is size bytes long, from the start of the image up to and including the
+// first SOS segment.  Only the marker headers are read, so the cost of a walk
+// does not depend on the size of the segments.  Walking stops early, without
+// error, if fn returns false.
+func walkSegments(r io.ReaderAt, size int64, fn func(s segment) bool) error {
+	var buffer [4]byte
+
+	_, err := r.ReadAt(buffer[:2], 0)
+	if err != nil || buffer[0] != 0xff || buffer[1] != markerSOI {
+		return NotJPEGError
+	}
+
+	offset := int64(2)
+	for offset < size {
+		_, err := r.ReadAt(buffer[:1], offset)
+		if err != nil {
+			return err
+		}
+		if buffer[0] != 0xff {
+			return NotJPEGError
+		}
+
+		// Any number of 0xff fill bytes may precede a marker code.
+		for buffer[0] == 0xff {
+			offset++
+			_, err = r.ReadAt(buffer[:1], offset)
+			if err != nil {
+				return err
+			}
+		}
+		marker := buffer[0]
+		offset++
+
+		if marker == markerTEM || (marker >= markerRST0 && marker <= markerRST7) {
+			continue
+		}
+		if marker == markerEOI {
+			return nil
+		}
+
+		_, err = r.ReadAt(buffer[:2], offset)
+		if err != nil {
+			return err
+		}
+		length := int(binary.BigEndian.Uint16(buffer[:2]))
+		if length < 2 {
+			return NotJPEGError
+		}
+
+		s := segment{marker: marker, offset: offset + 2, length: length - 2}
+		if !fn(s) || marker == markerSOS {
+			return nil
+		}
+		offset += int64(length)
+	}
+
+	return nil
+}
+
+// readSegment returns the payload of s.
+func readSegment(r io.ReaderAt, s segment) ([]byte, error) {
+	payload := make([]byte, s.length)
+	_, err := r.ReadAt(payload, s.offset)
+	return payload, err
+}
+
+// isExifSegment reports whether the APP1 payload starts with the Exif
+// identifier.
+func isExifSegment(marker byte, payload []byte) bool {
+	return marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader)
+}
+
+// hasTIFFHeader reports whether data starts with a TIFF byte order marker
+// and magic number.
+func hasTIFFHeader(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))
+}
+
+// standardExifPayload returns payload, that of a segment with the given
+// marker, with the standard Exif identifier if it holds EXIF data under a
+// nonstandard one.  Some minimal encoders omit the padding bytes of the
+// identifier, write other bytes in their place, or start the TIFF header
+// directly after the segment length, and such segments would otherwise not be
+// recognised as EXIF data at all.  payload is not modified, and is returned
+// as is if it is not such a segment, or if the standard identifier would not
+// fit.
+func standardExifPayload(marker byte, payload []byte) []byte {
+	if marker != markerAPP1 || isExifSegment(marker, payload) {
+		return payload
+	}
+
+	start := -1
+	if hasTIFFHeader(payload) {
+		start = 0
+	} else if bytes.HasPrefix(payload, []byte("Exif")) {
+		for n := 4; n <= len(exifHeader) && n < len(payload); n++ {
+			if hasTIFFHeader(payload[n:]) {
+				start = n
+				break
+			}
+		}
+	}
+	if start < 0 || len(exifHeader)+len(payload)-start > maxSegmentPayload {
+		return payload
+	}
+
+	return append(append([]byte(nil), exifHeader...), payload[start:]...)
+}
+
+// appendExifContinuation appends to payload, the EXIF segment s of the JPEG
+// image in r including its identifier, the data of any segments continuing
+// it.  Some vendors split EXIF data too large for a single segment across
+// consecutive APP1 segments, each starting with the Exif identifier, which is
+// not standard but is seen in the wild.  A segment can only be continued if
+// it is full.
+func appendExifContinuation(r io.ReaderAt, s segment, payload []byte) ([]byte, error) {
+	var header [4]byte
+	for s.length == maxSegmentPayload {
+		offset := s.offset + int64(s.length)
+		_, err := r.ReadAt(header[:], offset)
+		if err != nil || header[0] != 0xff || header[1] != markerAPP1 {
+			break
+		}
+
+		s = segment{marker: markerAPP1, offset: offset + 4, length: int(binary.BigEndian.Uint16(header[2:])) - 2}
+		if s.length < len(exifHeader) {
+			break
+		}
+		chunk, err := readSegment(r, s)
+		if err != nil {
+			return nil, err
+		}
+		if !isExifSegment(s.marker, chunk) {
+			break
+		}
+		payload = append(payload, chunk[len(exifHeader):]...)
+	}
+
+	return payload, nil
+}
+
+// exifSegments splits payload, EXIF data including its identifier, across as
+// many APP1 segments as it needs, repeating the identifier in each as the
+// vendors that write such data do.
+func exifSegments(payload []byte) []jpegenc.Segment {
+	n := maxSegmentPayload - len(exifHeader)
+	data := payload[len(exifHeader):]
+
+	var segments []jpegenc.Segment
+	for first := true; first || len(data) > 0; first = false {
+		chunk := data[:min(n, len(data))]
+		data = data[len(chunk):]
+		segments = append(segments, jpegenc.Segment{Marker: markerAPP1, Payload: append(append([]byte(nil), exifHeader...), chunk...)})
+	}
+
+	return segments
+}
+
+// exifEditor edits EXIF data that may be split across several APP1 segments
+// for a jpegenc.KeepFunc, which sees the segments one at a time.  The whole
+// block is edited when its first segment is seen, and each segment is then
+// replaced by its own part of the result.  Edits are made in place, so every
+// segment keeps its size.
+type exifEditor struct {
+	// block is the payload of the whole EXIF block including its identifier,
+	// as returned by findExifSegment, or nil to edit segments on their own.
+	block []byte
+	edit  func(payload []byte) []byte
+
+	edited []byte
+	offset int
+}
+
+// next returns the edited version of payload, the next EXIF segment seen.
+// Segments beyond the block, such as a second EXIF segment, are edited on
+// their own.
+func (e *exifEditor) next(payload []byte) []byte {
+	n := len(payload) - len(exifHeader)
+	if e.edited == nil && e.block != nil {
+		e.edited = e.edit(append([]byte(nil), e.block...))
+		e.offset = len(exifHeader)
+	}
+	if e.edited == nil || e.offset+n > len(e.edited) {
+		return e.edit(append([]byte(nil), payload...))
+	}
+
+	chunk := append(append([]byte(nil), exifHeader...), e.edited[e.offset:e.offset+n]...)
+	e.offset += n
+	return chunk
+}
+
+// isSOF reports whether marker is one of the start-of-frame markers, which
+// carry the dimensions of the image.
+func isSOF(marker byte) bool {
+	return marker >= markerSOF0 && marker <= markerSOF15 &&
+		marker != markerDHT && marker != markerJPG && marker != markerDAC
+}
+
+// GetOrientationTagAt behaves as GetOrientationTag, but reads the JPEG image
+// of the given size from r.  Rather than scanning the file, it walks the JPEG
+// marker segments and reads only the EXIF segment, so it suits memory-mapped
+// files and ranged reads from object storage where touching as few bytes as
+// possible matters.
+func GetOrientationTagAt(r io.ReaderAt, size int64) (uint16, error) {
+	info, err := GetInfoAt(r, size)
+	if err != nil {
+		return 0, err
+	}
+	if !info.HasExif {
+		return 0, NoExifError
+	}
+
+	return info.Orientation, nil
+}
@@ -0,0 +1,99 @@
+package exiflign
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// SidecarExt is appended to the name of a normalized file to form the name of
+// its sidecar.
+const SidecarExt = ".json"
+
+// Sidecar describes how NormalizeFile changed an image.  Under
+// FileOptions.Sidecar it is written as JSON next to the output, so that batch
+// runs can be audited and undone file by file.
+type Sidecar struct {
+	// Source is the path the original was read from.
+	Source string `json:"source"`
+
+	// Tag is the orientation tag of the original as stored, or 0 if it has
+	// none.
+	Tag uint16 `json:"tag"`
+
+	// Orientation is the orientation tag that was applied, as in Result, and
+	// Transform the operations that applied it.
+	Orientation uint16    `json:"orientation"`
+	Transform   Transform `json:"transform"`
+
+	// Suggested, Lossless and Copied are as in Result.
+	Suggested bool `json:"suggested,omitempty"`
+	Lossless  bool `json:"lossless,omitempty"`
+	Copied    bool `json:"copied,omitempty"`
+
+	// Exif is the EXIF data of the original, and ExifSegment the payload of
+	// the APP1 segment that held it, including its identifier.  Both are nil
+	// if the original has no EXIF data.
+	Exif        *Exif  `json:"exif,omitempty"`
+	ExifSegment []byte `json:"exif_segment,omitempty"`
+}
+
+// SidecarPath returns the path of the sidecar of the normalized file at path.
+func SidecarPath(path string) string {
+	return path + SidecarExt
+}
+
+// ReadSidecar reads the sidecar at path.
+func ReadSidecar(path string) (*Sidecar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sidecar{}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// newSidecar returns the Sidecar of the original at src, opened as r,
+// holding a snapshot of its EXIF data.  When finished, the internal position
+// in r will be at io.SeekStart.
+func newSidecar(src string, r io.ReadSeeker) (*Sidecar, error) {
+	s := &Sidecar{Source: src}
+
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := findExifSegment(ra, size)
+	if err == nil {
+		s.ExifSegment = payload
+		if x, err := parseExif(payload[len(exifHeader):]); err == nil {
+			s.Exif, s.Tag = x, x.Orientation
+		}
+	} else if err != NoExifError && err != NotJPEGError {
+		return nil, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	return s, err
+}
+
+// write records res in s and writes s to the sidecar of the normalized file
+// at path.
+func (s *Sidecar) write(path string, res *Result) error {
+	s.Orientation = res.Orientation
+	s.Transform = TransformFor(res.Orientation)
+	s.Suggested, s.Lossless, s.Copied = res.Suggested, res.Lossless, res.Copied
+
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(SidecarPath(path), append(data, '\n'), 0644)
+}
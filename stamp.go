@@ -0,0 +1,30 @@
+package exiflign
+
+import "errors"
+
+// StampAttr is the name of the extended attribute used to mark files that have
+// already been normalized by this package.
+const StampAttr = "user.exiflign"
+
+// StampValue is the value written to StampAttr by Stamp.
+const StampValue = "1"
+
+var StampUnsupportedError error = errors.New("Extended attributes are not supported on this platform.")
+
+// Stamp marks the file at path as normalized by setting the StampAttr extended
+// attribute on it, or on Windows by writing StampValue to its alternate data
+// stream named StampAttr.  Stamping does not modify the contents of the file,
+// so it works regardless of whether the image carries any EXIF data.  On
+// platforms other than Linux, macOS and Windows, StampUnsupportedError is
+// returned.
+func Stamp(path string) error {
+	return setStamp(path)
+}
+
+// IsStamped reports whether the file at path has previously been marked with
+// Stamp.  A file on a filesystem that does not support extended attributes is
+// reported as not stamped.  On platforms other than Linux, macOS and Windows,
+// StampUnsupportedError is returned.
+func IsStamped(path string) (bool, error) {
+	return getStamp(path)
+}
@@ -0,0 +1,226 @@
+package exiflign
+
+import (
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Stats summarizes the orientation information found across a corpus of JPEG
+// images.  It is intended to help plan a migration by showing how many files
+// would actually be altered by normalization, and roughly how much work that
+// would be.
+type Stats struct {
+	// Files is the total number of JPEG files examined.
+	Files int
+
+	// Orientations counts files by their orientation tag.  Index 0 is unused,
+	// files without EXIF orientation data are counted in NoExif instead.
+	Orientations [9]int
+
+	// LittleEndian and BigEndian count files by the byte order of their EXIF
+	// data.
+	LittleEndian int
+	BigEndian    int
+
+	// NoExif counts files that have no EXIF orientation information and would
+	// be copied through unchanged.
+	NoExif int
+
+	// Errors counts files that could not be read or are not valid JPEG images.
+	Errors int
+
+	// ReencodeFiles, ReencodeBytes and ReencodePixels estimate the re-encode
+	// cost of normalizing the corpus.  They cover only those files whose
+	// orientation tag requires a transformation, which are the only files that
+	// Normalize decodes and encodes.
+	ReencodeFiles  int
+	ReencodeBytes  int64
+	ReencodePixels int64
+}
+
+// ScanStats walks the directory tree rooted at root and collects Stats for
+// every file with a .jpg or .jpeg extension.  Files that cannot be read are
+// counted in Stats.Errors rather than aborting the scan.
+func ScanStats(root string) (*Stats, error) {
+	s := &Stats{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isJPEGName(path) {
+			return nil
+		}
+
+		s.Files++
+		if s.addFile(path) != nil {
+			s.Errors++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// addFile examines the JPEG image at path and adds it to s.  Errors are
+// returned before s is modified.
+func (s *Stats) addFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	tag, littleEndian, err := getOrientation(f, false)
+	if err == NoExifError {
+		s.NoExif++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Orientations[tag]++
+	if littleEndian {
+		s.LittleEndian++
+	} else {
+		s.BigEndian++
+	}
+
+	if tag != 1 {
+		s.ReencodeFiles++
+		s.ReencodeBytes += info.Size()
+		s.ReencodePixels += int64(cfg.Width) * int64(cfg.Height)
+	}
+
+	return nil
+}
+
+// ResultStats accumulates the outcomes of normalizations, for services that
+// aggregate them over many images, possibly across workers or hosts.  The
+// zero value is empty and ready for use.  A ResultStats is not safe for
+// concurrent use, so concurrent workers should each keep their own and Merge
+// them.
+type ResultStats struct {
+	// Results counts successful normalizations, and Errors failed ones.
+	Results int64
+	Errors  int64
+
+	// Orientations counts successful normalizations by the orientation tag
+	// that was applied, as in Result.Orientation.  Index 0 is unused.
+	Orientations [9]int64
+
+	// NoExif, Suggested, Copied, Lossless and Cached count successful
+	// normalizations whose Result has the field of the same name set.
+	NoExif    int64
+	Suggested int64
+	Copied    int64
+	Lossless  int64
+	Cached    int64
+
+	// InputBytes and OutputBytes total the sizes of the originals and of the
+	// normalized images of successful normalizations.
+	InputBytes  int64
+	OutputBytes int64
+}
+
+// Add adds the outcome of a normalization to s: res, the Result it returned,
+// along with the sizes of the original and of the normalized image, or err
+// if it failed, in which case res and the sizes are ignored.
+func (s *ResultStats) Add(res *Result, inputSize, outputSize int64, err error) {
+	if err != nil || res == nil {
+		s.Errors++
+		return
+	}
+
+	s.Results++
+	if res.Orientation >= 1 && res.Orientation <= 8 {
+		s.Orientations[res.Orientation]++
+	}
+	if res.NoExif {
+		s.NoExif++
+	}
+	if res.Suggested {
+		s.Suggested++
+	}
+	if res.Copied {
+		s.Copied++
+	}
+	if res.Lossless {
+		s.Lossless++
+	}
+	if res.Cached {
+		s.Cached++
+	}
+	s.InputBytes += inputSize
+	s.OutputBytes += outputSize
+}
+
+// Merge adds the outcomes accumulated in other to s.
+func (s *ResultStats) Merge(other *ResultStats) {
+	s.Results += other.Results
+	s.Errors += other.Errors
+	for i, n := range other.Orientations {
+		s.Orientations[i] += n
+	}
+	s.NoExif += other.NoExif
+	s.Suggested += other.Suggested
+	s.Copied += other.Copied
+	s.Lossless += other.Lossless
+	s.Cached += other.Cached
+	s.InputBytes += other.InputBytes
+	s.OutputBytes += other.OutputBytes
+}
+
+// OrientationShare returns the fraction of successful normalizations that
+// applied the orientation tag, or 0 if there were none.
+func (s *ResultStats) OrientationShare(tag uint16) float64 {
+	if s.Results == 0 || tag < 1 || tag > 8 {
+		return 0
+	}
+
+	return float64(s.Orientations[tag]) / float64(s.Results)
+}
+
+// MeanSizeDelta returns the mean change in size, in bytes, from original to
+// normalized image across successful normalizations, negative if images
+// shrank, or 0 if there were none.
+func (s *ResultStats) MeanSizeDelta() float64 {
+	if s.Results == 0 {
+		return 0
+	}
+
+	return float64(s.OutputBytes-s.InputBytes) / float64(s.Results)
+}
+
+// ErrorRate returns the fraction of normalizations that failed, or 0 if
+// there were none.
+func (s *ResultStats) ErrorRate() float64 {
+	total := s.Results + s.Errors
+	if total == 0 {
+		return 0
+	}
+
+	return float64(s.Errors) / float64(total)
+}
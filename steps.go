@@ -0,0 +1,186 @@
+package exiflign
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Step is a transformation applied by a Pipeline to the orientation-corrected
+// image before any output is produced.
+type Step interface {
+	Apply(img image.Image) (image.Image, error)
+}
+
+// StepFunc adapts an ordinary function to the Step interface.
+type StepFunc func(img image.Image) (image.Image, error)
+
+// Apply implements Step.
+func (f StepFunc) Apply(img image.Image) (image.Image, error) {
+	return f(img)
+}
+
+// Watermark is a Step that composites an image, such as a logo or copyright
+// strip, on top of the corrected image.  Because it runs after orientation
+// correction, the watermark always appears upright.
+type Watermark struct {
+	// Image is the watermark to draw.  Its alpha channel is respected.
+	Image image.Image
+
+	// Anchor is the position of the watermark within the image.
+	Anchor imaging.Anchor
+
+	// Margin is the distance, in pixels, between the watermark and the edges
+	// of the image it is anchored to.
+	Margin int
+
+	// Opacity scales the opacity of the watermark, between 0 and 1.  If zero,
+	// the watermark is drawn fully opaque.
+	Opacity float64
+}
+
+// Apply implements Step.
+func (wm Watermark) Apply(img image.Image) (image.Image, error) {
+	opacity := wm.Opacity
+	if opacity == 0 {
+		opacity = 1
+	}
+
+	pos := anchorPoint(img.Bounds().Size(), wm.Image.Bounds().Size(), wm.Anchor, wm.Margin)
+	return imaging.Overlay(img, wm.Image, pos, opacity), nil
+}
+
+// anchorPoint returns the position at which an object of size inner should be
+// placed within a region of size outer, for the given anchor and margin.
+func anchorPoint(outer, inner image.Point, anchor imaging.Anchor, margin int) image.Point {
+	left := margin
+	centerX := (outer.X - inner.X) / 2
+	right := outer.X - inner.X - margin
+	top := margin
+	centerY := (outer.Y - inner.Y) / 2
+	bottom := outer.Y - inner.Y - margin
+
+	switch anchor {
+	case imaging.TopLeft:
+		return image.Pt(left, top)
+	case imaging.Top:
+		return image.Pt(centerX, top)
+	case imaging.TopRight:
+		return image.Pt(right, top)
+	case imaging.Left:
+		return image.Pt(left, centerY)
+	case imaging.Right:
+		return image.Pt(right, centerY)
+	case imaging.BottomLeft:
+		return image.Pt(left, bottom)
+	case imaging.Bottom:
+		return image.Pt(centerX, bottom)
+	case imaging.BottomRight:
+		return image.Pt(right, bottom)
+	}
+
+	return image.Pt(centerX, centerY)
+}
+
+// Crop is a Step that crops the corrected image to a target aspect ratio,
+// such as 1:1 for square avatars, keeping as much of the image as possible.
+type Crop struct {
+	// AspectX and AspectY give the target aspect ratio as width to height.
+	AspectX int
+	AspectY int
+
+	// Anchor is the part of the image kept when cropping.  It is ignored if
+	// Entropy is set.
+	Anchor imaging.Anchor
+
+	// Entropy, if set, keeps the part of the image with the most detail, as
+	// measured by the entropy of its luminance, instead of using Anchor.
+	Entropy bool
+}
+
+// Apply implements Step.
+func (c Crop) Apply(img image.Image) (image.Image, error) {
+	if c.AspectX <= 0 || c.AspectY <= 0 {
+		return nil, InvalidSizeError
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w*c.AspectY > h*c.AspectX {
+		w = h * c.AspectX / c.AspectY
+	} else {
+		h = w * c.AspectY / c.AspectX
+	}
+
+	// Ratios too extreme for the image round down to nothing along its short
+	// side, of which a single row or column is kept instead.
+	w, h = max(w, min(1, b.Dx())), max(h, min(1, b.Dy()))
+	if w == b.Dx() && h == b.Dy() {
+		return img, nil
+	}
+
+	var pos image.Point
+	if c.Entropy {
+		pos = entropyPoint(img, image.Pt(w, h))
+	} else {
+		pos = anchorPoint(b.Size(), image.Pt(w, h), c.Anchor, 0)
+	}
+
+	rect := image.Rect(0, 0, w, h).Add(pos).Add(b.Min)
+	if _, ok := img.(*image.Gray); ok {
+		return toGray(imaging.Crop(img, rect)), nil
+	}
+
+	return imaging.Crop(img, rect), nil
+}
+
+// entropyPoint returns the position of the window of the given size within
+// img whose luminance has the highest entropy.  Windows are only considered
+// along the axis in which the window is smaller than the image.
+func entropyPoint(img image.Image, size image.Point) image.Point {
+	b := img.Bounds()
+	grid, gw, gh := luminanceGrid(img, checkGridSize)
+
+	// The window in grid cells, and the number of pixels per cell.
+	ww := max(size.X*gw/b.Dx(), 1)
+	wh := max(size.Y*gh/b.Dy(), 1)
+	sx := float64(b.Dx()) / float64(gw)
+	sy := float64(b.Dy()) / float64(gh)
+
+	best, bestEntropy := image.Point{}, -1.0
+	for y := 0; y+wh <= gh; y++ {
+		for x := 0; x+ww <= gw; x++ {
+			if e := gridEntropy(grid, gw, image.Rect(x, y, x+ww, y+wh)); e > bestEntropy {
+				best, bestEntropy = image.Pt(x, y), e
+			}
+		}
+	}
+
+	pos := image.Pt(int(float64(best.X)*sx), int(float64(best.Y)*sy))
+	pos.X = min(pos.X, b.Dx()-size.X)
+	pos.Y = min(pos.Y, b.Dy()-size.Y)
+	return pos
+}
+
+// gridEntropy computes the Shannon entropy of the luminance values in the
+// cells of grid, of width gw, that fall within r.
+func gridEntropy(grid []float64, gw int, r image.Rectangle) float64 {
+	var histogram [32]int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			histogram[min(int(grid[y*gw+x]*32), 31)]++
+		}
+	}
+
+	var entropy float64
+	n := float64(r.Dx() * r.Dy())
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+
+	return entropy
+}
@@ -0,0 +1,31 @@
+package exiflign
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCropExtremeAspect(t *testing.T) {
+	tests := []struct {
+		aspectX, aspectY int
+		want             image.Point
+	}{
+		{1, 1, image.Pt(500, 500)},
+		{2, 1, image.Pt(500, 250)},
+		{1, 1000, image.Pt(1, 500)},
+		{1000, 1, image.Pt(500, 1)},
+		{1, 1 << 20, image.Pt(1, 500)},
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 500, 500))
+	for _, test := range tests {
+		out, err := Crop{AspectX: test.aspectX, AspectY: test.aspectY}.Apply(img)
+		if err != nil {
+			t.Errorf("%d:%d: %v", test.aspectX, test.aspectY, err)
+			continue
+		}
+		if got := out.Bounds().Size(); got != test.want {
+			t.Errorf("%d:%d: size %v, want %v", test.aspectX, test.aspectY, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package exiflign
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Storage is a store of named objects, such as a local directory or an S3
+// bucket, that NormalizeStorage reads originals from and writes outputs to.
+// Names are slash-separated paths relative to the root of the store.
+type Storage interface {
+	// List returns the names of every object whose name starts with
+	// prefix, in lexical order.
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// Open opens the object with the given name for reading.
+	Open(ctx context.Context, name string) (io.ReadSeekCloser, error)
+
+	// Create creates or truncates the object with the given name.  The
+	// object is only guaranteed to be complete once the writer has been
+	// closed without error.
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+
+	// Rename moves the object oldName to newName, replacing any object
+	// already there.
+	Rename(ctx context.Context, oldName, newName string) error
+}
+
+// storageTempSuffix is appended to the name of an output while it is being
+// written, so that it is not mistaken for a JPEG image if left behind.
+const storageTempSuffix = ".exiflign-tmp"
+
+// NormalizeStorage normalizes every object with a .jpg or .jpeg extension
+// under prefix in src into the object with the same name in dst, which may be
+// src itself to normalize the objects in place.  Each output is written under
+// a temporary name and then renamed, so no object is left partially written.
+// Objects that were quarantined are skipped.
+func NormalizeStorage(ctx context.Context, src, dst Storage, prefix string, opts *Options) error {
+	names, err := src.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !isJPEGName(name) {
+			continue
+		}
+
+		_, err = normalizeObject(ctx, src, dst, name, name, opts)
+		if err != nil && err != QuarantinedError {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StorageStampSuffix is appended to the name of an object to name its stamp,
+// the empty object that marks it as normalized in a Storage, which has no
+// extended attributes to hold a stamp like Stamp does.
+const StorageStampSuffix = ".exiflign"
+
+// NormalizeStorageStamped is like NormalizeStorage, but skips every object
+// whose output in dst already has a stamp, and stamps every output it
+// writes, so that it can be run repeatedly over a growing store to normalize
+// only the objects added since the last run.
+func NormalizeStorageStamped(ctx context.Context, src, dst Storage, prefix string, opts *Options) error {
+	names, err := src.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	stamped := make(map[string]bool)
+	stamps, err := dst.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, name := range stamps {
+		if strings.HasSuffix(name, StorageStampSuffix) {
+			stamped[strings.TrimSuffix(name, StorageStampSuffix)] = true
+		}
+	}
+
+	for _, name := range names {
+		if !isJPEGName(name) || stamped[name] {
+			continue
+		}
+
+		_, err = normalizeObject(ctx, src, dst, name, name, opts)
+		if err == QuarantinedError {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		w, err := dst.Create(ctx, name+StorageStampSuffix)
+		if err != nil {
+			return err
+		}
+		err = w.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// normalizeObject normalizes the object name of src into the object out of
+// dst.
+func normalizeObject(ctx context.Context, src, dst Storage, name, out string, opts *Options) (*Result, error) {
+	r, err := src.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	w, err := dst.Create(ctx, out+storageTempSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := NormalizeWithOptions(r, w, opts)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return res, dst.Rename(ctx, out+storageTempSuffix, out)
+}
+
+// uploadWriter buffers an object created in a remote Storage until it is
+// closed, and then uploads it whole.
+type uploadWriter struct {
+	bytes.Buffer
+	upload func(data []byte) error
+}
+
+// Close uploads the object.
+func (w *uploadWriter) Close() error {
+	return w.upload(w.Bytes())
+}
+
+// nopSeekCloser adds a no-op Close method to an io.ReadSeeker.
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// readObject reads the whole body of resp, the response to a request for an
+// object of a remote Storage, into memory.
+func readObject(resp *http.Response, err error) (io.ReadSeekCloser, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return nopSeekCloser{bytes.NewReader(data)}, nil
+}
+
+// checkStatus returns resp and err, the result of a request made to a remote
+// Storage, with an error in place of resp unless the request succeeded.
+func checkStatus(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		u := *resp.Request.URL
+		u.RawQuery = ""
+		return nil, fmt.Errorf("Unexpected status %q while requesting %s.", resp.Status, u.String())
+	}
+
+	return resp, nil
+}
+
+// LocalStorage is a Storage over the directory tree rooted at Root.
+type LocalStorage struct {
+	Root string
+}
+
+// path returns the local path of the object name.
+func (s LocalStorage) path(name string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(cleanName(name)))
+}
+
+// List implements Storage.  Only the directory containing prefix is walked.
+func (s LocalStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	dir := prefix[:strings.LastIndexByte(prefix, '/')+1]
+
+	var names []string
+	err := filepath.WalkDir(s.path(dir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(s.Root, p)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	sort.Strings(names)
+
+	return names, err
+}
+
+// Open implements Storage.
+func (s LocalStorage) Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	return os.Open(s.path(name))
+}
+
+// Create implements Storage, creating directories as required.
+func (s LocalStorage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	err := os.MkdirAll(filepath.Dir(s.path(name)), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Create(s.path(name))
+}
+
+// Rename implements Storage, creating directories as required.
+func (s LocalStorage) Rename(ctx context.Context, oldName, newName string) error {
+	err := os.MkdirAll(filepath.Dir(s.path(newName)), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(s.path(oldName), s.path(newName))
+}
+
+// cleanName returns name without leading slashes or dot segments, so that it
+// cannot escape the root of a store.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
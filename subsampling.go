@@ -0,0 +1,100 @@
+package exiflign
+
+import (
+	"image"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// Subsampling describes the chroma subsampling of a JPEG image.
+type Subsampling int
+
+const (
+	// SubsamplingDefault leaves the choice of subsampling to image/jpeg,
+	// which always produces 4:2:0.  It is also reported for grayscale images
+	// and subsamplings that cannot be reproduced.
+	SubsamplingDefault Subsampling = iota
+	Subsampling444
+	Subsampling422
+	Subsampling420
+	Subsampling440
+)
+
+// String returns the conventional J:a:b notation for s.
+func (s Subsampling) String() string {
+	switch s {
+	case Subsampling444:
+		return "4:4:4"
+	case Subsampling422:
+		return "4:2:2"
+	case Subsampling420:
+		return "4:2:0"
+	case Subsampling440:
+		return "4:4:0"
+	}
+
+	return "default"
+}
+
+// sampling returns the sampling factors used by jpegenc for s.
+func (s Subsampling) sampling() jpegenc.Sampling {
+	switch s {
+	case Subsampling444:
+		return jpegenc.Sampling444
+	case Subsampling422:
+		return jpegenc.Sampling422
+	case Subsampling440:
+		return jpegenc.Sampling440
+	}
+
+	return jpegenc.Sampling420
+}
+
+// subsamplingOf reports the subsampling of a decoded JPEG image.
+func subsamplingOf(img image.Image) Subsampling {
+	ycc, ok := img.(*image.YCbCr)
+	if !ok {
+		return SubsamplingDefault
+	}
+
+	switch ycc.SubsampleRatio {
+	case image.YCbCrSubsampleRatio444:
+		return Subsampling444
+	case image.YCbCrSubsampleRatio422:
+		return Subsampling422
+	case image.YCbCrSubsampleRatio420:
+		return Subsampling420
+	case image.YCbCrSubsampleRatio440:
+		return Subsampling440
+	}
+
+	return SubsamplingDefault
+}
+
+// transposed returns the subsampling of an image with subsampling s once it
+// has been rotated by a quarter turn, as for orientation tags 5 to 8, so that
+// chroma resolution is kept along the same axis of the displayed image.
+func (s Subsampling) transposed() Subsampling {
+	switch s {
+	case Subsampling422:
+		return Subsampling440
+	case Subsampling440:
+		return Subsampling422
+	}
+
+	return s
+}
+
+// outputSubsampling returns the subsampling that an image normalized with o,
+// as described by res, should be encoded with.
+func (o *Options) outputSubsampling(res *Result) Subsampling {
+	if !o.PreserveSubsampling || res == nil {
+		return SubsamplingDefault
+	}
+
+	if res.Orientation >= 5 && res.Orientation <= 8 {
+		return res.Subsampling.transposed()
+	}
+
+	return res.Subsampling
+}
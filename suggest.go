@@ -0,0 +1,273 @@
+package exiflign
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// DefaultMinConfidence is the confidence a Suggester must report before its
+// suggestion is applied, when Options.MinConfidence is not set.
+const DefaultMinConfidence = 0.8
+
+// Suggester guesses the orientation of images that carry no orientation
+// metadata, such as the output of document scanners.  Implementations may
+// use anything from simple image statistics to a trained model.
+type Suggester interface {
+	// Suggest returns the orientation tag that should be applied to img to
+	// make it upright, along with a confidence between 0 and 1.  A tag of 1
+	// means the image already appears upright.
+	Suggest(img image.Image) (uint16, float64, error)
+}
+
+// KeyedSuggester is a Suggester that identifies its settings, such as the
+// model it runs, so that the images it orients can be kept in Options.Cache.
+type KeyedSuggester interface {
+	Suggester
+
+	// CacheKey returns a string that differs between Suggesters that would
+	// suggest different orientations for the same image.
+	CacheKey() string
+}
+
+// HorizonSuggester is a Suggester that relies on natural scenes usually being
+// brighter at the top than at the bottom, the same heuristic used by
+// CheckOrientation.  It only ever suggests rotations, never mirroring.
+type HorizonSuggester struct{}
+
+// Suggest implements Suggester.
+func (HorizonSuggester) Suggest(img image.Image) (uint16, float64, error) {
+	scores := uprightScores(img)
+
+	best := 0
+	for rot := 1; rot < 4; rot++ {
+		if scores[rot] > scores[best] {
+			best = rot
+		}
+	}
+	if best == 0 {
+		return 1, 1 - math.Exp(-(scores[0] - max(scores[1], scores[2], scores[3]))), nil
+	}
+
+	return tagFor(best, false), 1 - math.Exp(-(scores[best] - scores[0])), nil
+}
+
+// CacheKey implements KeyedSuggester.  HorizonSuggester has no settings.
+func (HorizonSuggester) CacheKey() string {
+	return ""
+}
+
+// DefaultDocumentResolution is the resolution a DocumentSuggester analyzes
+// pages at, when DocumentSuggester.Resolution is not set.
+const DefaultDocumentResolution = 1024
+
+// DefaultDocumentMinLines is the number of text lines a DocumentSuggester
+// must find before it suggests anything, when DocumentSuggester.MinLines is
+// not set.
+const DefaultDocumentMinLines = 3
+
+// documentBiasScale converts the bias of ascenders within text lines into
+// confidence.
+const documentBiasScale = 2
+
+// DocumentSuggester is a Suggester for scanned documents, such as receipts
+// and letters, whose pages were fed in sideways or upside down and which carry
+// no EXIF data at all.  It finds the direction of the text lines from
+// projection profiles of dark pixels, which alternate sharply between lines
+// and the gaps between them across the lines but not along them, and which
+// way up the lines are from ascenders and capitals being more common than
+// descenders in Latin script, which puts more ink above the dense core of
+// each line than below it.  It only ever suggests rotations, never mirroring,
+// and is not meant for photographs, for which its confidence is usually low.
+type DocumentSuggester struct {
+	// Resolution is the longest side, in cells, of the grid pages are
+	// analyzed at.  Text lines must be several cells high at this
+	// resolution to be told apart.  If zero, DefaultDocumentResolution is
+	// used.
+	Resolution int
+
+	// MinLines is the number of text lines that must be found for anything
+	// to be suggested.  If zero, DefaultDocumentMinLines is used.
+	MinLines int
+}
+
+// Suggest implements Suggester.
+func (s DocumentSuggester) Suggest(img image.Image) (uint16, float64, error) {
+	resolution := s.Resolution
+	if resolution <= 0 {
+		resolution = DefaultDocumentResolution
+	}
+	minLines := s.MinLines
+	if minLines <= 0 {
+		minLines = DefaultDocumentMinLines
+	}
+
+	grid, w, h := luminanceGrid(img, resolution)
+	ink, ok := inkMask(grid)
+	if !ok {
+		return 1, 0, nil
+	}
+	rows, cols := make([]float64, h), make([]float64, w)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if ink[y*w+x] {
+				rows[y]++
+				cols[x]++
+			}
+		}
+	}
+
+	rowContrast, colContrast := profileContrast(rows), profileContrast(cols)
+	if rowContrast == 0 && colContrast == 0 {
+		return 1, 0, nil
+	}
+	profile, vertical := rows, false
+	if colContrast > rowContrast {
+		profile, vertical = cols, true
+	}
+	axis := 1 - math.Pow(min(rowContrast, colContrast)/max(rowContrast, colContrast), 3)
+
+	bias, lines := ascenderBias(profile)
+	if lines < minLines {
+		return 1, 0, nil
+	}
+	confidence := axis * (1 - math.Exp(-math.Abs(bias)*math.Sqrt(float64(lines))*documentBiasScale))
+
+	// Upright lines have their ascenders above them.  Lines running down
+	// the page with their ascenders to the right were turned clockwise, and
+	// are turned back by three quarter turns.
+	var rotate int
+	switch {
+	case !vertical && bias < 0:
+		rotate = 2
+	case vertical && bias < 0:
+		rotate = 3
+	case vertical:
+		rotate = 1
+	}
+
+	return tagFor(rotate, false), confidence, nil
+}
+
+// CacheKey implements KeyedSuggester.
+func (s DocumentSuggester) CacheKey() string {
+	return fmt.Sprintf("%d:%d", s.Resolution, s.MinLines)
+}
+
+// inkMask marks the cells of grid, a luminance grid as returned by
+// luminanceGrid, that are distinctly darker than the page around them.  It
+// reports false if grid is too uniform to hold any ink.
+func inkMask(grid []float64) ([]bool, bool) {
+	var sum, sumSq float64
+	for _, v := range grid {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(grid))
+	mean := sum / n
+	stddev := math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+	if stddev < 0.02 {
+		return nil, false
+	}
+
+	ink := make([]bool, len(grid))
+	for i, v := range grid {
+		ink[i] = v < mean-stddev
+	}
+
+	return ink, true
+}
+
+// profileContrast rates how strongly the projection profile p alternates
+// between high and low values, as the coefficient of variation of p between
+// its first and last ink, after averaging out the gaps between characters
+// over a few neighbouring values.
+func profileContrast(p []float64) float64 {
+	start, end := 0, len(p)
+	for start < end && p[start] == 0 {
+		start++
+	}
+	for end > start && p[end-1] == 0 {
+		end--
+	}
+	if end-start < 3 {
+		return 0
+	}
+
+	var sum, sumSq float64
+	for i := start + 1; i < end-1; i++ {
+		v := (p[i-1] + p[i] + p[i+1]) / 3
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(end - start - 2)
+	mean := sum / n
+	if mean == 0 {
+		return 0
+	}
+
+	return math.Sqrt(math.Max(sumSq/n-mean*mean, 0)) / mean
+}
+
+// ascenderBias splits the projection profile p, taken across text lines,
+// into lines separated by gaps, and returns how much more of the ink of
+// their ascenders and descenders, outside of the dense core of each line,
+// lies towards the start of p than towards its end, as a fraction of that
+// ink between -1 and 1.  It also returns the number of lines found.
+func ascenderBias(p []float64) (float64, int) {
+	var peak float64
+	for _, v := range p {
+		peak = max(peak, v)
+	}
+	threshold := peak / 20
+
+	var before, after float64
+	var lines int
+	for i := 0; i < len(p); {
+		if p[i] <= threshold {
+			i++
+			continue
+		}
+
+		start := i
+		var linePeak float64
+		for ; i < len(p) && p[i] > threshold; i++ {
+			linePeak = max(linePeak, p[i])
+		}
+		if i-start < 3 {
+			continue
+		}
+
+		// The core spans the rows holding at least half as much ink as
+		// the densest one, and the rest of the line lies on either side.
+		core0, core1 := start, i
+		for p[core0] < linePeak/2 {
+			core0++
+		}
+		for p[core1-1] < linePeak/2 {
+			core1--
+		}
+		for j := start; j < core0; j++ {
+			before += p[j]
+		}
+		for j := core1; j < i; j++ {
+			after += p[j]
+		}
+		lines++
+	}
+	if before+after == 0 {
+		return 0, lines
+	}
+
+	return (before - after) / (before + after), lines
+}
+
+// suggesterKey identifies s in cache keys by its type and, for a
+// KeyedSuggester, its settings.
+func suggesterKey(s Suggester) string {
+	if k, ok := s.(KeyedSuggester); ok {
+		return fmt.Sprintf("%T(%q)", k, k.CacheKey())
+	}
+
+	return fmt.Sprintf("%T", s)
+}
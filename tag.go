@@ -0,0 +1,173 @@
+package exiflign
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// IFD identifies one of the IFDs of EXIF data.
+type IFD int
+
+const (
+	// IFD0 describes the main image, and holds the orientation tag along
+	// with the make, model and software.
+	IFD0 IFD = iota
+
+	// IFD1 describes the embedded thumbnail.
+	IFD1
+
+	// ExifIFD is the Exif sub-IFD, which holds the exposure settings and
+	// timestamps.
+	ExifIFD
+
+	// GPSIFD holds the location the image was taken at.
+	GPSIFD
+
+	// InteropIFD is the Interoperability IFD, reached through ExifIFD.
+	InteropIFD
+)
+
+var NoTagError error = errors.New("The given file does not contain the requested EXIF tag.")
+
+// Tag is a single field of EXIF data, as found by FindTag.
+type Tag struct {
+	// ID is the tag identifier, such as 0x0112 for the orientation.
+	ID uint16
+
+	// Type is the TIFF field type, such as 3 for SHORT.
+	Type uint16
+
+	// Count is the number of values of the field.
+	Count uint32
+
+	// Value is the raw value of the field, in the byte order of the EXIF
+	// data.
+	Value []byte
+
+	// LittleEndian is set when the EXIF data is little-endian encoded.
+	LittleEndian bool
+}
+
+// FindTag returns the field with the given tag identifier in the given IFD of
+// the EXIF data of the JPEG image in r.  Like GetOrientationTag, it walks the
+// marker segments up to the EXIF segment and reads nothing else, so it is
+// suitable for tools that need a single field of many files.  NoExifError is
+// returned if the image has no EXIF segment, and NoTagError if the IFD or the
+// tag is missing.  When finished, the internal position in r will be at
+// io.SeekStart.
+func FindTag(r io.ReadSeeker, ifd IFD, id uint16) (*Tag, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := FindTagAt(ra, size, ifd, id)
+	_, serr := r.Seek(0, io.SeekStart)
+	if err == nil {
+		err = serr
+	}
+
+	return tag, err
+}
+
+// FindTagAt is like FindTag, for a JPEG image of the given size in r.
+func FindTagAt(r io.ReaderAt, size int64, ifd IFD, id uint16) (*Tag, error) {
+	payload, err := findExifSegment(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newTIFFReader(payload[len(exifHeader):])
+	if err != nil {
+		return nil, err
+	}
+	offset, ok := t.ifdOffset(ifd)
+	if !ok {
+		return nil, NoTagError
+	}
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.tag == id {
+			return &Tag{ID: e.tag, Type: e.typ, Count: e.count, Value: e.value, LittleEndian: t.littleEndian()}, nil
+		}
+	}
+
+	return nil, NoTagError
+}
+
+// ifdOffset returns the offset of ifd, reporting whether the data has it.
+func (t *tiffReader) ifdOffset(ifd IFD) (uint32, bool) {
+	ifd0, next, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return 0, false
+	}
+
+	switch ifd {
+	case IFD0:
+		return t.firstIFD(), true
+	case IFD1:
+		return next, next != 0
+	case ExifIFD, InteropIFD:
+		offset, ok := t.pointer(ifd0, tagExifIFD)
+		if !ok || ifd == ExifIFD {
+			return offset, ok
+		}
+		entries, _, err := t.ifd(offset)
+		if err != nil {
+			return 0, false
+		}
+		return t.pointer(entries, tagInteropIFD)
+	case GPSIFD:
+		return t.pointer(ifd0, tagGPSIFD)
+	}
+
+	return 0, false
+}
+
+// pointer returns the offset held by the entry of entries with the given tag,
+// reporting whether there is one.
+func (t *tiffReader) pointer(entries []ifdEntry, tag uint16) (uint32, bool) {
+	for _, e := range entries {
+		if e.tag == tag && e.count >= 1 {
+			offset := t.uint(e, 0)
+			return offset, offset != 0
+		}
+	}
+
+	return 0, false
+}
+
+// reader returns a tiffReader able to interpret the value of g.
+func (g *Tag) reader() (*tiffReader, ifdEntry) {
+	var order binary.ByteOrder = binary.BigEndian
+	if g.LittleEndian {
+		order = binary.LittleEndian
+	}
+
+	return &tiffReader{data: g.Value, order: order}, ifdEntry{tag: g.ID, typ: g.Type, count: g.Count, value: g.Value}
+}
+
+// Uint returns the i'th value of an integral field, or 0 if there is none.
+func (g *Tag) Uint(i int) uint32 {
+	t, e := g.reader()
+	return t.uint(e, i)
+}
+
+// Float returns the i'th value of a numeric field as a float64, or 0 if there
+// is none.
+func (g *Tag) Float(i int) float64 {
+	t, e := g.reader()
+	return t.float(e, i)
+}
+
+// String returns the value of an ASCII field, without its terminating NUL and
+// surrounding spaces, or "" if the field is not ASCII.
+func (g *Tag) String() string {
+	t, e := g.reader()
+	return t.string(e)
+}
@@ -0,0 +1,174 @@
+package exiflign
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var InvalidTemplateError error = errors.New("The output template contains an unknown or unterminated field.")
+
+// unknownField is substituted for template fields the image has no data for.
+const unknownField = "unknown"
+
+// ExpandOutputTemplate returns the relative path given by template for the
+// JPEG image at src, replacing each field in braces with the metadata of the
+// image.  The fields are:
+//
+//	{date}         the date the image was taken, as 2006-01-02
+//	{year}         the year the image was taken
+//	{month}        the month the image was taken, as 01 to 12
+//	{day}          the day the image was taken, as 01 to 31
+//	{camera}       the make and model of the camera
+//	{orientation}  the orientation tag of the original, 1 if it has none
+//	{hash}         the first 16 hex digits of the SHA-256 of the original
+//	{name}         the base name of src without its extension
+//	{ext}          the extension of src, including the dot
+//
+// The date is taken from DateTimeOriginal, or DateTime if the image has no
+// DateTimeOriginal, and date and camera fields the image has no data for are
+// replaced with "unknown".  Path separators within field values are replaced,
+// so that a field never adds or escapes directories, as are the characters
+// Windows does not allow in names.  Names Windows reserves, such as CON or
+// NUL.jpg, and names ending in dots or spaces are altered so that the same
+// template gives the same paths on every platform.
+func ExpandOutputTemplate(template, src string) (string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	x, err := ReadExif(f)
+	if err == NoExifError || err == InvalidTIFFError {
+		x = &Exif{}
+	} else if err != nil {
+		return "", err
+	}
+	tag, err := GetOrientationTag(f)
+	if err == NoExifError {
+		tag = 1
+	} else if err != nil {
+		return "", err
+	}
+	h, err := HashOf(f)
+	if err != nil {
+		return "", err
+	}
+
+	fields := map[string]string{
+		"date":        unknownField,
+		"year":        unknownField,
+		"month":       unknownField,
+		"day":         unknownField,
+		"camera":      strings.TrimSpace(x.Make + " " + x.Model),
+		"orientation": strconv.Itoa(int(tag)),
+		"hash":        h.String()[:16],
+		"ext":         filepath.Ext(src),
+	}
+	fields["name"] = strings.TrimSuffix(filepath.Base(src), fields["ext"])
+	if fields["camera"] == "" {
+		fields["camera"] = unknownField
+	}
+
+	date := x.DateTimeOriginal
+	if date == "" {
+		date = x.DateTime
+	}
+	if t, err := time.Parse(exifTimeLayout, date); err == nil {
+		fields["date"] = t.Format("2006-01-02")
+		fields["year"] = t.Format("2006")
+		fields["month"] = t.Format("01")
+		fields["day"] = t.Format("02")
+	}
+
+	return expandTemplate(template, fields)
+}
+
+// expandTemplate replaces each field in braces in template with its value in
+// fields, sanitized for use as part of a path.  InvalidTemplateError is
+// returned for fields not in fields.
+func expandTemplate(template string, fields map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(template, '{')
+		if i < 0 {
+			b.WriteString(template)
+			break
+		}
+		j := strings.IndexByte(template[i:], '}')
+		if j < 0 {
+			return "", InvalidTemplateError
+		}
+
+		name := template[i+1 : i+j]
+		value, ok := fields[name]
+		if !ok {
+			return "", InvalidTemplateError
+		}
+
+		b.WriteString(template[:i])
+		b.WriteString(sanitizeField(value))
+		template = template[i+j+1:]
+	}
+
+	elems := strings.Split(b.String(), "/")
+	for i, elem := range elems {
+		elems[i] = portableName(elem)
+	}
+
+	return filepath.Clean(filepath.FromSlash(strings.Join(elems, "/"))), nil
+}
+
+// sanitizeField makes value safe to use as part of a single path element.
+func sanitizeField(value string) string {
+	value = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r < ' ' || strings.ContainsRune(`<>"|?*`, r):
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "." || value == ".." {
+		return strings.Repeat("_", len(value))
+	}
+
+	return value
+}
+
+// reservedNames are the names Windows reserves for devices, with or without
+// an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// portableName makes the path element name one that can be created on any
+// platform, so that the same template lays out the same tree everywhere.
+// Names Windows reserves for devices have an underscore added to their
+// stem, and trailing dots and spaces, which Windows drops, are replaced
+// with underscores.
+func portableName(name string) string {
+	if name == "." || name == ".." {
+		return name
+	}
+
+	trimmed := strings.TrimRight(name, ". ")
+	name = trimmed + strings.Repeat("_", len(name)-len(trimmed))
+
+	stem, rest, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		name = stem + "_"
+		if rest != "" {
+			name += "." + rest
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,85 @@
+package exiflign
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestJPEG writes a 32 by 16 JPEG image with ifd0 as the IFD0 of its
+// EXIF data to path.
+func writeTestJPEG(t *testing.T, path string, ifd0 testIFD) {
+	t.Helper()
+
+	exif := append(append([]byte(nil), exifHeader...), buildTIFF(binary.LittleEndian, ifd0)...)
+	err := os.WriteFile(path, buildJPEG(t, 32, 16, map[byte][]byte{markerAPP1: exif}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPortableName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"CON", "CON_"},
+		{"con", "con_"},
+		{"NUL.jpg", "NUL_.jpg"},
+		{"nul.tar.gz", "nul_.tar.gz"},
+		{"Aux .jpg", "Aux _.jpg"},
+		{"COM1", "COM1_"},
+		{"lpt9.jpeg", "lpt9_.jpeg"},
+		{"COM0", "COM0"},
+		{"CONSOLE.jpg", "CONSOLE.jpg"},
+		{"prn.", "prn_"},
+		{"trailing.", "trailing_"},
+		{"trailing ", "trailing_"},
+		{"dots...", "dots___"},
+		{".", "."},
+		{"..", ".."},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := portableName(test.name); got != test.want {
+			t.Errorf("portableName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExpandOutputTemplateReservedNames(t *testing.T) {
+	tests := []struct {
+		template    string
+		make, model string
+		want        string
+	}{
+		{"{camera}/{name}{ext}", "CON", "", "CON_/photo.jpg"},
+		{"{camera}/{name}{ext}", "Aux", "", "Aux_/photo.jpg"},
+		{"{camera}{ext}", "nul", "", "nul_.jpg"},
+		{"{camera}/{name}{ext}", "Canon", "LPT1", "Canon LPT1/photo.jpg"},
+		{"{camera}/{name}{ext}", "Canon.", "", "Canon_/photo.jpg"},
+		{"{camera}/{name}{ext}", "COM3", "..", "COM3___/photo.jpg"},
+		{"prn/{orientation}/{name}{ext}", "Canon", "", "prn_/6/photo.jpg"},
+		{"{camera}/{name}{ext}", "a/../../CON", "", "a_.._.._CON/photo.jpg"},
+	}
+
+	for _, test := range tests {
+		src := filepath.Join(t.TempDir(), "photo.jpg")
+		writeTestJPEG(t, src, testIFD{
+			{tagMake, test.make},
+			{tagModel, test.model},
+			{tagOrientation, uint16(6)},
+		})
+
+		got, err := ExpandOutputTemplate(test.template, src)
+		if err != nil {
+			t.Errorf("ExpandOutputTemplate(%q) with %q %q: %v", test.template, test.make, test.model, err)
+			continue
+		}
+		if want := filepath.FromSlash(test.want); got != want {
+			t.Errorf("ExpandOutputTemplate(%q) with %q %q = %q, want %q", test.template, test.make, test.model, got, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package exiflign
+
+import (
+	"errors"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+var InvalidSizeError error = errors.New("The requested thumbnail size must be positive.")
+
+// ThumbnailOptions controls the behaviour of Thumbnail and Thumbnails.  A nil
+// *ThumbnailOptions is equivalent to the zero value.
+type ThumbnailOptions struct {
+	// Options controls how the image is oriented and encoded.  Options.Cache
+	// is not consulted.
+	Options
+
+	// Filter is the resampling filter used to downscale the image.  If unset,
+	// imaging.Lanczos is used.
+	Filter *imaging.ResampleFilter
+}
+
+// ThumbnailSpec requests a single thumbnail from Thumbnails.
+type ThumbnailSpec struct {
+	// W receives the encoded thumbnail.
+	W io.Writer
+
+	// MaxDim is the largest the width and height of the thumbnail may be.
+	MaxDim int
+}
+
+// Thumbnail decodes the JPEG image in r, corrects its orientation, and writes
+// a JPEG thumbnail to w whose width and height are no larger than maxDim,
+// preserving the aspect ratio.  Images that are already small enough are not
+// enlarged.  Orientation and scaling happen on a single decode, so this is
+// considerably cheaper than normalizing and then resizing.  When finished,
+// the internal position in r will be at io.SeekStart.
+func Thumbnail(r io.ReadSeeker, w io.Writer, maxDim int, opts *ThumbnailOptions) error {
+	return Thumbnails(r, []ThumbnailSpec{{W: w, MaxDim: maxDim}}, opts)
+}
+
+// Thumbnails is like Thumbnail, but produces several thumbnails from a single
+// decode of r.  Smaller thumbnails are downscaled from larger ones rather than
+// from the full-size image, which keeps the cost of each additional size low.
+func Thumbnails(r io.ReadSeeker, specs []ThumbnailSpec, opts *ThumbnailOptions) error {
+	if opts == nil {
+		opts = &ThumbnailOptions{}
+	}
+	for _, s := range specs {
+		if s.MaxDim <= 0 {
+			return InvalidSizeError
+		}
+	}
+
+	p := &Pipeline{Options: opts.Options, Filter: opts.Filter}
+	for _, s := range specs {
+		p.Outputs = append(p.Outputs, Output{W: s.W, MaxDim: s.MaxDim})
+	}
+
+	_, err := p.Run(r)
+	return err
+}
@@ -0,0 +1,297 @@
+package exiflign
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// TIFF field types, as used in EXIF IFD entries.
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffSByte     = 6
+	tiffUndefined = 7
+	tiffSShort    = 8
+	tiffSLong     = 9
+	tiffSRational = 10
+	tiffFloat     = 11
+	tiffDouble    = 12
+)
+
+// tiffTypeSizes gives the size in bytes of a single value of each TIFF field
+// type.  Unknown types have a size of 0.
+var tiffTypeSizes = [13]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+const tagOrientation = 0x0112
+
+var InvalidTIFFError error = errors.New("The EXIF data is not a valid TIFF structure.")
+
+// tiffReader provides access to the IFDs of a TIFF structure, which is the
+// format EXIF data is stored in.
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a single field of an IFD.
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+
+	// offset is the position within the TIFF data of the entry itself.
+	offset int
+
+	// value is the raw, still byte-order dependent, value of the field.  It
+	// aliases the TIFF data.
+	value []byte
+
+	// valueOffset is the position within the TIFF data of value.
+	valueOffset int
+}
+
+// newTIFFReader checks the TIFF header at the start of data and prepares to
+// read its IFDs.
+func newTIFFReader(data []byte) (*tiffReader, error) {
+	if len(data) < 8 {
+		return nil, InvalidTIFFError
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case data[0] == 'I' && data[1] == 'I':
+		order = binary.LittleEndian
+	case data[0] == 'M' && data[1] == 'M':
+		order = binary.BigEndian
+	default:
+		return nil, InvalidTIFFError
+	}
+
+	if order.Uint16(data[2:]) != 42 {
+		return nil, InvalidTIFFError
+	}
+
+	return &tiffReader{data: data, order: order}, nil
+}
+
+// littleEndian reports whether the TIFF data is little-endian encoded.
+func (t *tiffReader) littleEndian() bool {
+	return t.order == binary.LittleEndian
+}
+
+// firstIFD returns the offset of IFD0.
+func (t *tiffReader) firstIFD() uint32 {
+	return t.order.Uint32(t.data[4:])
+}
+
+// ifd reads the entries of the IFD at offset, along with the offset of the
+// next IFD in the chain, which is 0 at the end of the chain.  Entries whose
+// values lie outside of the TIFF data are skipped.
+func (t *tiffReader) ifd(offset uint32) ([]ifdEntry, uint32, error) {
+	if int64(offset)+2 > int64(len(t.data)) {
+		return nil, 0, InvalidTIFFError
+	}
+
+	n := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+12*n+4 > len(t.data) {
+		return nil, 0, InvalidTIFFError
+	}
+
+	entries := make([]ifdEntry, 0, n)
+	for i := 0; i < n; i++ {
+		p := start + 12*i
+		e := ifdEntry{
+			tag:         t.order.Uint16(t.data[p:]),
+			typ:         t.order.Uint16(t.data[p+2:]),
+			count:       t.order.Uint32(t.data[p+4:]),
+			offset:      p,
+			valueOffset: p + 8,
+		}
+
+		if int(e.typ) >= len(tiffTypeSizes) || tiffTypeSizes[e.typ] == 0 {
+			continue
+		}
+		size := int64(tiffTypeSizes[e.typ]) * int64(e.count)
+		if size > 4 {
+			e.valueOffset = int(t.order.Uint32(t.data[p+8:]))
+		}
+		if int64(e.valueOffset)+size > int64(len(t.data)) {
+			continue
+		}
+		e.value = t.data[e.valueOffset : e.valueOffset+int(size)]
+
+		entries = append(entries, e)
+	}
+
+	next := t.order.Uint32(t.data[start+12*n:])
+	return entries, next, nil
+}
+
+// uint returns the i'th value of an integral entry, or 0 if there is none.
+func (t *tiffReader) uint(e ifdEntry, i int) uint32 {
+	if uint32(i) >= e.count {
+		return 0
+	}
+
+	switch e.typ {
+	case tiffByte, tiffUndefined:
+		return uint32(e.value[i])
+	case tiffShort:
+		return uint32(t.order.Uint16(e.value[2*i:]))
+	case tiffLong:
+		return t.order.Uint32(e.value[4*i:])
+	}
+
+	return 0
+}
+
+// exifOrientation finds the orientation tag in the TIFF structure data, the
+// payload of an EXIF segment after its identifier.  The value is returned as
+// stored, without being checked against the valid range.
+func exifOrientation(data []byte) (uint16, bool) {
+	t, err := newTIFFReader(data)
+	if err != nil {
+		return 0, false
+	}
+
+	entries, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return 0, false
+	}
+
+	for _, e := range entries {
+		if e.tag == tagOrientation && e.typ == tiffShort && e.count >= 1 {
+			return uint16(t.uint(e, 0)), true
+		}
+	}
+
+	return 0, false
+}
+
+// lenientExifOrientation is like exifOrientation, but tolerates TIFF data
+// whose byte order marker contradicts the byte order it was actually written
+// in, as produced by some buggy editors, either throughout or for the
+// orientation value alone.  Each combination of byte orders for the IFD
+// structure and the value is tried, those of the marker first, and the first
+// to yield an orientation between 1 and 8 is used.  If none does, the
+// orientation as read by exifOrientation is returned.
+func lenientExifOrientation(data []byte) (uint16, bool) {
+	tag, found := exifOrientation(data)
+	if found && tag >= 1 && tag <= 8 {
+		return tag, true
+	}
+	if len(data) < 8 || !(data[0] == 'I' && data[1] == 'I' || data[0] == 'M' && data[1] == 'M') {
+		return tag, found
+	}
+	if binary.BigEndian.Uint16(data[2:]) != 42 && binary.LittleEndian.Uint16(data[2:]) != 42 {
+		return tag, found
+	}
+
+	orders := []binary.ByteOrder{binary.BigEndian, binary.LittleEndian}
+	if data[0] == 'I' {
+		orders[0], orders[1] = orders[1], orders[0]
+	}
+	for _, order := range orders {
+		t := &tiffReader{data: data, order: order}
+		entries, _, err := t.ifd(t.firstIFD())
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			if e.tag != tagOrientation || e.typ != tiffShort || e.count < 1 {
+				continue
+			}
+			for _, valueOrder := range orders {
+				if v := valueOrder.Uint16(e.value); v >= 1 && v <= 8 {
+					return v, true
+				}
+			}
+		}
+	}
+
+	return tag, found
+}
+
+// setExifOrientation overwrites the orientation tag in the TIFF structure
+// data in place, reporting whether one was found.
+func setExifOrientation(data []byte, tag uint16) bool {
+	t, err := newTIFFReader(data)
+	if err != nil {
+		return false
+	}
+
+	entries, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return false
+	}
+
+	for _, e := range entries {
+		if e.tag == tagOrientation && e.typ == tiffShort && e.count >= 1 {
+			t.order.PutUint16(e.value, tag)
+			return true
+		}
+	}
+
+	return false
+}
+
+// setUint overwrites the first value of a SHORT or LONG entry in place,
+// reporting whether v could be stored.
+func (t *tiffReader) setUint(e ifdEntry, v uint32) bool {
+	switch {
+	case e.typ == tiffShort && v <= 0xffff:
+		t.order.PutUint16(e.value, uint16(v))
+	case e.typ == tiffLong:
+		t.order.PutUint32(e.value, v)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// exifIFD returns the entries of the Exif sub-IFD of the TIFF structure.
+func (t *tiffReader) exifIFD() ([]ifdEntry, error) {
+	ifd0, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range ifd0 {
+		if e.tag == tagExifIFD && e.count >= 1 {
+			entries, _, err := t.ifd(t.uint(e, 0))
+			return entries, err
+		}
+	}
+
+	return nil, InvalidTIFFError
+}
+
+// setExifDimensions overwrites the PixelXDimension and PixelYDimension tags
+// of the TIFF structure data in place, where present.  Since nothing is moved,
+// the offsets of every other IFD, such as the Interoperability IFD, and of
+// maker notes stay valid.
+func setExifDimensions(data []byte, w, h int) {
+	t, err := newTIFFReader(data)
+	if err != nil {
+		return
+	}
+	entries, err := t.exifIFD()
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		switch e.tag {
+		case tagPixelXDimension:
+			t.setUint(e, uint32(w))
+		case tagPixelYDimension:
+			t.setUint(e, uint32(h))
+		}
+	}
+}
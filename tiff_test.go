@@ -0,0 +1,110 @@
+package exiflign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// orientationTIFF returns a TIFF structure whose IFD0 holds the given
+// orientation among other fields, encoded in order.
+func orientationTIFF(order binary.ByteOrder, tag uint16) []byte {
+	return buildTIFF(order, testIFD{
+		{tagMake, "Canon"},
+		{tagModel, "Canon EOS 5D Mark IV"},
+		{tagOrientation, tag},
+		{tagExifIFD, testIFD{
+			{tagExifVersion, []byte("0230")},
+		}},
+	})
+}
+
+// withMarker returns a copy of data with its byte order marker replaced by
+// marker, contradicting the byte order it was encoded in.
+func withMarker(data []byte, marker string) []byte {
+	data = append([]byte(nil), data...)
+	copy(data, marker)
+	return data
+}
+
+// withSwappedOrientation returns a copy of data with the bytes of the
+// orientation value swapped, as if that value alone had been written in the
+// other byte order.
+func withSwappedOrientation(data []byte) []byte {
+	data = append([]byte(nil), data...)
+	t, err := newTIFFReader(data)
+	if err != nil {
+		panic(err)
+	}
+	entries, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		if e.tag == tagOrientation {
+			e.value[0], e.value[1] = e.value[1], e.value[0]
+		}
+	}
+
+	return data
+}
+
+func TestOrientationEndianness(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+
+	tests := []struct {
+		name string
+		tiff []byte
+
+		// strict and strictErr are what GetOrientationTag returns, and
+		// lenient and lenientErr what GetOrientationTagLenient returns.
+		strict     uint16
+		strictErr  error
+		lenient    uint16
+		lenientErr error
+	}{
+		{"little-endian", orientationTIFF(le, 6), 6, nil, 6, nil},
+		{"big-endian", orientationTIFF(be, 8), 8, nil, 8, nil},
+		{"big-endian marked little-endian", withMarker(orientationTIFF(be, 6), "II"), 0, NoExifError, 6, nil},
+		{"little-endian marked big-endian", withMarker(orientationTIFF(le, 3), "MM"), 0, NoExifError, 3, nil},
+		{"big-endian value in little-endian data", withSwappedOrientation(orientationTIFF(le, 5)), 1, nil, 5, nil},
+		{"little-endian value in big-endian data", withSwappedOrientation(orientationTIFF(be, 7)), 1, nil, 7, nil},
+		{"upright value in contradicting data", withSwappedOrientation(orientationTIFF(le, 1)), 1, nil, 1, nil},
+		{"invalid value in either order", orientationTIFF(le, 0x0909), 1, nil, 1, nil},
+		{"invalid value marked big-endian", withMarker(orientationTIFF(le, 0x0909), "MM"), 0, NoExifError, 0, NoExifError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exif := append(append([]byte(nil), exifHeader...), test.tiff...)
+			in := buildJPEG(t, 16, 16, map[byte][]byte{markerAPP1: exif})
+
+			tag, err := GetOrientationTag(bytes.NewReader(in))
+			if tag != test.strict || err != test.strictErr {
+				t.Errorf("GetOrientationTag = %d, %v, want %d, %v", tag, err, test.strict, test.strictErr)
+			}
+
+			r := bytes.NewReader(in)
+			tag, err = GetOrientationTagLenient(r)
+			if tag != test.lenient || err != test.lenientErr {
+				t.Errorf("GetOrientationTagLenient = %d, %v, want %d, %v", tag, err, test.lenient, test.lenientErr)
+			}
+			if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+				t.Errorf("GetOrientationTagLenient left the reader at %d", pos)
+			}
+
+			res, err := NormalizeWithOptions(bytes.NewReader(in), io.Discard, &Options{LenientEndianness: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.lenient
+			if test.lenientErr != nil {
+				want = 1
+			}
+			if res.Orientation != want {
+				t.Errorf("NormalizeWithOptions under LenientEndianness applied %d, want %d", res.Orientation, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package exiflign
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var TimeoutError error = errors.New("The given image could not be normalized in the time allowed.")
+
+// normalizeTimed performs NormalizeWithOptions within opts.Timeout.  The
+// output is held back until it is complete, so that nothing is written to w
+// on timeout.  Go offers no way to stop the normalization itself, which
+// carries on in the background once timed out, until its next attempt to read
+// r fails.
+func normalizeTimed(r io.ReadSeeker, w io.Writer, opts *Options) (*Result, error) {
+	o := *opts
+	o.Timeout = 0
+
+	type outcome struct {
+		res *Result
+		err error
+	}
+	cr := &cancelableReader{r: r}
+	var buffer bytes.Buffer
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := NormalizeWithOptions(cr, &buffer, &o)
+		done <- outcome{res, err}
+	}()
+
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+	select {
+	case out := <-done:
+		if out.err != nil {
+			return nil, out.err
+		}
+		_, err := w.Write(buffer.Bytes())
+		return out.res, err
+	case <-timer.C:
+		cr.cancel()
+		r.Seek(0, io.SeekStart)
+		return nil, TimeoutError
+	}
+}
+
+// cancelableReader is an io.ReadSeeker that fails with TimeoutError once
+// canceled, so that a normalization that timed out no longer touches the
+// reader it was given once NormalizeWithOptions has returned.
+type cancelableReader struct {
+	mu       sync.Mutex
+	r        io.ReadSeeker
+	canceled bool
+}
+
+func (c *cancelableReader) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.canceled {
+		return 0, TimeoutError
+	}
+
+	return c.r.Read(p)
+}
+
+func (c *cancelableReader) Seek(offset int64, whence int) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.canceled {
+		return 0, TimeoutError
+	}
+
+	return c.r.Seek(offset, whence)
+}
+
+// cancel makes every later call fail, waiting for any call in progress.
+func (c *cancelableReader) cancel() {
+	c.mu.Lock()
+	c.canceled = true
+	c.mu.Unlock()
+}
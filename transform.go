@@ -0,0 +1,276 @@
+package exiflign
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// transformPoint maps the position (x, y) in an image of size (w, h) to its
+// position after the transformation for tag.  It also returns the size of the
+// transformed image.
+func transformPoint(x, y, w, h int, tag uint16) (int, int, int, int) {
+	op := tagOps[tag]
+
+	switch op.rotate {
+	case 1:
+		x, y, w, h = h-1-y, x, h, w
+	case 2:
+		x, y = w-1-x, h-1-y
+	case 3:
+		x, y, w, h = y, w-1-x, h, w
+	}
+	if op.flip {
+		x = w - 1 - x
+	}
+
+	return x, y, w, h
+}
+
+// transformGray performs the transformation for tag on a grayscale image,
+// keeping it single channel.  imaging always produces *image.NRGBA, which
+// image/jpeg would then encode as a three channel image of three times the
+// size.
+func transformGray(src *image.Gray, tag uint16) *image.Gray {
+	b := src.Bounds()
+	_, _, dw, dh := transformPoint(0, 0, b.Dx(), b.Dy(), tag)
+	dst := image.NewGray(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < b.Dy(); y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			dx, dy, _, _ := transformPoint(x, y, b.Dx(), b.Dy(), tag)
+			dst.Pix[dy*dst.Stride+dx] = row[x]
+		}
+	}
+
+	return dst
+}
+
+// chromaFactors returns the horizontal and vertical chroma subsampling
+// factors of ratio, or 0, 0 if the ratio is unknown.
+func chromaFactors(ratio image.YCbCrSubsampleRatio) (int, int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio444:
+		return 1, 1
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+
+	return 0, 0
+}
+
+// transformYCbCr performs the transformation for tag directly on the planes
+// of a YCbCr image, as decoded by image/jpeg, which avoids converting it to
+// RGB and back when it is encoded again.  Each chroma plane is transformed as
+// a whole, so a quarter turn also transposes the subsample ratio.  It reports
+// false, leaving the image to the generic path, when the image does not cover
+// whole chroma samples or its ratio has no transposed counterpart.
+func transformYCbCr(src *image.YCbCr, tag uint16) (*image.YCbCr, bool) {
+	b := src.Bounds()
+	sx, sy := chromaFactors(src.SubsampleRatio)
+	if sx == 0 || b.Min.X%sx != 0 || b.Min.Y%sy != 0 || b.Dx()%sx != 0 || b.Dy()%sy != 0 {
+		return nil, false
+	}
+
+	ratio := src.SubsampleRatio
+	if tagOps[tag].rotate%2 == 1 {
+		switch ratio {
+		case image.YCbCrSubsampleRatio422:
+			ratio = image.YCbCrSubsampleRatio440
+		case image.YCbCrSubsampleRatio440:
+			ratio = image.YCbCrSubsampleRatio422
+		case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+			return nil, false
+		}
+	}
+
+	_, _, dw, dh := transformPoint(0, 0, b.Dx(), b.Dy(), tag)
+	dst := image.NewYCbCr(image.Rect(0, 0, dw, dh), ratio)
+
+	y0 := src.YOffset(b.Min.X, b.Min.Y)
+	for y := 0; y < b.Dy(); y++ {
+		row := src.Y[y0+y*src.YStride:]
+		for x := 0; x < b.Dx(); x++ {
+			dx, dy, _, _ := transformPoint(x, y, b.Dx(), b.Dy(), tag)
+			dst.Y[dy*dst.YStride+dx] = row[x]
+		}
+	}
+
+	c0 := src.COffset(b.Min.X, b.Min.Y)
+	cw, ch := b.Dx()/sx, b.Dy()/sy
+	for y := 0; y < ch; y++ {
+		cb, cr := src.Cb[c0+y*src.CStride:], src.Cr[c0+y*src.CStride:]
+		for x := 0; x < cw; x++ {
+			dx, dy, _, _ := transformPoint(x, y, cw, ch, tag)
+			dst.Cb[dy*dst.CStride+dx] = cb[x]
+			dst.Cr[dy*dst.CStride+dx] = cr[x]
+		}
+	}
+
+	return dst, true
+}
+
+// toGray converts img, which must only contain shades of gray, back to a
+// single channel image.
+func toGray(img image.Image) *image.Gray {
+	if g, ok := img.(*image.Gray); ok {
+		return g
+	}
+
+	b := img.Bounds()
+	dst := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	nrgba := imaging.Clone(img)
+	for i := range dst.Pix {
+		dst.Pix[i] = nrgba.Pix[4*i]
+	}
+
+	return dst
+}
+
+// TransformNRGBAInPlace performs the transformation for tag on img without
+// allocating a new image, for callers that already own a mutable buffer such
+// as the output of an earlier pipeline step.  img itself is modified: its
+// pixels are rearranged within img.Pix and, for tags that exchange width and
+// height, its Rect and Stride are updated to describe the transformed image.
+// The returned image is img, so any other image sharing img.Pix, such as a
+// sub-image or the parent of one, no longer shows meaningful pixels
+// afterwards.
+//
+// The rows of img must be contiguous in img.Pix, as they are for images
+// created by image.NewNRGBA, for the quarter turns of tags 5 to 8 to be
+// performed in place.  Otherwise, such as for most sub-images, those tags
+// fall back to a copy and img is left untouched.
+func TransformNRGBAInPlace(img *image.NRGBA, tag uint16) *image.NRGBA {
+	if tag < 2 || tag > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return img
+	}
+
+	if tagOps[tag].rotate%2 == 0 {
+		flipNRGBA(img, tag)
+		return img
+	}
+
+	if img.Stride != 4*w {
+		return imaging.Clone(TransformForTag(img, tag))
+	}
+
+	// Rearrange the pixels by following the cycles of the permutation, which
+	// needs a single bit of bookkeeping per pixel.
+	start := img.PixOffset(b.Min.X, b.Min.Y)
+	pix := img.Pix[start : start+4*w*h]
+	visited := make([]uint64, (w*h+63)/64)
+	for i := 0; i < w*h; i++ {
+		if visited[i/64]&(1<<(i%64)) != 0 {
+			continue
+		}
+
+		var carry [4]byte
+		copy(carry[:], pix[4*i:])
+		j := i
+		for {
+			dx, dy, dw, _ := transformPoint(j%w, j/w, w, h, tag)
+			d := dy*dw + dx
+			visited[d/64] |= 1 << (d % 64)
+
+			var next [4]byte
+			copy(next[:], pix[4*d:])
+			copy(pix[4*d:], carry[:])
+			carry = next
+			if d == i {
+				break
+			}
+			j = d
+		}
+	}
+
+	img.Pix = pix
+	img.Stride = 4 * h
+	img.Rect = image.Rect(0, 0, h, w)
+
+	return img
+}
+
+// flipNRGBA performs the transformation for tag, which must not exchange width
+// and height, on img by swapping pixels within each row and swapping rows.
+func flipNRGBA(img *image.NRGBA, tag uint16) {
+	b := img.Bounds()
+	flipPlane(img.Pix[img.PixOffset(b.Min.X, b.Min.Y):], img.Stride, b.Dx(), b.Dy(), 4, tag)
+}
+
+// flipPlane performs the transformation for tag, which must not exchange
+// width and height, on the w by h samples of size n starting at pix, whose
+// rows are stride bytes apart.
+func flipPlane(pix []byte, stride, w, h, n int, tag uint16) {
+	op := tagOps[tag]
+	mirror := op.flip != (op.rotate == 2)
+
+	row := func(y int) []byte {
+		return pix[y*stride : y*stride+n*w]
+	}
+
+	if op.rotate == 2 {
+		tmp := make([]byte, n*w)
+		for y := 0; y < h/2; y++ {
+			top, bottom := row(y), row(h-1-y)
+			copy(tmp, top)
+			copy(top, bottom)
+			copy(bottom, tmp)
+		}
+	}
+
+	if mirror {
+		for y := 0; y < h; y++ {
+			mirrorRow(row(y), n)
+		}
+	}
+}
+
+// transformInPlace performs the transformation for tag on img, which the
+// caller must own, like TransformForTag.  Flips and the half turn only swap
+// rows and pixels, so for the image types image/jpeg decodes to they are
+// performed within the buffers of img instead of allocating a second image of
+// the same size, keeping the bounds of img.  The quarter turns, and other
+// image types, are left to TransformForTag.
+func transformInPlace(img image.Image, tag uint16) image.Image {
+	if tag < 2 || tag > 8 || tagOps[tag].rotate%2 == 1 {
+		return TransformForTag(img, tag)
+	}
+
+	b := img.Bounds()
+	switch src := img.(type) {
+	case *image.Gray:
+		flipPlane(src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, b.Dx(), b.Dy(), 1, tag)
+		return src
+	case *image.NRGBA:
+		flipNRGBA(src, tag)
+		return src
+	case *image.YCbCr:
+		sx, sy := chromaFactors(src.SubsampleRatio)
+		if sx == 0 || b.Min.X%sx != 0 || b.Min.Y%sy != 0 || b.Dx()%sx != 0 || b.Dy()%sy != 0 {
+			break
+		}
+
+		flipPlane(src.Y[src.YOffset(b.Min.X, b.Min.Y):], src.YStride, b.Dx(), b.Dy(), 1, tag)
+		c := src.COffset(b.Min.X, b.Min.Y)
+		flipPlane(src.Cb[c:], src.CStride, b.Dx()/sx, b.Dy()/sy, 1, tag)
+		flipPlane(src.Cr[c:], src.CStride, b.Dx()/sx, b.Dy()/sy, 1, tag)
+		return src
+	}
+
+	return TransformForTag(img, tag)
+}
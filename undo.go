@@ -0,0 +1,168 @@
+package exiflign
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/luke-park/exiflign/internal/jpegenc"
+)
+
+// UndoFile reverses the normalization of the file at path, as described by
+// its sidecar written under FileOptions.Sidecar.  The inverse of the applied
+// transformation is applied to the pixels, and the original EXIF segment,
+// with its orientation tag, takes the place of any EXIF data the file has.
+// Files that were copied or transformed losslessly are restored without
+// being re-encoded where possible, others are re-encoded with opts, which may
+// be nil.  Like NormalizeFile, the file is replaced atomically.  The sidecar
+// is removed once it no longer describes the file.
+func UndoFile(path string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	s, err := ReadSidecar(SidecarPath(path))
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tag := uint16(1)
+	if s.Orientation >= 1 && s.Orientation <= 8 {
+		tag = inverseTag(s.Orientation)
+	}
+
+	var buffer bytes.Buffer
+	err = jpegenc.ErrNotTransformable
+	if s.Lossless || tag == 1 {
+		err = undoLossless(&buffer, data, tag, s)
+	}
+	if err == jpegenc.ErrNotTransformable {
+		buffer.Reset()
+		err = undoReencode(&buffer, data, tag, s, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = replaceFile(path, buffer.Bytes(), info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(SidecarPath(path))
+}
+
+// UndoDir walks the directory tree rooted at dir and undoes the normalization
+// of every JPEG file that has a sidecar with UndoFile.  Files without one are
+// left untouched.
+func UndoDir(dir string, opts *Options) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isJPEGName(path) {
+			return err
+		}
+
+		_, err = os.Stat(SidecarPath(path))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return UndoFile(path, opts)
+	})
+}
+
+// undoLossless writes data, transformed for tag in the DCT domain and with
+// the EXIF data of s restored, to buffer.  It returns
+// jpegenc.ErrNotTransformable if data must be re-encoded instead.
+func undoLossless(buffer *bytes.Buffer, data []byte, tag uint16, s *Sidecar) error {
+	// The EXIF data of data may span several segments, as may that of s, so
+	// rather than being replaced in place it is dropped and added anew.
+	var extra []jpegenc.Segment
+	if s.ExifSegment != nil {
+		extra = exifSegments(s.ExifSegment)
+	}
+	keep := func(marker byte, payload []byte) ([]byte, bool) {
+		return payload, !isExifSegment(marker, standardExifPayload(marker, payload))
+	}
+
+	return jpegenc.Transform(buffer, data, losslessOps[tag], keep, extra)
+}
+
+// undoReencode writes data, decoded, transformed for tag and re-encoded with
+// opts, to buffer.  The EXIF data of s is restored and the other APPn and COM
+// segments of data are kept.
+func undoReencode(buffer *bytes.Buffer, data []byte, tag uint16, s *Sidecar, opts *Options) error {
+	r := bytes.NewReader(data)
+	res := &Result{Orientation: 1}
+	img, err := decode(r, opts, res)
+	if err != nil {
+		return err
+	}
+	img = transformInPlace(img, tag)
+
+	var segments []jpegenc.Segment
+	if s.ExifSegment != nil {
+		segments = exifSegments(s.ExifSegment)
+	}
+	var serr error
+	err = walkSegments(r, int64(len(data)), func(seg segment) bool {
+		if (seg.marker < markerAPP0 || seg.marker > markerAPP15) && seg.marker != markerCOM {
+			return true
+		}
+
+		var payload []byte
+		payload, serr = readSegment(r, seg)
+		if serr != nil {
+			return false
+		}
+		if !isExifSegment(seg.marker, standardExifPayload(seg.marker, payload)) {
+			segments = append(segments, jpegenc.Segment{Marker: seg.marker, Payload: payload})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if serr != nil {
+		return serr
+	}
+
+	return encode(buffer, img, segments, nil, opts, res)
+}
+
+// replaceFile atomically replaces the file at path with one holding data,
+// by writing it to a temporary file alongside path that is then renamed.
+func replaceFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".exiflign-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
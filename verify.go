@@ -0,0 +1,247 @@
+package exiflign
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// DefaultMinPSNR is the peak signal-to-noise ratio, in decibels, that Verify
+// requires when VerifyOptions.MinPSNR is not set.  Small or oddly sized images
+// re-encoded at the default JPEG quality can fall to the mid twenties, as
+// their partial blocks are padded, while a wrong rotation or flip of a
+// photograph rarely reaches 15.
+const DefaultMinPSNR = 20
+
+// DefaultMinSSIM is the structural similarity that Verify requires when
+// VerifyOptions.MinSSIM is not set.  Re-encoding preserves structure well
+// even where it loses detail, whereas a wrong rotation or flip destroys it.
+const DefaultMinSSIM = 0.75
+
+var VerificationError error = errors.New("The normalized image does not match the original.")
+
+// VerifyOptions controls the comparison performed by Verify.  A nil
+// *VerifyOptions is equivalent to the zero value.
+type VerifyOptions struct {
+	// Exact requires every pixel to be identical.  This is appropriate when the
+	// normalized image was produced without re-encoding.
+	Exact bool
+
+	// MinPSNR is the lowest acceptable peak signal-to-noise ratio, in decibels,
+	// between the two images.  It is ignored when Exact is set.  If zero,
+	// DefaultMinPSNR is used.
+	MinPSNR float64
+
+	// MinSSIM is the lowest acceptable structural similarity, between -1 and
+	// 1, of the luma of the two images.  It is ignored when Exact is set.  If
+	// zero, DefaultMinSSIM is used.
+	MinSSIM float64
+
+	// Orientation, if non-zero, is the orientation tag that was applied to
+	// the original, as reported by Result.Orientation, which is used in place
+	// of the tag embedded in it.  This accounts for orientations taken from
+	// XMP sidecars, suggesters, device quirks or lenient parsing.
+	Orientation uint16
+
+	// Trimmed is set when the partial MCUs at the edges of the normalized
+	// image were dropped, as reported by Result.Trimmed, in which case the
+	// original is trimmed to the same size before they are compared.
+	Trimmed bool
+}
+
+// Verify decodes the JPEG images in original and normalized, applies each of
+// their orientation tags, and compares the resulting pixels.  It returns the
+// peak signal-to-noise ratio between the two, which is +Inf for identical
+// images, and VerificationError if they differ by more than opts allows,
+// either in PSNR or in structural similarity (SSIM).  When finished, the
+// internal positions of both readers will be at io.SeekStart.
+func Verify(original, normalized io.ReadSeeker, opts *VerifyOptions) (float64, error) {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+
+	img2, err := decodeOriented(normalized, 0, image.Point{})
+	if err != nil {
+		return 0, err
+	}
+	var trim image.Point
+	if opts.Trimmed {
+		trim = img2.Bounds().Size()
+	}
+	img1, err := decodeOriented(original, opts.Orientation, trim)
+	if err != nil {
+		return 0, err
+	}
+
+	if img1.Bounds().Dx() != img2.Bounds().Dx() || img1.Bounds().Dy() != img2.Bounds().Dy() {
+		return 0, VerificationError
+	}
+
+	psnr := comparePSNR(img1, img2)
+	if opts.Exact {
+		if !math.IsInf(psnr, 1) {
+			return psnr, VerificationError
+		}
+		return psnr, nil
+	}
+
+	minPSNR := opts.MinPSNR
+	if minPSNR == 0 {
+		minPSNR = DefaultMinPSNR
+	}
+	minSSIM := opts.MinSSIM
+	if minSSIM == 0 {
+		minSSIM = DefaultMinSSIM
+	}
+	if psnr < minPSNR || compareSSIM(img1, img2) < minSSIM {
+		return psnr, VerificationError
+	}
+
+	return psnr, nil
+}
+
+// decodeOriented decodes the JPEG image in r and applies tag, or its own
+// orientation tag if tag is 0, producing the image as it is intended to be
+// displayed.  If trim is non-zero, the image is first trimmed to that size,
+// given in display coordinates, by dropping its right and bottom edges as
+// stored, as Options.Trim does.  When finished, the internal position in r
+// will be at io.SeekStart.
+func decodeOriented(r io.ReadSeeker, tag uint16, trim image.Point) (image.Image, error) {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	if tag == 0 {
+		tag, err = GetOrientationTag(r)
+		if err != nil && err != NoExifError {
+			return nil, err
+		}
+		_, err = r.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	img, err := jpeg.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	if trim != (image.Point{}) {
+		if tag >= 5 && tag <= 8 {
+			trim.X, trim.Y = trim.Y, trim.X
+		}
+		b := img.Bounds()
+		if trim.X < b.Dx() || trim.Y < b.Dy() {
+			img = imaging.Crop(img, image.Rect(b.Min.X, b.Min.Y, b.Min.X+trim.X, b.Min.Y+trim.Y))
+		}
+	}
+
+	return TransformForTag(img, tag), nil
+}
+
+// comparePSNR computes the peak signal-to-noise ratio over the red, green and
+// blue channels of two images with identical dimensions.
+func comparePSNR(img1, img2 image.Image) float64 {
+	b1, b2 := img1.Bounds(), img2.Bounds()
+
+	var sum float64
+	for y := 0; y < b1.Dy(); y++ {
+		for x := 0; x < b1.Dx(); x++ {
+			r1, g1, bl1, _ := img1.At(b1.Min.X+x, b1.Min.Y+y).RGBA()
+			r2, g2, bl2, _ := img2.At(b2.Min.X+x, b2.Min.Y+y).RGBA()
+
+			dr := float64(r1>>8) - float64(r2>>8)
+			dg := float64(g1>>8) - float64(g2>>8)
+			db := float64(bl1>>8) - float64(bl2>>8)
+			sum += dr*dr + dg*dg + db*db
+		}
+	}
+
+	if sum == 0 {
+		return math.Inf(1)
+	}
+
+	mse := sum / float64(3*b1.Dx()*b1.Dy())
+	return 10 * math.Log10(255*255/mse)
+}
+
+// ssimWindow and ssimStep are the size of the square windows compareSSIM
+// averages the structural similarity over, and the distance between them.
+const (
+	ssimWindow = 8
+	ssimStep   = 4
+)
+
+// compareSSIM computes the mean structural similarity of the luma of two
+// images with identical dimensions, over windows of ssimWindow pixels.
+// Images smaller than a window are compared as a single window.
+func compareSSIM(img1, img2 image.Image) float64 {
+	b := img1.Bounds()
+	w, h := b.Dx(), b.Dy()
+	y1, y2 := lumaPlane(img1), lumaPlane(img2)
+
+	ww, wh := min(ssimWindow, w), min(ssimWindow, h)
+	var sum float64
+	var windows int
+	for y := 0; y+wh <= h; y += ssimStep {
+		for x := 0; x+ww <= w; x += ssimStep {
+			sum += windowSSIM(y1, y2, w, x, y, ww, wh)
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+
+	return sum / float64(windows)
+}
+
+// windowSSIM computes the structural similarity of the window of the given
+// size at (x, y) of two luma planes of the given stride.
+func windowSSIM(y1, y2 []float64, stride, x, y, w, h int) float64 {
+	const c1, c2 = (0.01 * 255) * (0.01 * 255), (0.03 * 255) * (0.03 * 255)
+
+	var s1, s2, s11, s22, s12 float64
+	for j := y; j < y+h; j++ {
+		for i := x; i < x+w; i++ {
+			a, b := y1[j*stride+i], y2[j*stride+i]
+			s1 += a
+			s2 += b
+			s11 += a * a
+			s22 += b * b
+			s12 += a * b
+		}
+	}
+
+	n := float64(w * h)
+	m1, m2 := s1/n, s2/n
+	v1, v2 := s11/n-m1*m1, s22/n-m2*m2
+	cov := s12/n - m1*m2
+
+	return (2*m1*m2 + c1) * (2*cov + c2) / ((m1*m1 + m2*m2 + c1) * (v1 + v2 + c2))
+}
+
+// lumaPlane returns the luma of every pixel of img, between 0 and 255, row by
+// row.
+func lumaPlane(img image.Image) []float64 {
+	b := img.Bounds()
+	plane := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			plane = append(plane, (0.299*float64(r)+0.587*float64(g)+0.114*float64(bl))/257)
+		}
+	}
+
+	return plane
+}
@@ -0,0 +1,38 @@
+package exiflign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestVerifyTrimmed(t *testing.T) {
+	// Transposing, for orientation 5, never needs trimming.
+	for _, tag := range []uint16{2, 3, 4, 6, 7, 8} {
+		t.Run(fmt.Sprint(tag), func(t *testing.T) {
+			// Neither side is a multiple of the 16 pixel MCUs, so that Trim
+			// drops some of both.
+			exif := append(append([]byte(nil), exifHeader...), orientationTIFF(binary.LittleEndian, tag)...)
+			in := buildJPEG(t, 45, 27, map[byte][]byte{markerAPP1: exif})
+
+			var out bytes.Buffer
+			res, err := NormalizeWithOptions(bytes.NewReader(in), &out, &Options{Mode: ModeLossless, Trim: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Trimmed {
+				t.Fatal("image was not trimmed")
+			}
+
+			_, err = Verify(bytes.NewReader(in), bytes.NewReader(out.Bytes()), &VerifyOptions{Trimmed: true})
+			if err != nil {
+				t.Errorf("Verify with Trimmed: %v", err)
+			}
+			_, err = Verify(bytes.NewReader(in), bytes.NewReader(out.Bytes()), nil)
+			if err != VerificationError {
+				t.Errorf("Verify without Trimmed: got %v, want %v", err, VerificationError)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package exiflign
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var CaseCollisionError error = errors.New("The given file would be written to the same path as another on a case-insensitive file system.")
+
+// fileKey identifies a file independently of the paths leading to it.
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// linkedFile is a file NormalizeDir has normalized, to which later paths
+// leading to the same file are linked.
+type linkedFile struct {
+	out string
+	res *Result
+}
+
+// dirWalker performs the work of NormalizeDir.
+type dirWalker struct {
+	src, dst   string
+	opts       *FileOptions
+	duplicates *duplicateIndex
+
+	// dirs holds the directories walked so far under opts.FollowSymlinks,
+	// and links the files normalized so far that other paths may lead to.
+	dirs  []fs.FileInfo
+	links map[fileKey]linkedFile
+
+	// outputs maps the paths written to so far, other than in place, from
+	// their lower case form.
+	outputs map[string]string
+}
+
+// walk walks the tree rooted at root, whose paths are reported as though
+// they were under lexical, the path of the symbolic link that led to root or
+// root itself.
+func (w *dirWalker) walk(root, lexical string) error {
+	root = longPath(root)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		return w.visit(filepath.Join(lexical, rel), path, d)
+	})
+}
+
+// visit handles the entry d of the tree, found at path and reported as
+// lexical.
+func (w *dirWalker) visit(lexical, path string, d fs.DirEntry) error {
+	rel, err := filepath.Rel(w.src, lexical)
+	if err != nil {
+		return err
+	}
+	out := filepath.Join(w.dst, rel)
+
+	symlink := d.Type()&fs.ModeSymlink != 0
+	if symlink {
+		if !w.opts.FollowSymlinks {
+			return nil
+		}
+
+		// Dangling links and loops of links lead nowhere and are skipped.
+		path, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			return w.walk(path, lexical)
+		}
+		d = fs.FileInfoToDirEntry(info)
+		if out == lexical {
+			out = path
+		}
+	}
+
+	if d.IsDir() {
+		if w.opts.FollowSymlinks {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			for _, dir := range w.dirs {
+				if os.SameFile(dir, info) {
+					return fs.SkipDir
+				}
+			}
+			w.dirs = append(w.dirs, info)
+		}
+		if w.opts.OutputTemplate != "" {
+			return nil
+		}
+		return os.MkdirAll(longPath(out), 0755)
+	}
+	if !d.Type().IsRegular() || !isJPEGName(lexical) {
+		return nil
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	key, links, ok := identify(info)
+	track := ok && (links > 1 || w.opts.FollowSymlinks)
+	if first, found := w.links[key]; track && found {
+		return w.link(path, lexical, out, first)
+	}
+
+	if w.duplicates != nil {
+		// Files that are filtered out are kept out of the index, so that
+		// they never stand for the files they duplicate.
+		match, err := w.opts.Filter.Match(path)
+		if err != nil {
+			return err
+		}
+		if !match {
+			return w.report(lexical, out, nil, FilteredError)
+		}
+
+		original, err := w.duplicates.check(path)
+		if err != nil {
+			return err
+		}
+		if original != "" && w.opts.OnDuplicate != nil {
+			w.opts.OnDuplicate(lexical, original)
+		}
+		if original != "" && w.opts.Duplicates == DuplicatesSkip {
+			return w.report(lexical, out, nil, DuplicateError)
+		}
+	}
+
+	out, err = w.output(path, lexical, out)
+	if err != nil {
+		return err
+	}
+	out, err = w.resolve(path, lexical, out)
+	if err != nil {
+		return w.report(lexical, out, nil, err)
+	}
+
+	res, err := normalizePath(path, out, w.opts)
+	if err == nil && track {
+		w.track(key, out, res)
+	}
+
+	return w.report(lexical, out, res, err)
+}
+
+// track records that the file identified by key was normalized to out.  The
+// output is recorded under its own identity too, as it may take over that
+// of a file it replaced, so that paths leading to it later are not mistaken
+// for paths leading to the file whose identity it took over.
+func (w *dirWalker) track(key fileKey, out string, res *Result) {
+	first := linkedFile{out: out, res: res}
+	w.links[key] = first
+	if info, err := os.Stat(longPath(out)); err == nil {
+		if outKey, _, ok := identify(info); ok {
+			w.links[outKey] = first
+		}
+	}
+}
+
+// output returns the path the file at path, reported as lexical, is written
+// to, which is out unless opts.OutputTemplate says otherwise, creating its
+// directory if required.
+func (w *dirWalker) output(path, lexical, out string) (string, error) {
+	if w.opts.OutputTemplate == "" {
+		return out, nil
+	}
+
+	rel, err := ExpandOutputTemplate(w.opts.OutputTemplate, path)
+	if err != nil {
+		return "", err
+	}
+	out = filepath.Join(w.dst, rel)
+
+	return out, os.MkdirAll(longPath(filepath.Dir(out)), 0755)
+}
+
+// resolve returns the path the file at path, reported as lexical, is
+// actually written to instead of out under opts.Collisions, and claims it.
+func (w *dirWalker) resolve(path, lexical, out string) (string, error) {
+	resolved, err := w.opts.resolveOutput(path, out)
+	if err != nil {
+		return out, err
+	}
+
+	return resolved, w.claim(lexical, resolved)
+}
+
+// claim records that the file reported as lexical is written to out, and
+// returns CaseCollisionError if an earlier file was written to a path that
+// differs from out only in case, which is the same file on case-insensitive
+// file systems.  Files normalized in place cannot collide and are not
+// recorded.
+func (w *dirWalker) claim(lexical, out string) error {
+	if out == lexical {
+		return nil
+	}
+
+	folded := strings.ToLower(out)
+	if earlier, ok := w.outputs[folded]; ok && earlier != out {
+		return CaseCollisionError
+	}
+	w.outputs[folded] = out
+
+	return nil
+}
+
+// link makes out, the output of the file reported as lexical, a hard link to
+// the output of first, the same file reached through another path, unless
+// they are the same already.
+func (w *dirWalker) link(path, lexical, out string, first linkedFile) error {
+	out, err := w.output(path, lexical, out)
+	if err != nil {
+		return err
+	}
+
+	if outInfo, err := os.Stat(longPath(out)); err != nil || !sameFile(outInfo, first.out) {
+		out, err = w.resolve(path, lexical, out)
+		if err == nil {
+			err = linkFile(first.out, out)
+		}
+		if err != nil {
+			return w.report(lexical, out, nil, err)
+		}
+	}
+
+	return w.report(lexical, out, first.res, nil)
+}
+
+// report passes the outcome of the file reported as lexical to
+// opts.OnFile, and returns the error NormalizeDir should stop at, if any.
+func (w *dirWalker) report(lexical, out string, res *Result, err error) error {
+	if w.opts.OnFile != nil {
+		err = w.opts.OnFile(lexical, out, res, err)
+	}
+	if err == StampedError || err == QuarantinedError || err == DuplicateError || err == FilteredError || err == CaseCollisionError {
+		return nil
+	}
+	if err == OutputExistsError && w.opts.Collisions == CollisionSkip {
+		return nil
+	}
+
+	return err
+}
+
+// sameFile reports whether info describes the file at path.
+func sameFile(info fs.FileInfo, path string) bool {
+	other, err := os.Stat(longPath(path))
+	return err == nil && os.SameFile(info, other)
+}
+
+// linkFile replaces dst with a hard link to src, atomically.
+func linkFile(src, dst string) error {
+	src, dst = longPath(src), longPath(dst)
+	f, err := os.CreateTemp(filepath.Dir(dst), ".exiflign-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	os.Remove(tmp)
+
+	err = os.Link(src, tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return err
+}
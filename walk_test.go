@@ -0,0 +1,77 @@
+package exiflign
+
+import (
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeDirCaseCollision(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	files := []struct {
+		name, camera string
+		orientation  uint16
+	}{
+		{"a.jpg", "Canon", 6},
+		{"b.jpg", "CANON", 1},
+		{"c.jpg", "Nikon", 6},
+	}
+	for _, f := range files {
+		writeTestJPEG(t, filepath.Join(src, f.name), testIFD{
+			{tagMake, f.camera},
+			{tagOrientation, f.orientation},
+		})
+	}
+
+	errs := make(map[string]error)
+	opts := &FileOptions{
+		OutputTemplate: "{camera}{ext}",
+		OnFile: func(src, dst string, res *Result, err error) error {
+			errs[filepath.Base(src)] = err
+			return err
+		},
+	}
+	err := NormalizeDir(src, dst, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]error{"a.jpg": nil, "b.jpg": CaseCollisionError, "c.jpg": nil}
+	for name, werr := range want {
+		if got, ok := errs[name]; !ok || got != werr {
+			t.Errorf("%s: got %v, want %v", name, got, werr)
+		}
+	}
+
+	// The file that collided must not have replaced the one written first.
+	checkRotated(t, filepath.Join(dst, "Canon.jpg"))
+	checkRotated(t, filepath.Join(dst, "Nikon.jpg"))
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("dst holds %d files, want 2", len(entries))
+	}
+}
+
+// checkRotated fails t unless the file at path is an image written by
+// writeTestJPEG that was turned by a quarter turn.
+func checkRotated(t *testing.T, path string) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+	} else if cfg.Width != 16 || cfg.Height != 32 {
+		t.Errorf("%s: size %dx%d, want 16x32", path, cfg.Width, cfg.Height)
+	}
+}
@@ -0,0 +1,75 @@
+package exiflign
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// WebhookSignatureHeader is the header carrying the signature of a
+// notification sent with a Webhook.Secret, as "sha256=" followed by the hex
+// HMAC-SHA256 of the body.
+const WebhookSignatureHeader = "X-Exiflign-Signature"
+
+// Webhook notifies a downstream system, such as an indexer or a CDN, that
+// normalized images are ready, by POSTing JSON to a URL.  It is an AuditSink,
+// so setting it as Options.Audit sends the AuditRecord of every normalized
+// image.
+type Webhook struct {
+	// URL is the address notifications are POSTed to.
+	URL string
+
+	// Secret, if set, is the key the body of every notification is signed
+	// with, in WebhookSignatureHeader, so that the receiver can verify its
+	// origin.
+	Secret string
+
+	// Client is the HTTP client notifications are sent with.  If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Post sends v, encoded as JSON, to h.URL.  Any response other than a 2xx
+// status is an error.
+func (h *Webhook) Post(ctx context.Context, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Unexpected status %q while notifying %s.", resp.Status, h.URL)
+	}
+
+	return nil
+}
+
+// Record implements AuditSink.
+func (h *Webhook) Record(rec *AuditRecord) error {
+	return h.Post(context.Background(), rec)
+}
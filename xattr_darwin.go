@@ -0,0 +1,145 @@
+package exiflign
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+)
+
+// copyAttributes copies the extended attributes of the file at src, which
+// include its Finder tags and Finder info, to the file at dst.
+func copyAttributes(src, dst string) error {
+	names, err := listAttributes(src)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		value, err := getAttribute(src, name)
+		if err != nil {
+			return err
+		}
+		err = setAttribute(dst, name, value)
+		if err == syscall.EPERM || err == syscall.ENOTSUP {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listAttributes returns the names of the extended attributes of the file at
+// path, which has none on file systems without support for them.
+func listAttributes(path string) ([]string, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		size, _, errno := syscall.Syscall6(syscall.SYS_LISTXATTR, uintptr(unsafe.Pointer(p)), 0, 0, 0, 0, 0)
+		if errno == syscall.ENOTSUP {
+			return nil, nil
+		}
+		if errno != 0 {
+			return nil, errno
+		}
+		if size == 0 {
+			return nil, nil
+		}
+
+		buffer := make([]byte, size)
+		n, _, errno := syscall.Syscall6(syscall.SYS_LISTXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&buffer[0])), size, 0, 0, 0)
+		if errno == syscall.ERANGE {
+			// Attributes were added in the meantime.
+			continue
+		}
+		if errno != 0 {
+			return nil, errno
+		}
+
+		var names []string
+		for _, name := range bytes.Split(buffer[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+// getAttribute returns the value of the extended attribute name of the file
+// at path.
+func getAttribute(path, name string) ([]byte, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	a, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		size, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), 0, 0, 0, 0)
+		if errno != 0 {
+			return nil, errno
+		}
+		if size == 0 {
+			return nil, nil
+		}
+
+		value := make([]byte, size)
+		n, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(unsafe.Pointer(&value[0])), size, 0, 0)
+		if errno == syscall.ERANGE {
+			continue
+		}
+		if errno != 0 {
+			return nil, errno
+		}
+		return value[:n], nil
+	}
+}
+
+// setAttribute sets the extended attribute name of the file at path to
+// value.
+func setAttribute(path, name string, value []byte) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	a, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	var v unsafe.Pointer
+	if len(value) > 0 {
+		v = unsafe.Pointer(&value[0])
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(v), uintptr(len(value)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func setStamp(path string) error {
+	return setAttribute(path, StampAttr, []byte(StampValue))
+}
+
+func getStamp(path string) (bool, error) {
+	value, err := getAttribute(path, StampAttr)
+	if err == syscall.ENOATTR || err == syscall.ENOTSUP {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return string(value) == StampValue, nil
+}
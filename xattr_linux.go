@@ -0,0 +1,105 @@
+package exiflign
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// copyAttributes copies the extended attributes of the file at src to the
+// file at dst.  Attributes in namespaces the process may not write, such as
+// security and trusted, are left out.
+func copyAttributes(src, dst string) error {
+	names, err := listAttributes(src)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		value, err := getAttribute(src, name)
+		if err == syscall.ENODATA {
+			// The attribute was removed in the meantime.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = syscall.Setxattr(dst, name, value, 0)
+		if err == syscall.EPERM || err == syscall.ENOTSUP {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listAttributes returns the names of the extended attributes of the file at
+// path, which has none on file systems without support for them.
+func listAttributes(path string) ([]string, error) {
+	for {
+		size, err := syscall.Listxattr(path, nil)
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		buffer := make([]byte, size)
+		n, err := syscall.Listxattr(path, buffer)
+		if err == syscall.ERANGE {
+			// Attributes were added in the meantime.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, name := range bytes.Split(buffer[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+// getAttribute returns the value of the extended attribute name of the file
+// at path.
+func getAttribute(path, name string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		value := make([]byte, size)
+		n, err := syscall.Getxattr(path, name, value)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	}
+}
+
+func setStamp(path string) error {
+	return syscall.Setxattr(path, StampAttr, []byte(StampValue), 0)
+}
+
+func getStamp(path string) (bool, error) {
+	value, err := getAttribute(path, StampAttr)
+	if err == syscall.ENODATA || err == syscall.ENOTSUP {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return string(value) == StampValue, nil
+}
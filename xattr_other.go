@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+
+package exiflign
+
+func copyAttributes(src, dst string) error {
+	return AttributesUnsupportedError
+}
+
+func setStamp(path string) error {
+	return StampUnsupportedError
+}
+
+func getStamp(path string) (bool, error) {
+	return false, StampUnsupportedError
+}
@@ -0,0 +1,120 @@
+package exiflign
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32            = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStream = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStream  = kernel32.NewProc("FindNextStreamW")
+)
+
+// errorInvalidParameter is returned by FindFirstStreamW on file systems
+// without alternate data streams.
+const errorInvalidParameter syscall.Errno = 87
+
+// findStreamData is the WIN32_FIND_STREAM_DATA structure.
+type findStreamData struct {
+	size int64
+	name [syscall.MAX_PATH + 36]uint16
+}
+
+// copyAttributes copies the alternate data streams of the file at src, in
+// which desktop applications keep labels and other metadata, to the file at
+// dst.
+func copyAttributes(src, dst string) error {
+	names, err := listStreams(src)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = copyStream(src+":"+name, dst+":"+name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listStreams returns the names of the alternate data streams of the file at
+// path, which has none on file systems without support for them.
+func listStreams(path string) ([]string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data findStreamData
+	h, _, errno := procFindFirstStream.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		if errno == syscall.ERROR_HANDLE_EOF || errno == errorInvalidParameter {
+			return nil, nil
+		}
+		return nil, errno
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+
+	var names []string
+	for {
+		// Streams are named ":name:$DATA", and the unnamed one is the
+		// content of the file itself.
+		name := strings.TrimSuffix(strings.TrimPrefix(syscall.UTF16ToString(data.name[:]), ":"), ":$DATA")
+		if name != "" {
+			names = append(names, name)
+		}
+
+		ok, _, errno := procFindNextStream.Call(h, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if errno == syscall.ERROR_HANDLE_EOF {
+				return names, nil
+			}
+			return nil, errno
+		}
+	}
+}
+
+// copyStream copies the stream at src to dst.
+func copyStream(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// setStamp writes StampValue to the alternate data stream of the file at path
+// named after StampAttr.
+func setStamp(path string) error {
+	return os.WriteFile(path+":"+StampAttr, []byte(StampValue), 0644)
+}
+
+func getStamp(path string) (bool, error) {
+	value, err := os.ReadFile(path + ":" + StampAttr)
+	if os.IsNotExist(err) || errors.Is(err, errorInvalidParameter) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return string(value) == StampValue, nil
+}
@@ -0,0 +1,189 @@
+package exiflign
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// XMPExt is the extension of the XMP sidecars consulted under
+// FileOptions.XMPSidecar.
+const XMPExt = ".xmp"
+
+// xmpOrientationName is the qualified name of the XMP property holding the
+// orientation tag.
+var xmpOrientationName = []byte("tiff:Orientation")
+
+// XMPSidecarPath returns the path of the XMP sidecar of the image at path, and
+// whether it exists.  Digital asset management tools disagree on how to name
+// sidecars, so both "photo.jpg.xmp", as darktable writes, and "photo.xmp", as
+// Adobe tools write, are tried, in that order.  If neither exists, the first
+// is returned.
+func XMPSidecarPath(path string) (string, bool) {
+	candidates := []string{path + XMPExt, path[:len(path)-len(filepath.Ext(path))] + XMPExt}
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && info.Mode().IsRegular() {
+			return c, true
+		}
+	}
+
+	return candidates[0], false
+}
+
+// ReadXMPOrientation returns the tiff:Orientation property of the XMP sidecar
+// at path, whether written as an attribute or as an element.  NoExifError is
+// returned if the sidecar has no such property, or one outside the range 1 to
+// 8.
+func ReadXMPOrientation(path string) (uint16, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	tag, ok := xmpOrientation(data)
+	if !ok {
+		return 0, NoExifError
+	}
+
+	return tag, nil
+}
+
+// xmpOrientation returns the tiff:Orientation property of the XMP packet in
+// data, and whether it has a valid one.
+func xmpOrientation(data []byte) (uint16, bool) {
+	start, end, ok := xmpOrientationValue(data)
+	if !ok {
+		return 0, false
+	}
+
+	tag, err := strconv.ParseUint(string(bytes.TrimSpace(data[start:end])), 10, 16)
+	if err != nil || tag < 1 || tag > 8 {
+		return 0, false
+	}
+
+	return uint16(tag), true
+}
+
+// setXMPOrientation returns a copy of the XMP packet in data with its
+// tiff:Orientation property set to tag, and whether it has one to set.  The
+// rest of the packet is left byte for byte as it is.
+func setXMPOrientation(data []byte, tag uint16) ([]byte, bool) {
+	start, end, ok := xmpOrientationValue(data)
+	if !ok {
+		return nil, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:start]...)
+	out = strconv.AppendUint(out, uint64(tag), 10)
+	return append(out, data[end:]...), true
+}
+
+// xmpOrientationValue returns the bounds in data of the value of the first
+// tiff:Orientation property, written either as the attribute
+// tiff:Orientation="6" or as the element
+// <tiff:Orientation>6</tiff:Orientation>.
+func xmpOrientationValue(data []byte) (int, int, bool) {
+	offset := 0
+	for {
+		i := bytes.Index(data[offset:], xmpOrientationName)
+		if i < 0 {
+			return 0, 0, false
+		}
+		i += offset
+		offset = i + len(xmpOrientationName)
+
+		if i > 0 && data[i-1] == '<' {
+			if offset >= len(data) || data[offset] != '>' {
+				continue
+			}
+			end := bytes.IndexByte(data[offset+1:], '<')
+			if end < 0 {
+				return 0, 0, false
+			}
+			return offset + 1, offset + 1 + end, true
+		}
+
+		j := offset
+		for j < len(data) && isXMLSpace(data[j]) {
+			j++
+		}
+		if j >= len(data) || data[j] != '=' {
+			continue
+		}
+		for j++; j < len(data) && isXMLSpace(data[j]); j++ {
+		}
+		if j >= len(data) || (data[j] != '"' && data[j] != '\'') {
+			continue
+		}
+		end := bytes.IndexByte(data[j+1:], data[j])
+		if end < 0 {
+			return 0, 0, false
+		}
+		return j + 1, j + 1 + end, true
+	}
+}
+
+// isXMLSpace reports whether b is whitespace in XML.
+func isXMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// xmpSidecar is the XMP sidecar of an image being normalized under
+// FileOptions.XMPSidecar.
+type xmpSidecar struct {
+	// path is where the sidecar was found, and adobe whether it is named
+	// after the image without its extension.
+	path  string
+	adobe bool
+
+	// data is the content of the sidecar, perm its permissions, and tag its
+	// orientation, or 0 if it has none.
+	data []byte
+	perm os.FileMode
+	tag  uint16
+}
+
+// readXMPSidecar reads the XMP sidecar of the image at src, returning nil if
+// it has none.
+func readXMPSidecar(src string) (*xmpSidecar, error) {
+	path, ok := XMPSidecarPath(src)
+	if !ok {
+		return nil, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &xmpSidecar{path: path, adobe: path != src+XMPExt, data: data, perm: info.Mode().Perm()}
+	s.tag, _ = xmpOrientation(data)
+	return s, nil
+}
+
+// write writes the sidecar next to the normalized image at dst, named like
+// the original one, with its orientation reset to 1 now that the image is
+// stored upright.  A sidecar without an orientation is only copied when dst
+// is not the original image.
+func (s *xmpSidecar) write(dst string) error {
+	path := dst + XMPExt
+	if s.adobe {
+		path = dst[:len(dst)-len(filepath.Ext(dst))] + XMPExt
+	}
+
+	data, ok := setXMPOrientation(s.data, 1)
+	if !ok || s.tag == 1 {
+		if path == s.path {
+			return nil
+		}
+		data = s.data
+	}
+
+	return replaceFile(path, data, s.perm)
+}